@@ -1,16 +1,14 @@
 package main
 
 import (
-	"database/sql"
 	"log"
 
 	"os"
+	"strconv"
+	"strings"
 	"test/config"
 	"test/run"
 
-	"test/internal/models"
-
-	"github.com/brianvoe/gofakeit/v6"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
@@ -22,89 +20,125 @@ func init() {
 	}
 }
 
-func generateData() {
-	db, err := sql.Open("postgres", os.Getenv("DB_DSN"))
-	if err != nil {
-		log.Fatal(err)
+func newConfig() *config.Config {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "postgres"
 	}
-	defer db.Close()
-	gofakeit.Seed(0)
-	pword := models.Password{}
-	pword.Set("123456789")
-	for i := 0; i <= 100; i++ {
-		user := &models.User{
-			Name:     gofakeit.Name(),
-			Email:    gofakeit.Email(),
-			Password: pword,
-			Deleted:  false,
-		}
-		query := `
-        INSERT INTO users (name, email, password_hash, deleted) 
-        VALUES ($1, $2, $3, $4)
-		RETURNING id, version
-       `
-
-		args := []any{user.Name, user.Email, user.Password.Hash, user.Deleted}
 
-		err := db.QueryRow(query, args...).Scan(&user.ID, &user.Version)
-		if err != nil {
-			log.Fatal(err)
-		}
+	opts := []config.Option{
+		config.WithPort(8080),
+		config.WithEnv(os.Getenv("APP_ENV")),
+		config.WithDBname(driver),
+		config.WithDSN(os.Getenv("DB_DSN")),
+		config.WithQueryTimeout(os.Getenv("DB_QUERY_TIMEOUT")),
+		config.WithAutoMigrate(os.Getenv("DB_AUTO_MIGRATE") == "true"),
+		config.WithLoanPeriod(os.Getenv("LOAN_PERIOD")),
+		config.WithFineGracePeriod(os.Getenv("FINE_GRACE_PERIOD")),
+		config.WithOverdueCheckInterval(os.Getenv("OVERDUE_CHECK_INTERVAL")),
+		config.WithPickupWindow(os.Getenv("PICKUP_WINDOW")),
+		config.WithHoldCheckInterval(os.Getenv("HOLD_CHECK_INTERVAL")),
+		config.WithTokenAlgorithm(os.Getenv("JWT_ALGORITHM")),
+		config.WithSigningKey(os.Getenv("JWT_KEY_FILE"), os.Getenv("JWT_KEY_ID")),
+		config.WithTokenTTL(os.Getenv("JWT_TOKEN_TTL")),
+		config.WithSessionTTL(os.Getenv("JWT_SESSION_TTL")),
+		config.WithLockoutPeriod(os.Getenv("LOGIN_LOCKOUT_PERIOD")),
+		config.WithResetURL(os.Getenv("PASSWORD_RESET_URL")),
+		config.WithResetTTL(os.Getenv("PASSWORD_RESET_TTL")),
+		config.WithMailFile(os.Getenv("MAIL_FILE")),
+		config.WithMailFrom(os.Getenv("MAIL_FROM")),
 	}
-
-	for i := 0; i <= 10; i++ {
-		author := &models.Author{
-			Name: gofakeit.Name(),
-		}
-		query := `
-        INSERT INTO authors (name) 
-        VALUES ($1)
-		RETURNING id
-        `
-		args := []any{author.Name}
-
-		err := db.QueryRow(query, args...).Scan(&author.ID)
-		if err != nil {
-			log.Fatal(err)
-		}
+	opts = append(opts, verifyKeys(os.Getenv("JWT_VERIFY_KEYS"))...)
+	if n, ok := lookupIntEnv("FINE_DAILY_RATE"); ok {
+		opts = append(opts, config.WithDailyFine(n))
+	}
+	if n, ok := lookupIntEnv("FINE_CAP"); ok {
+		opts = append(opts, config.WithFineCap(n))
+	}
+	if n, ok := lookupIntEnv("FINE_BLOCK_THRESHOLD"); ok {
+		opts = append(opts, config.WithFineThreshold(n))
+	}
+	if n, ok := lookupIntEnv("LOGIN_MAX_FAILURES"); ok {
+		opts = append(opts, config.WithMaxLoginFailures(n))
+	}
+	if n, ok := lookupIntEnv("LOGIN_MAX_ADDRESS_FAILURES"); ok {
+		opts = append(opts, config.WithMaxAddressFailures(n))
 	}
 
-	for i := 0; i <= 100; i++ {
-		book := &models.Book{
-			Title: gofakeit.BookTitle(),
-			Year:  gofakeit.Date().Year(),
-			Author: &models.Author{
-				ID: int64(gofakeit.Number(1, 10)),
-			},
+	return config.NewConfig(opts...)
+}
+
+// verifyKeys reads the retired keys that still verify tokens, listed as
+// kid=file pairs separated by commas.
+func verifyKeys(value string) []config.Option {
+	var opts []config.Option
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
 		}
-		query := `
-	    INSERT INTO books (year, title, author_id)
-	    VALUES ($1, $2, $3)
-	    RETURNING id
-	    `
-		args := []any{book.Year, book.Title, book.Author.ID}
-
-		err := db.QueryRow(query, args...).Scan(&book.ID)
-		if err != nil {
-			log.Fatal(err)
+		id, file, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || file == "" {
+			log.Printf("ignoring verify key %q, expected kid=file", pair)
+			continue
 		}
+		opts = append(opts, config.WithVerifyKey(id, file))
 	}
+	return opts
+}
 
+// lookupIntEnv reads a numeric variable and reports whether it was set, for
+// the settings where 0 isn't the default but turns something off.
+func lookupIntEnv(name string) (int, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("ignoring %s=%q: %v", name, value, err)
+		return 0, false
+	}
+	return n, true
 }
 
 func main() {
 
-	generateData()
-
-	cfg := config.NewConfig(config.WithPort(8080), config.WithDBname("postgres"), config.WithDSN(os.Getenv("DB_DSN")))
+	cfg := newConfig()
 
 	logger, err := zap.NewProduction()
 	if err != nil {
 		panic(err)
 	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg, logger, os.Stdout, os.Args[2:]); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := seed(cfg, logger, os.Stdout, os.Args[2:]); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "promote" {
+		if err := promote(cfg, logger, os.Stdout, os.Args[2:]); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	app := run.NewApp(cfg, logger)
 
 	app.Run()
+
 	err = app.Serve()
 	logger.Error(err.Error())
 	os.Exit(1)
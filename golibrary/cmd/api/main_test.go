@@ -26,3 +26,12 @@ func TestMainfunc(t *testing.T) {
 	time.Sleep(2 * time.Second)
 	t.Log("main finished")
 }
+
+func TestNewConfigDailyFine(t *testing.T) {
+	for value, want := range map[string]int{"": 25, "50": 50, "0": 0, "lots": 25} {
+		t.Setenv("FINE_DAILY_RATE", value)
+		if got := newConfig().Library.DailyFine; got != want {
+			t.Errorf("FINE_DAILY_RATE=%q: expected %d, got %d", value, want, got)
+		}
+	}
+}
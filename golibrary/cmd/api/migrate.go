@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"test/config"
+	"test/internal/db"
+	"test/internal/db/migrations"
+
+	"go.uber.org/zap"
+)
+
+var errMigrateUsage = errors.New("usage: migrate up | down [N] | status | goto N")
+
+// migrate runs the migrate subcommand against the configured database.
+func migrate(cfg *config.Config, logger *zap.Logger, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	dbx, err := db.NewSqlDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if dbx == nil {
+		return fmt.Errorf("%s has no schema to migrate", cfg.Db.DBname)
+	}
+	defer dbx.Close()
+
+	migrator, err := migrations.New(dbx)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return errMigrateUsage
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return errMigrateUsage
+		}
+		return migrator.Goto(ctx, version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	}
+
+	return errMigrateUsage
+}
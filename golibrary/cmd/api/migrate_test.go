@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"test/config"
+
+	"go.uber.org/zap"
+)
+
+func TestMigrate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "library.db")
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(dsn))
+
+	var out bytes.Buffer
+	if err := migrate(cfg, zap.NewNop(), &out, []string{"status"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "pending") {
+		t.Errorf("expected pending migrations, got %s", out.String())
+	}
+
+	if err := migrate(cfg, zap.NewNop(), &out, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := migrate(cfg, zap.NewNop(), &out, []string{"status"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "pending") {
+		t.Errorf("expected every migration to be applied, got %s", out.String())
+	}
+
+	if err := migrate(cfg, zap.NewNop(), &out, []string{"goto", "0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(cfg, zap.NewNop(), &out, []string{"sideways"}); err != errMigrateUsage {
+		t.Errorf("expected usage error, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"test/config"
+	"test/internal/db"
+	"test/internal/models"
+	users "test/internal/modules/user/repository"
+	"test/internal/modules/user/service"
+
+	"go.uber.org/zap"
+)
+
+var errPromoteUsage = errors.New("usage: promote [-role admin|librarian|member] NAME")
+
+// promote sets the role of a registered user. Only admins can change roles
+// over the API, so this is how a new database gets its first admin.
+func promote(cfg *config.Config, logger *zap.Logger, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("promote", flag.ContinueOnError)
+	flags.SetOutput(out)
+	role := flags.String("role", models.RoleAdmin, "role to give the user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || !slices.Contains(models.UserRoles, *role) {
+		return errPromoteUsage
+	}
+	name := flags.Arg(0)
+
+	dbx, err := db.NewSqlDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if dbx == nil {
+		return fmt.Errorf("%s keeps no users between runs, there is nobody to promote", cfg.Db.DBname)
+	}
+	defer dbx.Close()
+
+	ctx := context.Background()
+	// a demotion ends the user's sessions like it does over the API
+	userService := service.NewUserService(users.NewUserModel(dbx, 0), service.Policy{}, nil)
+
+	user, err := userService.GetUserByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, users.ErrRecordNotFound) {
+			return fmt.Errorf("no user is named %q", name)
+		}
+		return err
+	}
+	if err := userService.SetRole(ctx, user, *role); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%q is a %s\n", user.Name, user.Role)
+	return nil
+}
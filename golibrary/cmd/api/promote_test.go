@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"test/config"
+	"test/internal/db"
+	"test/internal/models"
+	users "test/internal/modules/user/repository"
+
+	"go.uber.org/zap"
+)
+
+func TestPromote(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "library.db")
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(dsn))
+	if err := migrate(cfg, zap.NewNop(), &bytes.Buffer{}, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dbx, err := db.NewSqlDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	storage := users.NewUserModel(dbx, 0)
+	ctx := context.Background()
+	if err := storage.Insert(ctx, &models.User{Name: "carl", Email: "carl@example.com", Password: models.Password{Hash: []byte("hash")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	role := func() string {
+		t.Helper()
+		user, err := storage.GetByName(ctx, "carl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Role
+	}
+
+	var out bytes.Buffer
+	if err := promote(cfg, zap.NewNop(), &out, []string{"carl"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := role(); got != models.RoleAdmin {
+		t.Errorf("expected an admin, got %s", got)
+	}
+	if err := promote(cfg, zap.NewNop(), &out, []string{"-role", "librarian", "carl"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := role(); got != models.RoleLibrarian {
+		t.Errorf("expected a librarian, got %s", got)
+	}
+
+	if err := promote(cfg, zap.NewNop(), &out, []string{"nobody"}); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+	for _, args := range [][]string{{}, {"-role", "janitor", "carl"}, {"carl", "sweet"}} {
+		if err := promote(cfg, zap.NewNop(), &out, args); err != errPromoteUsage {
+			t.Errorf("%q: expected usage error, got %v", args, err)
+		}
+	}
+}
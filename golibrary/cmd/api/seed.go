@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/config"
+	"test/internal/db"
+	"test/internal/infrastructure/validator"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	books "test/internal/modules/books/repository"
+	"test/internal/modules/books/service"
+	users "test/internal/modules/user/repository"
+	"test/run"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// seedTables lists the seeded tables, and the ones referencing them that
+// truncating has to empty too, children before their parents.
+var seedTables = []string{"sessions", "password_resets", "holds", "fines", "rented", "copies", "book_genres", "book_contributors", "books", "authors", "users"}
+
+type seedOptions struct {
+	users    int
+	authors  int
+	books    int
+	copies   int
+	rentals  int
+	seed     int64
+	truncate bool
+	password string
+	policy   service.Policy
+}
+
+// seed fills the configured database with reproducible demo data. The same
+// seed and counts always produce the same rows, only the rental dates move
+// along with the day of seeding. A database that already has users is left
+// alone unless -truncate is given.
+func seed(cfg *config.Config, logger *zap.Logger, out io.Writer, args []string) error {
+	var opts seedOptions
+
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.IntVar(&opts.users, "users", 100, "number of users")
+	flags.IntVar(&opts.authors, "authors", 10, "number of authors")
+	flags.IntVar(&opts.books, "books", 100, "number of books")
+	flags.IntVar(&opts.copies, "copies", 3, "most copies of a book, every book has at least one")
+	flags.IntVar(&opts.rentals, "rentals", 200, "number of rentals to play through")
+	flags.Int64Var(&opts.seed, "seed", 1, "random seed, the same seed gives the same data")
+	flags.BoolVar(&opts.truncate, "truncate", false, "delete all users, authors, books and rentals first")
+	flags.StringVar(&opts.password, "password", "", "password shared by every user, random per user when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.users < 0 || opts.authors < 0 || opts.books < 0 || opts.rentals < 0 || opts.copies < 0 {
+		return errors.New("seed counts must not be negative")
+	}
+	if opts.books > 0 && opts.authors == 0 {
+		return errors.New("books need at least one author")
+	}
+	opts.copies = max(opts.copies, 1)
+
+	policy, err := run.Policy(cfg)
+	if err != nil {
+		return err
+	}
+	opts.policy = policy
+
+	dbx, err := db.NewSqlDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if dbx == nil {
+		return fmt.Errorf("%s keeps no data between runs, there is nothing to seed", cfg.Db.DBname)
+	}
+	defer dbx.Close()
+
+	ctx := context.Background()
+
+	if err := db.Migrate(ctx, dbx); err != nil {
+		return err
+	}
+
+	if opts.truncate {
+		if err := truncate(ctx, dbx); err != nil {
+			return err
+		}
+	} else {
+		var count int
+		if err := dbx.GetContext(ctx, &count, "SELECT count(*) FROM users"); err != nil {
+			return err
+		}
+		if count > 0 {
+			fmt.Fprintln(out, "database already has data, run seed -truncate to replace it")
+			return nil
+		}
+	}
+
+	return generate(ctx, dbx, logger, out, opts)
+}
+
+func truncate(ctx context.Context, dbx *sqlx.DB) error {
+	switch dbx.DriverName() {
+	case "postgres":
+		_, err := dbx.ExecContext(ctx, "TRUNCATE "+strings.Join(seedTables, ", ")+" RESTART IDENTITY CASCADE")
+		return err
+	case "mysql":
+		// Only TRUNCATE restarts AUTO_INCREMENT. It refuses tables other
+		// tables reference unless the foreign key checks are off, which
+		// holds for one connection only.
+		conn, err := dbx.Connx(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+		for _, table := range seedTables {
+			if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE "+table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// sqlite hands out the ids of an emptied table from 1 again
+	return db.WithTx(ctx, dbx, func(tx *sqlx.Tx) error {
+		for _, table := range seedTables {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func generate(ctx context.Context, dbx *sqlx.DB, logger *zap.Logger, out io.Writer, opts seedOptions) error {
+	faker := gofakeit.New(opts.seed)
+	userStorage := users.NewUserModel(dbx, 0)
+	bookStorage := books.NewBookStorage(dbx, logger, 0)
+	bookService := service.NewBookService(bookStorage, opts.policy)
+
+	var shared models.Password
+	if opts.password != "" {
+		if err := shared.Set(opts.password); err != nil {
+			return err
+		}
+	}
+
+	// the first users run the library, so the guarded routes can be tried
+	// out with -password
+	staff := []string{models.RoleAdmin, models.RoleLibrarian}
+
+	userIDs := make([]int64, 0, opts.users)
+	for i := 0; i < opts.users; i++ {
+		// the index keeps names and emails unique, the database is empty
+		name := fmt.Sprintf("%s.%s%d", handle(faker.FirstName()), handle(faker.LastName()), i+1)
+		user := &models.User{Name: name, Email: name + "@example.com", Password: shared}
+		if i < len(staff) {
+			user.Role = staff[i]
+		}
+		if opts.password == "" {
+			// Nobody knows these passwords, the cheapest cost keeps seeding fast.
+			hash, err := bcrypt.GenerateFromPassword([]byte(faker.Password(true, true, true, true, false, 16)), bcrypt.MinCost)
+			if err != nil {
+				return err
+			}
+			user.Password.Hash = hash
+		}
+
+		if err := userStorage.Insert(ctx, user); err != nil {
+			return err
+		}
+		userIDs = append(userIDs, user.ID)
+		if user.Role != models.RoleMember {
+			fmt.Fprintf(out, "%s %q is a %s\n", user.Email, user.Name, user.Role)
+		}
+	}
+
+	authorIDs := make([]int64, 0, opts.authors)
+	for i := 0; i < opts.authors; i++ {
+		author := &models.Author{Name: faker.Name()}
+		if err := bookStorage.CreateAuthor(ctx, author); err != nil {
+			return err
+		}
+		authorIDs = append(authorIDs, author.ID)
+	}
+
+	bookIDs := make([]int64, 0, opts.books)
+	copies := 0
+	for len(bookIDs) < opts.books {
+		book := &models.Book{
+			Title:       faker.BookTitle(),
+			Year:        faker.Number(1850, 2024),
+			Author:      &models.Author{ID: authorIDs[faker.Number(0, len(authorIDs)-1)]},
+			ISBN:        fakeISBN(faker),
+			Publisher:   faker.Company(),
+			Language:    "en",
+			Pages:       faker.Number(80, 900),
+			Description: faker.Sentence(12),
+			Genres:      []string{faker.BookGenre(), faker.BookGenre()},
+		}
+		// every fifth book or so is a translation
+		if translator := authorIDs[faker.Number(0, len(authorIDs)-1)]; translator != book.Author.ID && faker.Number(1, 5) == 1 {
+			book.Contributors = []models.Contributor{
+				{Author: book.Author},
+				{Author: &models.Author{ID: translator}, Role: models.RoleTranslator},
+			}
+		}
+		v := validator.New()
+		if service.ValidateBook(v, book); !v.Valid() {
+			return v.Err()
+		}
+		err := bookService.CreateBook(ctx, book)
+		if errors.Is(err, book_errors.ErrDuplicateISBN) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for n := faker.Number(1, opts.copies); n > 0; n-- {
+			copies++
+			copy := &models.Copy{BookID: book.ID, Barcode: fmt.Sprintf("SD%08d", copies)}
+			if err := bookService.AddCopy(ctx, copy); err != nil {
+				return err
+			}
+		}
+		bookIDs = append(bookIDs, book.ID)
+	}
+
+	// Play the rentals through the service so the copies, availability flags
+	// and the authors' order counts end up consistent. When all copies of a
+	// book are out its longest reader returns one before the next reader
+	// takes it. The rentals are spread over the year before today, the
+	// latest ones are still out, and late returns are fined by the
+	// configured policy. Rentals the service refuses are left out.
+	clock := time.Now().UTC().Truncate(24*time.Hour).AddDate(-1, 0, 0)
+	bookService.SetClock(func() time.Time { return clock })
+	maxStep := max(2*int(365*24*time.Hour/time.Minute)/max(opts.rentals, 1), 1)
+
+	readers := map[int64][]int64{}
+	rented, stillOut := 0, 0
+	if len(userIDs) > 0 && len(bookIDs) > 0 {
+		for i := 0; i < opts.rentals; i++ {
+			clock = clock.Add(time.Duration(faker.Number(1, maxStep)) * time.Minute)
+			bookID := bookIDs[faker.Number(0, len(bookIDs)-1)]
+			userID := userIDs[faker.Number(0, len(userIDs)-1)]
+
+			err := bookService.RentBook(ctx, bookID, userID)
+			if errors.Is(err, book_errors.ErrRentInvalid) && len(readers[bookID]) > 0 && !slices.Contains(readers[bookID], userID) {
+				if err := bookService.ReturnBook(ctx, bookID, readers[bookID][0]); err != nil {
+					return err
+				}
+				readers[bookID] = readers[bookID][1:]
+				stillOut--
+				err = bookService.RentBook(ctx, bookID, userID)
+			}
+			if errors.Is(err, book_errors.ErrRentInvalid) || errors.Is(err, book_errors.ErrRentBlocked) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			readers[bookID] = append(readers[bookID], userID)
+			rented++
+			stillOut++
+		}
+	}
+
+	// charge the books that are still out as the overdue job would today
+	clock = time.Now().UTC()
+	overdue, err := bookService.AccrueFines(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "seeded %d users, %d authors, %d books with %d copies, %d rentals (%d still out, %d overdue) with seed %d\n",
+		len(userIDs), len(authorIDs), len(bookIDs), copies, rented, stillOut, overdue, opts.seed)
+	return nil
+}
+
+// handle lowercases a made up name and drops everything but letters and
+// digits, so it fits a login name and an email.
+func handle(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, name)
+}
+
+// fakeISBN makes up an ISBN-13, the check digit is found by trying them all.
+func fakeISBN(faker *gofakeit.Faker) string {
+	prefix := fmt.Sprintf("978%09d", faker.Number(0, 999_999_999))
+	for digit := 0; ; digit++ {
+		if isbn, ok := models.NormalizeISBN(prefix + strconv.Itoa(digit)); ok {
+			return isbn
+		}
+	}
+}
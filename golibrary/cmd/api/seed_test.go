@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"test/config"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+func TestSeed(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "library.db")
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(dsn))
+	args := []string{"-users", "5", "-authors", "3", "-books", "8", "-rentals", "20", "-seed", "42"}
+
+	var out bytes.Buffer
+	if err := seed(cfg, zap.NewNop(), &out, args); err != nil {
+		t.Fatal(err)
+	}
+
+	dbx, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+
+	snapshot := func() (emails []string, rented, credits, ordered int) {
+		t.Helper()
+		if err := dbx.Select(&emails, "SELECT email FROM users ORDER BY id"); err != nil {
+			t.Fatal(err)
+		}
+		if err := dbx.Get(&rented, "SELECT count(*) FROM rented"); err != nil {
+			t.Fatal(err)
+		}
+		// every rental counts an order for each author credited on the book
+		if err := dbx.Get(&credits, `SELECT count(*) FROM rented
+			INNER JOIN (SELECT DISTINCT book_id, author_id FROM book_contributors) credits ON credits.book_id = rented.book_id`); err != nil {
+			t.Fatal(err)
+		}
+		if err := dbx.Get(&ordered, "SELECT sum(times_ordered) FROM authors"); err != nil {
+			t.Fatal(err)
+		}
+		return emails, rented, credits, ordered
+	}
+
+	emails, rented, credits, ordered := snapshot()
+	if len(emails) != 5 || rented == 0 || credits < rented || ordered != credits {
+		t.Fatalf("unexpected seed: %d users, %d rented, %d credits, %d orders", len(emails), rented, credits, ordered)
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf(" %d rentals ", rented)) {
+		t.Errorf("expected the summary to count the %d rentals made, got %q", rented, out.String())
+	}
+
+	var staff []string
+	if err := dbx.Select(&staff, "SELECT role FROM users WHERE role <> 'member' ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(staff, []string{"admin", "librarian"}) {
+		t.Errorf("expected an admin and a librarian, got %v", staff)
+	}
+
+	var returned int
+	if err := dbx.Get(&returned, "SELECT count(*) FROM rented WHERE returned_at IS NOT NULL"); err != nil {
+		t.Fatal(err)
+	}
+	if returned == 0 || returned == rented {
+		t.Errorf("expected both returned and open rentals, got %d of %d returned", returned, rented)
+	}
+
+	var fined int
+	if err := dbx.Get(&fined, "SELECT count(*) FROM fines WHERE kind = 'fine' AND amount > 0"); err != nil {
+		t.Fatal(err)
+	}
+	if fined == 0 {
+		t.Error("expected late returns to be fined")
+	}
+
+	out.Reset()
+	if err := seed(cfg, zap.NewNop(), &out, args); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "already has data") {
+		t.Errorf("expected the second run to be skipped, got %q", out.String())
+	}
+
+	// a login keeps a session referencing its user
+	if _, err := dbx.Exec(`INSERT INTO sessions (user_id, token_hash, created_at, expires_at)
+		SELECT id, 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP FROM users LIMIT 1`); err != nil {
+		t.Fatal(err)
+	}
+	// and so does a password reset
+	if _, err := dbx.Exec(`INSERT INTO password_resets (user_id, token_hash, created_at, expires_at)
+		SELECT id, 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP FROM users LIMIT 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := seed(cfg, zap.NewNop(), &out, append(args, "-truncate")); err != nil {
+		t.Fatal(err)
+	}
+	reseeded, rentedAgain, _, orderedAgain := snapshot()
+	if !reflect.DeepEqual(emails, reseeded) || rented != rentedAgain || ordered != orderedAgain {
+		t.Errorf("expected the same seed to give the same data, got %v and %v", emails, reseeded)
+	}
+	var lastID int64
+	if err := dbx.Get(&lastID, "SELECT max(id) FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if lastID != int64(len(reseeded)) {
+		t.Errorf("expected truncating to restart the ids, the last user has id %d", lastID)
+	}
+
+	if err := seed(cfg, zap.NewNop(), &out, []string{"-users", "-1"}); err == nil {
+		t.Error("expected negative counts to be rejected")
+	}
+}
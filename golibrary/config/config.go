@@ -9,6 +9,53 @@ type Config struct {
 		MaxOpenConns int
 		MaxIdleConns int
 		MaxIdleTime  string
+		QueryTimeout string
+		AutoMigrate  bool
+	}
+	Library struct {
+		LoanPeriod string
+		// fines are in cents, a daily fine of 0 or less turns them off
+		DailyFine       int
+		FineGracePeriod string
+		// FineCap limits the fine of a rental, FineThreshold is the balance
+		// above which a patron can't rent, 0 turns either off
+		FineCap              int
+		FineThreshold        int
+		OverdueCheckInterval string
+		PickupWindow         string
+		HoldCheckInterval    string
+	}
+	Auth struct {
+		// Algorithm signs the tokens, HS256, RS256 or EdDSA
+		Algorithm string
+		// KeyFile holds the signing key, the secret itself for HS256 and a
+		// PEM private key otherwise. Without one a key is made up at start,
+		// which outside production is fine as long as tokens needn't
+		// survive a restart.
+		KeyFile string
+		KeyID   string
+		// VerifyKeys are the files of retired keys by key id, tokens they
+		// signed are accepted until they expire
+		VerifyKeys map[string]string
+		// TokenTTL is how long an access token is valid, SessionTTL how long
+		// a refresh token is, every refresh starts the latter over
+		TokenTTL   string
+		SessionTTL string
+		// MaxLoginFailures failed logins of an account, MaxAddressFailures
+		// from one client address, within LockoutPeriod lock them out for
+		// the period, 0 doesn't limit
+		MaxLoginFailures   int
+		MaxAddressFailures int
+		LockoutPeriod      string
+		// ResetURL is the page password reset mails link to with the token
+		// appended, ResetTTL how long the token is valid
+		ResetURL string
+		ResetTTL string
+	}
+	Mail struct {
+		// File collects the outgoing mail in place of a mail server
+		File string
+		From string
 	}
 }
 
@@ -16,6 +63,13 @@ type Option func(с *Config)
 
 func NewConfig(opts ...Option) *Config {
 	config := &Config{}
+	// 0 is a setting of its own for these, their defaults go in before the
+	// options instead of replacing zeros after them
+	config.Library.DailyFine = 25
+	config.Library.FineCap = 2000
+	config.Library.FineThreshold = 1000
+	config.Auth.MaxLoginFailures = 5
+	config.Auth.MaxAddressFailures = 20
 	for _, opt := range opts {
 		opt(config)
 	}
@@ -47,6 +101,62 @@ func NewConfig(opts ...Option) *Config {
 	if config.Db.MaxIdleTime == "" {
 		config.Db.MaxIdleTime = "15m"
 	}
+
+	if config.Db.QueryTimeout == "" {
+		config.Db.QueryTimeout = "3s"
+	}
+
+	if config.Library.LoanPeriod == "" {
+		config.Library.LoanPeriod = "336h"
+	}
+
+	if config.Library.FineGracePeriod == "" {
+		config.Library.FineGracePeriod = "24h"
+	}
+
+	if config.Library.OverdueCheckInterval == "" {
+		config.Library.OverdueCheckInterval = "1h"
+	}
+
+	if config.Library.PickupWindow == "" {
+		config.Library.PickupWindow = "72h"
+	}
+
+	if config.Library.HoldCheckInterval == "" {
+		config.Library.HoldCheckInterval = "15m"
+	}
+
+	if config.Auth.Algorithm == "" {
+		config.Auth.Algorithm = "HS256"
+	}
+
+	if config.Auth.TokenTTL == "" {
+		config.Auth.TokenTTL = "15m"
+	}
+
+	if config.Auth.SessionTTL == "" {
+		config.Auth.SessionTTL = "720h"
+	}
+
+	if config.Auth.LockoutPeriod == "" {
+		config.Auth.LockoutPeriod = "15m"
+	}
+
+	if config.Auth.ResetURL == "" {
+		config.Auth.ResetURL = "http://localhost:8080/reset-password"
+	}
+
+	if config.Auth.ResetTTL == "" {
+		config.Auth.ResetTTL = "1h"
+	}
+
+	if config.Mail.File == "" {
+		config.Mail.File = "outbox.txt"
+	}
+
+	if config.Mail.From == "" {
+		config.Mail.From = "library@localhost"
+	}
 	return config
 }
 
@@ -77,3 +187,99 @@ func WithMaxIdleConns(maxIdleConns int) Option {
 func WithMaxIdleTime(maxIdleTime string) Option {
 	return func(c *Config) { c.Db.MaxIdleTime = maxIdleTime }
 }
+
+func WithQueryTimeout(queryTimeout string) Option {
+	return func(c *Config) { c.Db.QueryTimeout = queryTimeout }
+}
+
+func WithAutoMigrate(autoMigrate bool) Option {
+	return func(c *Config) { c.Db.AutoMigrate = autoMigrate }
+}
+
+func WithLoanPeriod(loanPeriod string) Option {
+	return func(c *Config) { c.Library.LoanPeriod = loanPeriod }
+}
+
+func WithDailyFine(dailyFine int) Option {
+	return func(c *Config) { c.Library.DailyFine = dailyFine }
+}
+
+func WithFineGracePeriod(gracePeriod string) Option {
+	return func(c *Config) { c.Library.FineGracePeriod = gracePeriod }
+}
+
+func WithFineCap(fineCap int) Option {
+	return func(c *Config) { c.Library.FineCap = fineCap }
+}
+
+func WithFineThreshold(threshold int) Option {
+	return func(c *Config) { c.Library.FineThreshold = threshold }
+}
+
+func WithOverdueCheckInterval(interval string) Option {
+	return func(c *Config) { c.Library.OverdueCheckInterval = interval }
+}
+
+func WithPickupWindow(window string) Option {
+	return func(c *Config) { c.Library.PickupWindow = window }
+}
+
+func WithHoldCheckInterval(interval string) Option {
+	return func(c *Config) { c.Library.HoldCheckInterval = interval }
+}
+
+func WithTokenAlgorithm(algorithm string) Option {
+	return func(c *Config) { c.Auth.Algorithm = algorithm }
+}
+
+func WithSigningKey(file, id string) Option {
+	return func(c *Config) {
+		c.Auth.KeyFile = file
+		c.Auth.KeyID = id
+	}
+}
+
+func WithVerifyKey(id, file string) Option {
+	return func(c *Config) {
+		if c.Auth.VerifyKeys == nil {
+			c.Auth.VerifyKeys = make(map[string]string)
+		}
+		c.Auth.VerifyKeys[id] = file
+	}
+}
+
+func WithTokenTTL(ttl string) Option {
+	return func(c *Config) { c.Auth.TokenTTL = ttl }
+}
+
+func WithSessionTTL(ttl string) Option {
+	return func(c *Config) { c.Auth.SessionTTL = ttl }
+}
+
+func WithMaxLoginFailures(failures int) Option {
+	return func(c *Config) { c.Auth.MaxLoginFailures = failures }
+}
+
+func WithMaxAddressFailures(failures int) Option {
+	return func(c *Config) { c.Auth.MaxAddressFailures = failures }
+}
+
+func WithLockoutPeriod(period string) Option {
+	return func(c *Config) { c.Auth.LockoutPeriod = period }
+}
+
+func WithResetURL(url string) Option {
+	return func(c *Config) { c.Auth.ResetURL = url }
+}
+
+func WithResetTTL(ttl string) Option {
+	return func(c *Config) { c.Auth.ResetTTL = ttl }
+}
+
+func WithMailFile(file string) Option {
+	return func(c *Config) { c.Mail.File = file }
+}
+
+func WithMailFrom(from string) Option {
+	return func(c *Config) { c.Mail.From = from }
+}
@@ -65,3 +65,121 @@ func TestWithMaxIdleTime(t *testing.T) {
 		t.Errorf("expected %s, got %s", maxIdleTime, config.Db.MaxIdleTime)
 	}
 }
+
+func TestWithQueryTimeout(t *testing.T) {
+	if config := NewConfig(); config.Db.QueryTimeout != "3s" {
+		t.Errorf("expected default 3s, got %s", config.Db.QueryTimeout)
+	}
+
+	queryTimeout := "500ms"
+	config := NewConfig(WithQueryTimeout(queryTimeout))
+
+	if config.Db.QueryTimeout != queryTimeout {
+		t.Errorf("expected %s, got %s", queryTimeout, config.Db.QueryTimeout)
+	}
+}
+
+func TestWithAutoMigrate(t *testing.T) {
+	config := NewConfig(WithAutoMigrate(true))
+
+	if !config.Db.AutoMigrate {
+		t.Error("expected auto migrate to be enabled")
+	}
+}
+
+func TestWithLoanPeriod(t *testing.T) {
+	if period := NewConfig().Library.LoanPeriod; period != "336h" {
+		t.Errorf("expected the default loan period, got %s", period)
+	}
+
+	config := NewConfig(WithLoanPeriod("72h"))
+	if config.Library.LoanPeriod != "72h" {
+		t.Errorf("expected %s, got %s", "72h", config.Library.LoanPeriod)
+	}
+}
+
+func TestWithFines(t *testing.T) {
+	library := NewConfig().Library
+	if library.DailyFine != 25 || library.FineGracePeriod != "24h" || library.FineCap != 2000 ||
+		library.FineThreshold != 1000 || library.OverdueCheckInterval != "1h" {
+		t.Errorf("unexpected fine defaults %+v", library)
+	}
+
+	library = NewConfig(
+		WithDailyFine(50),
+		WithFineGracePeriod("48h"),
+		WithFineCap(500),
+		WithFineThreshold(100),
+		WithOverdueCheckInterval("10m"),
+	).Library
+	if library.DailyFine != 50 || library.FineGracePeriod != "48h" || library.FineCap != 500 ||
+		library.FineThreshold != 100 || library.OverdueCheckInterval != "10m" {
+		t.Errorf("unexpected fine settings %+v", library)
+	}
+
+	library = NewConfig(WithDailyFine(0), WithFineCap(0), WithFineThreshold(0)).Library
+	if library.DailyFine != 0 || library.FineCap != 0 || library.FineThreshold != 0 {
+		t.Errorf("expected 0 to turn the fines, the cap and the threshold off, got %+v", library)
+	}
+}
+
+func TestWithHolds(t *testing.T) {
+	library := NewConfig().Library
+	if library.PickupWindow != "72h" || library.HoldCheckInterval != "15m" {
+		t.Errorf("unexpected hold defaults %+v", library)
+	}
+
+	library = NewConfig(WithPickupWindow("48h"), WithHoldCheckInterval("1m")).Library
+	if library.PickupWindow != "48h" || library.HoldCheckInterval != "1m" {
+		t.Errorf("unexpected hold settings %+v", library)
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	auth := NewConfig().Auth
+	if auth.Algorithm != "HS256" || auth.TokenTTL != "15m" || auth.SessionTTL != "720h" || auth.KeyFile != "" ||
+		auth.MaxLoginFailures != 5 || auth.MaxAddressFailures != 20 || auth.LockoutPeriod != "15m" {
+		t.Errorf("unexpected auth defaults %+v", auth)
+	}
+
+	auth = NewConfig(
+		WithTokenAlgorithm("EdDSA"),
+		WithSigningKey("keys/2024.pem", "2024"),
+		WithVerifyKey("2023", "keys/2023.pem"),
+		WithVerifyKey("2022", "keys/2022.pem"),
+		WithTokenTTL("5m"),
+		WithSessionTTL("24h"),
+		WithMaxLoginFailures(3),
+		WithMaxAddressFailures(10),
+		WithLockoutPeriod("1h"),
+	).Auth
+	if auth.Algorithm != "EdDSA" || auth.KeyFile != "keys/2024.pem" || auth.KeyID != "2024" ||
+		len(auth.VerifyKeys) != 2 || auth.VerifyKeys["2023"] != "keys/2023.pem" || auth.TokenTTL != "5m" || auth.SessionTTL != "24h" ||
+		auth.MaxLoginFailures != 3 || auth.MaxAddressFailures != 10 || auth.LockoutPeriod != "1h" {
+		t.Errorf("unexpected auth settings %+v", auth)
+	}
+
+	// 0 turns the throttle off
+	auth = NewConfig(WithMaxLoginFailures(0), WithMaxAddressFailures(0)).Auth
+	if auth.MaxLoginFailures != 0 || auth.MaxAddressFailures != 0 {
+		t.Errorf("expected no login throttle, got %+v", auth)
+	}
+}
+
+func TestWithMail(t *testing.T) {
+	cfg := NewConfig()
+	if cfg.Mail.File != "outbox.txt" || cfg.Mail.From != "library@localhost" || cfg.Auth.ResetTTL != "1h" || cfg.Auth.ResetURL == "" {
+		t.Errorf("unexpected mail defaults %+v %+v", cfg.Mail, cfg.Auth)
+	}
+
+	cfg = NewConfig(
+		WithMailFile("/var/mail/library"),
+		WithMailFrom("desk@library.example"),
+		WithResetURL("https://library.example/reset"),
+		WithResetTTL("30m"),
+	)
+	if cfg.Mail.File != "/var/mail/library" || cfg.Mail.From != "desk@library.example" ||
+		cfg.Auth.ResetURL != "https://library.example/reset" || cfg.Auth.ResetTTL != "30m" {
+		t.Errorf("unexpected mail settings %+v %+v", cfg.Mail, cfg.Auth)
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"test/config"
+	"test/internal/db/migrations"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,35 +28,110 @@ func NewSqlDB(cfg *config.Config, logger *zap.Logger) (*sqlx.DB, error) {
 			return nil, err
 		}
 
-		db.SetMaxOpenConns(cfg.Db.MaxOpenConns)
-		db.SetMaxIdleConns(cfg.Db.MaxIdleConns)
-		duration, err := time.ParseDuration(cfg.Db.MaxIdleTime)
+		err = configurePool(db, cfg)
 		if err != nil {
 			return nil, err
 		}
-		db.SetConnMaxIdleTime(duration)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
 
-		err = db.PingContext(ctx)
+		return sqlx.NewDb(db, "postgres"), nil
+	case "mysql":
+		dsn, err := mysqlDSN(cfg.Db.Dsn)
 		if err != nil {
 			return nil, err
 		}
 
-		return sqlx.NewDb(db, "postgres"), nil
-	case "mysql":
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		err = configurePool(db, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return sqlx.NewDb(db, "mysql"), nil
 
 	case "sqlite3":
-		rawdb, _ := sql.Open("sqlite3", ":memory:")
-		_, err := rawdb.Exec("CREATE TABLE users (id SERIAL PRIMARY KEY, email VARCHAR(100), hashed_password VARCHAR(100))")
+		db, err := sql.Open("sqlite3", sqliteDSN(cfg.Db.Dsn))
 		if err != nil {
-			logger.Fatal(err.Error())
+			return nil, err
 		}
-		db := sqlx.NewDb(rawdb, "sqlite3")
-		return db, nil
-	case "test":
+
+		// sqlite serializes writers anyway, and a single connection keeps
+		// an in-memory database alive for the lifetime of the pool.
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+
+		dbx := sqlx.NewDb(db, "sqlite3")
+
+		// an in-memory database starts empty every time, there is nothing
+		// to keep in sync with a migrate command
+		if strings.HasPrefix(cfg.Db.Dsn, ":memory:") {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = Migrate(ctx, dbx)
+			if err != nil {
+				logger.Error("error on creating sqlite schema", zap.Error(err))
+				db.Close()
+				return nil, err
+			}
+		}
+
+		return dbx, nil
+	case "test", "memory":
 		return nil, nil
 	}
 
 	return nil, errors.New("unknown DB type")
 }
+
+func configurePool(db *sql.DB, cfg *config.Config) error {
+	db.SetMaxOpenConns(cfg.Db.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Db.MaxIdleConns)
+	duration, err := time.ParseDuration(cfg.Db.MaxIdleTime)
+	if err != nil {
+		return err
+	}
+	db.SetConnMaxIdleTime(duration)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// mysqlDSN makes the driver scan DATETIME columns into time.Time, the
+// storages expect the same types from every backend.
+func mysqlDSN(dsn string) (string, error) {
+	mysqlCfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	mysqlCfg.ParseTime = true
+	// report matched rather than changed rows, the storages tell a missing
+	// row from an unchanged one by RowsAffected like on the other databases
+	mysqlCfg.ClientFoundRows = true
+	return mysqlCfg.FormatDSN(), nil
+}
+
+// sqliteDSN enables foreign keys for every connection, the schema relies on
+// them the same way the postgres one does.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_foreign_keys") || strings.Contains(dsn, "_fk") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_foreign_keys=on"
+	}
+	return dsn + "?_foreign_keys=on"
+}
+
+// Migrate applies every pending embedded migration of the db driver.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	return migrator.Up(ctx)
+}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"os"
+	"strings"
 	"test/config"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -20,3 +24,51 @@ func TestDB(t *testing.T) {
 	}
 
 }
+
+func TestSqliteDB(t *testing.T) {
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(":memory:"))
+	db, err := NewSqlDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"users", "authors", "books", "rented"} {
+		var count int
+		if err := db.Get(&count, "SELECT count(*) FROM "+table); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+}
+
+func TestMysqlDSN(t *testing.T) {
+	dsn, err := mysqlDSN("library:password@tcp(localhost:3306)/gobook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "parseTime=true") {
+		t.Errorf("expected parseTime in %s", dsn)
+	}
+	if !strings.Contains(dsn, "clientFoundRows=true") {
+		t.Errorf("expected clientFoundRows in %s", dsn)
+	}
+
+	if _, err := mysqlDSN("not a dsn"); err == nil {
+		t.Error("expected invalid dsn error")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, ctx.Err())
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected no deadline for a zero timeout")
+	}
+}
@@ -0,0 +1,173 @@
+// Package dberrors turns driver specific errors of postgres, mysql and
+// sqlite into a few typed errors the storages can branch on with errors.Is
+// instead of matching error messages.
+package dberrors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrUniqueViolation      = errors.New("unique constraint violation")
+	ErrForeignKeyViolation  = errors.New("foreign key constraint violation")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrTimeout              = errors.New("database timeout")
+)
+
+// Error is a classified driver error. It matches both its Kind and the
+// original driver error with errors.Is and errors.As.
+type Error struct {
+	Kind error
+	// Constraint is the name of the violated constraint when the driver
+	// reports it: users_email_key on postgres, users.email on mysql and sqlite.
+	Constraint string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Constraint != "" {
+		return e.Kind.Error() + " on " + e.Constraint + ": " + e.Err.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// postgres SQLSTATE codes, see the errcodes appendix of the postgres docs.
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgQueryCanceled        = "57014"
+	pgLockNotAvailable     = "55P03"
+)
+
+// mysql server error numbers.
+const (
+	myDuplicateEntry      = 1062
+	myNoReferencedRow     = 1216 // ER_NO_REFERENCED_ROW
+	myRowIsReferenced     = 1217 // ER_ROW_IS_REFERENCED
+	myRowIsReferenced2    = 1451 // ER_ROW_IS_REFERENCED_2
+	myNoReferencedRow2    = 1452 // ER_NO_REFERENCED_ROW_2
+	myLockWaitTimeout     = 1205
+	myDeadlock            = 1213
+	myMaxExecutionTimeout = 3024
+)
+
+// Classify wraps err into an *Error when it is one of the known failures and
+// returns it unchanged otherwise. A nil err stays nil.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(err, pqErr)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMysql(err, mysqlErr)
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return classifySqlite(err, sqliteErr)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+
+	return err
+}
+
+// Temporary reports whether retrying the operation may succeed.
+func Temporary(err error) bool {
+	return errors.Is(err, ErrSerializationFailure) || errors.Is(err, ErrTimeout)
+}
+
+// Constraint returns the name of the violated constraint, if any.
+func Constraint(err error) string {
+	var classified *Error
+	if errors.As(Classify(err), &classified) {
+		return classified.Constraint
+	}
+	return ""
+}
+
+func classifyPostgres(err error, pqErr *pq.Error) error {
+	switch pqErr.Code {
+	case pgUniqueViolation:
+		return &Error{Kind: ErrUniqueViolation, Constraint: pqErr.Constraint, Err: err}
+	case pgForeignKeyViolation:
+		return &Error{Kind: ErrForeignKeyViolation, Constraint: pqErr.Constraint, Err: err}
+	case pgSerializationFailure, pgDeadlockDetected:
+		return &Error{Kind: ErrSerializationFailure, Err: err}
+	case pgQueryCanceled, pgLockNotAvailable:
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+	return err
+}
+
+func classifyMysql(err error, mysqlErr *mysql.MySQLError) error {
+	switch mysqlErr.Number {
+	case myDuplicateEntry:
+		// Duplicate entry 'a@b.c' for key 'users.email'
+		return &Error{Kind: ErrUniqueViolation, Constraint: between(mysqlErr.Message, "for key '", "'"), Err: err}
+	case myNoReferencedRow, myRowIsReferenced, myRowIsReferenced2, myNoReferencedRow2:
+		// ... a foreign key constraint fails (`db`.`books`, CONSTRAINT `books_ibfk_1` FOREIGN KEY ...)
+		return &Error{Kind: ErrForeignKeyViolation, Constraint: between(mysqlErr.Message, "CONSTRAINT `", "`"), Err: err}
+	case myDeadlock:
+		return &Error{Kind: ErrSerializationFailure, Err: err}
+	case myLockWaitTimeout, myMaxExecutionTimeout:
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+	return err
+}
+
+func classifySqlite(err error, sqliteErr sqlite3.Error) error {
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		// UNIQUE constraint failed: users.email
+		return &Error{Kind: ErrUniqueViolation, Constraint: after(sqliteErr.Error(), "constraint failed: "), Err: err}
+	case sqlite3.ErrConstraintForeignKey:
+		// sqlite does not name the foreign key that failed.
+		return &Error{Kind: ErrForeignKeyViolation, Err: err}
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return &Error{Kind: ErrSerializationFailure, Err: err}
+	}
+	return err
+}
+
+func between(s, start, end string) string {
+	_, rest, ok := strings.Cut(s, start)
+	if !ok {
+		return ""
+	}
+	value, _, _ := strings.Cut(rest, end)
+	return value
+}
+
+func after(s, start string) string {
+	_, rest, _ := strings.Cut(s, start)
+	return rest
+}
@@ -0,0 +1,135 @@
+package dberrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		kind       error
+		constraint string
+	}{
+		{
+			name:       "postgres unique",
+			err:        &pq.Error{Code: "23505", Constraint: "users_email_key"},
+			kind:       ErrUniqueViolation,
+			constraint: "users_email_key",
+		},
+		{
+			name:       "postgres foreign key",
+			err:        &pq.Error{Code: "23503", Constraint: "books_author_id_fkey"},
+			kind:       ErrForeignKeyViolation,
+			constraint: "books_author_id_fkey",
+		},
+		{
+			name: "postgres serialization",
+			err:  &pq.Error{Code: "40001"},
+			kind: ErrSerializationFailure,
+		},
+		{
+			name: "postgres statement timeout",
+			err:  &pq.Error{Code: "57014"},
+			kind: ErrTimeout,
+		},
+		{
+			name:       "mysql duplicate entry",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"},
+			kind:       ErrUniqueViolation,
+			constraint: "users.email",
+		},
+		{
+			name: "mysql foreign key",
+			err: &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails " +
+				"(`library`.`books`, CONSTRAINT `books_ibfk_1` FOREIGN KEY (`author_id`) REFERENCES `authors` (`id`))"},
+			kind:       ErrForeignKeyViolation,
+			constraint: "books_ibfk_1",
+		},
+		{
+			name: "mysql deadlock",
+			err:  &mysql.MySQLError{Number: 1213},
+			kind: ErrSerializationFailure,
+		},
+		{
+			name: "mysql lock wait timeout",
+			err:  &mysql.MySQLError{Number: 1205},
+			kind: ErrTimeout,
+		},
+		{
+			name: "wrapped deadline",
+			err:  fmt.Errorf("query: %w", context.DeadlineExceeded),
+			kind: ErrTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Classify(tt.err)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected the driver error to stay reachable, got %v", err)
+			}
+			if got := Constraint(err); got != tt.constraint {
+				t.Errorf("expected constraint %q, got %q", tt.constraint, got)
+			}
+		})
+	}
+}
+
+func TestClassifyUnknown(t *testing.T) {
+	if Classify(nil) != nil {
+		t.Error("expected nil to stay nil")
+	}
+
+	unknown := errors.New("boom")
+	if err := Classify(unknown); err != unknown {
+		t.Errorf("expected %v unchanged, got %v", unknown, err)
+	}
+	if err := Classify(&pq.Error{Code: "42601"}); errors.Is(err, ErrTimeout) || Temporary(err) {
+		t.Errorf("expected a syntax error to stay unclassified, got %v", err)
+	}
+	if Temporary(context.Canceled) {
+		t.Error("expected a cancelled request not to be temporary")
+	}
+}
+
+func TestClassifySqlite(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		`CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT UNIQUE)`,
+		`CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER REFERENCES authors(id))`,
+		`INSERT INTO authors (id, name) VALUES (1, 'Le Guin')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.Exec(`INSERT INTO authors (name) VALUES ('Le Guin')`)
+	err = Classify(err)
+	if !errors.Is(err, ErrUniqueViolation) || Constraint(err) != "authors.name" {
+		t.Errorf("expected a unique violation on authors.name, got %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO books (author_id) VALUES (42)`)
+	if err = Classify(err); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("expected a foreign key violation, got %v", err)
+	}
+}
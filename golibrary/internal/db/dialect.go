@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// InsertReturningID executes an INSERT written with ? placeholders and
+// returns the generated id. Postgres and sqlite report it through RETURNING,
+// mysql has no RETURNING and exposes it through LastInsertId instead.
+func InsertReturningID(ctx context.Context, q sqlx.ExtContext, query string, args ...any) (int64, error) {
+	if q.DriverName() == "mysql" {
+		result, err := q.ExecContext(ctx, q.Rebind(query), args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	var id int64
+	err := q.QueryRowxContext(ctx, q.Rebind(query+" RETURNING id"), args...).Scan(&id)
+	return id, err
+}
+
+// Querier is the part of *sqlx.DB and *sqlx.Tx the storages use, so the same
+// storage code can run inside or outside a transaction.
+type Querier interface {
+	sqlx.ExtContext
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn in a transaction. It commits when fn returns nil and rolls
+// back on an error or a panic.
+func WithTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ForUpdate returns the row locking clause for a SELECT. Sqlite has none, it
+// locks the whole database for the writing transaction instead.
+func ForUpdate(q Querier) string {
+	if q.DriverName() == "sqlite3" {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// WithTimeout bounds an operation on ctx by the configured query timeout.
+// A zero timeout leaves the deadline to the caller's context.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,404 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+)
+
+type BookStorage struct {
+	store *Store
+	// tx is set on the storage handed to an Atomic callback, which already
+	// holds the store lock.
+	tx bool
+	// undo puts back the writes of the callback, in reverse
+	undo []func()
+}
+
+func NewBookStorage(store *Store) repository.IBookStorage {
+	return &BookStorage{store: store}
+}
+
+// Atomic holds the store lock for the whole callback and puts back the rows
+// it wrote if it fails.
+func (s *BookStorage) Atomic(ctx context.Context, fn func(ctx context.Context, tx repository.IBookStorage) error) error {
+	if s.tx {
+		return fn(ctx, s)
+	}
+
+	defer s.lock()()
+
+	tx := &BookStorage{store: s.store, tx: true}
+	if err := fn(ctx, tx); err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// journal records a row of table before a write inside an Atomic call
+// changes or adds it, so a failure can put it back. Writes replace the
+// fields of a row and never the elements of its slices, so a copy of the
+// row is enough. The ids handed out stay used, like a postgres sequence.
+func journal[V any](s *BookStorage, table map[int64]*V, id int64) {
+	if !s.tx {
+		return
+	}
+	row, ok := table[id]
+	if !ok {
+		s.undo = append(s.undo, func() { delete(table, id) })
+		return
+	}
+	saved := *row
+	s.undo = append(s.undo, func() { *row = saved })
+}
+
+func (s *BookStorage) lock() func() {
+	if s.tx {
+		return func() {}
+	}
+	s.store.mu.Lock()
+	return s.store.mu.Unlock
+}
+
+func (s *BookStorage) rlock() func() {
+	if s.tx {
+		return func() {}
+	}
+	s.store.mu.RLock()
+	return s.store.mu.RUnlock
+}
+
+var bookSortColumns = sortColumns[*models.Book]{
+	"id":        func(b *models.Book) any { return b.ID },
+	"title":     func(b *models.Book) any { return b.Title },
+	"year":      func(b *models.Book) any { return int64(b.Year) },
+	"available": func(b *models.Book) any { return b.Available },
+	"author":    func(b *models.Book) any { return b.Author.Name },
+}
+
+var authorSortColumns = sortColumns[*models.Author]{
+	"id":            func(a *models.Author) any { return a.ID },
+	"name":          func(a *models.Author) any { return a.Name },
+	"times_ordered": func(a *models.Author) any { return int64(a.Times_ordered) },
+}
+
+func bookID(b *models.Book) int64     { return b.ID }
+func authorID(a *models.Author) int64 { return a.ID }
+
+// book returns a stored book joined with its author, the shape of the books
+// nested in other rows, without catalog details.
+func (s *Store) book(id int64) models.Book {
+	stored := s.books[id]
+	author := s.authors[stored.Author.ID]
+	book := models.Book{
+		ID:        stored.ID,
+		Title:     stored.Title,
+		Year:      stored.Year,
+		Available: stored.Available,
+		Author:    &models.Author{ID: author.ID, Name: author.Name},
+	}
+	book.CopiesAvailable, book.CopiesTotal = s.copyCounts(id)
+	return book
+}
+
+// catalogBook is book with its catalog details, the shape of the books
+// endpoints.
+func (s *Store) catalogBook(id int64) models.Book {
+	stored := s.books[id]
+	book := s.book(id)
+	book.ISBN = stored.ISBN
+	book.Publisher = stored.Publisher
+	book.Edition = stored.Edition
+	book.Language = stored.Language
+	book.Pages = stored.Pages
+	book.Description = stored.Description
+	book.Genres = slices.Clone(stored.Genres)
+	for _, stored := range stored.Contributors {
+		author := s.authors[stored.Author.ID]
+		stored.Author = &models.Author{ID: author.ID, Name: author.Name}
+		book.Contributors = append(book.Contributors, stored)
+	}
+	return book
+}
+
+// credited reports whether the author is credited on the book in any role.
+func credited(book *models.Book, authorID int64) bool {
+	return slices.ContainsFunc(book.Contributors, func(c models.Contributor) bool {
+		return c.Author.ID == authorID
+	})
+}
+
+var bookMatchers = fieldMatchers[*models.Book]{
+	"isbn":      func(v any, b *models.Book) bool { return b.ISBN == v },
+	"publisher": func(v any, b *models.Book) bool { return strings.EqualFold(b.Publisher, v.(string)) },
+	"language":  func(v any, b *models.Book) bool { return b.Language == v },
+	"genre":     func(v any, b *models.Book) bool { return slices.Contains(b.Genres, v.(string)) },
+	"author_id": func(v any, b *models.Book) bool { return credited(b, v.(int64)) },
+	"year_min":  func(v any, b *models.Book) bool { return int64(b.Year) >= v.(int64) },
+	"year_max":  func(v any, b *models.Book) bool { return int64(b.Year) <= v.(int64) },
+	"available": func(v any, b *models.Book) bool { return b.Available == v },
+}
+
+var authorMatchers = fieldMatchers[*models.Author]{
+	"name": func(v any, a *models.Author) bool { return containsFold(a.Name, v.(string)) },
+}
+
+var userMatchers = fieldMatchers[*models.User]{
+	"name":  func(v any, u *models.User) bool { return containsFold(u.Name, v.(string)) },
+	"email": func(v any, u *models.User) bool { return sameEmail(u.Email, v.(string)) },
+	"role":  func(v any, u *models.User) bool { return u.Role == v.(string) },
+}
+
+func (s *Store) authorBooks(authorID int64) []models.Book {
+	var books []models.Book
+	for _, id := range sortedKeys(s.books) {
+		if credited(s.books[id], authorID) {
+			books = append(books, s.book(id))
+		}
+	}
+	return books
+}
+
+func (s *Store) rentedBooks(userID int64) []models.Book {
+	var books []models.Book
+	for _, id := range sortedKeys(s.rented) {
+		if r := s.rented[id]; r.userID == userID && r.returnedAt == nil {
+			books = append(books, s.book(r.bookID))
+		}
+	}
+	return books
+}
+
+// CreateBook credits a book without contributors to its author, like the
+// sql storages.
+func (s *BookStorage) CreateBook(ctx context.Context, book *models.Book) error {
+	defer s.lock()()
+
+	contributors := book.Contributors
+	if len(contributors) == 0 {
+		contributors = []models.Contributor{{Author: book.Author, Role: models.RoleAuthor, Position: 1}}
+	}
+	stored := make([]models.Contributor, 0, len(contributors))
+	for _, contributor := range contributors {
+		if contributor.Author == nil {
+			return book_errors.ErrAuthorNotFound
+		}
+		if _, ok := s.store.authors[contributor.Author.ID]; !ok {
+			return book_errors.ErrAuthorNotFound
+		}
+		contributor.Author = &models.Author{ID: contributor.Author.ID}
+		stored = append(stored, contributor)
+	}
+	slices.SortStableFunc(stored, func(a, b models.Contributor) int { return cmp.Compare(a.Position, b.Position) })
+
+	if book.ISBN != "" {
+		for _, other := range s.store.books {
+			if other.ISBN == book.ISBN {
+				return book_errors.ErrDuplicateISBN
+			}
+		}
+	}
+
+	// sql storages list genres alphabetically
+	genres := slices.Clone(book.Genres)
+	slices.Sort(genres)
+
+	s.store.bookSeq++
+	book.ID = s.store.bookSeq
+	journal(s, s.store.books, book.ID)
+	// a new book has no copies yet
+	s.store.books[book.ID] = &models.Book{
+		ID:           book.ID,
+		Title:        book.Title,
+		Year:         book.Year,
+		Author:       &models.Author{ID: contributors[0].Author.ID},
+		ISBN:         book.ISBN,
+		Publisher:    book.Publisher,
+		Edition:      book.Edition,
+		Language:     book.Language,
+		Pages:        book.Pages,
+		Description:  book.Description,
+		Genres:       genres,
+		Contributors: stored,
+	}
+
+	*book = s.store.catalogBook(book.ID)
+
+	return nil
+}
+
+func (s *BookStorage) CreateAuthor(ctx context.Context, author *models.Author) error {
+	defer s.lock()()
+
+	s.store.authorSeq++
+	author.ID = s.store.authorSeq
+	journal(s, s.store.authors, author.ID)
+	s.store.authors[author.ID] = &models.Author{ID: author.ID, Name: author.Name}
+
+	author.Books = s.store.authorBooks(author.ID)
+
+	return nil
+}
+
+func (s *BookStorage) ListUsers(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+	defer s.rlock()()
+
+	users := make([]*models.User, 0, len(s.store.users))
+	for _, user := range s.store.users {
+		ok, err := matches(filters, userMatchers, user)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		if ok {
+			users = append(users, copyUser(user))
+		}
+	}
+
+	users, metadata, err := page(users, filters, userSortColumns, userID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	for _, user := range users {
+		user.RentedBooks = s.store.rentedBooks(user.ID)
+	}
+
+	return users, metadata, nil
+}
+
+func (s *BookStorage) ListBooks(ctx context.Context, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	defer s.rlock()()
+
+	books := make([]*models.Book, 0, len(s.store.books))
+	for id := range s.store.books {
+		book := s.store.catalogBook(id)
+		ok, err := matches(filters, bookMatchers, &book)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		if ok {
+			books = append(books, &book)
+		}
+	}
+
+	return page(books, filters, bookSortColumns, bookID)
+}
+
+// SearchBooks ranks the books like the sql storages without full-text
+// search: every term has to be in the title, a contributor's name or the
+// description, which count 4, 2 and 1.
+func (s *BookStorage) SearchBooks(ctx context.Context, query string, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	defer s.rlock()()
+
+	terms := strings.Fields(strings.ToLower(query))
+	rank := make(map[int64]int)
+	books := []*models.Book{}
+	for id := range s.store.books {
+		book := s.store.catalogBook(id)
+		score, ok := searchScore(&book, terms)
+		if !ok {
+			continue
+		}
+		rank[id] = score
+		books = append(books, &book)
+	}
+
+	byRank := sortColumns[*models.Book]{
+		"relevance": func(b *models.Book) any { return int64(rank[b.ID]) },
+	}
+	return page(books, filters, byRank, bookID)
+}
+
+// searchScore scores a book against lower case search terms and reports
+// whether every term matched.
+func searchScore(book *models.Book, terms []string) (int, bool) {
+	title, description := strings.ToLower(book.Title), strings.ToLower(book.Description)
+	score := 0
+	for _, term := range terms {
+		matched := false
+		if strings.Contains(title, term) {
+			score, matched = score+4, true
+		}
+		if slices.ContainsFunc(book.Contributors, func(c models.Contributor) bool {
+			return strings.Contains(strings.ToLower(c.Author.Name), term)
+		}) {
+			score, matched = score+2, true
+		}
+		if strings.Contains(description, term) {
+			score, matched = score+1, true
+		}
+		if !matched {
+			return 0, false
+		}
+	}
+	return score, len(terms) > 0
+}
+
+func (s *BookStorage) ListAuthors(ctx context.Context, filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
+	defer s.rlock()()
+
+	authors := make([]*models.Author, 0, len(s.store.authors))
+	for _, stored := range s.store.authors {
+		ok, err := matches(filters, authorMatchers, stored)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		if ok {
+			author := *stored
+			authors = append(authors, &author)
+		}
+	}
+
+	authors, metadata, err := page(authors, filters, authorSortColumns, authorID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	for _, author := range authors {
+		author.Books = s.store.authorBooks(author.ID)
+	}
+
+	return authors, metadata, nil
+}
+
+// LockBook needs no row lock of its own, Atomic holds the store lock.
+func (s *BookStorage) LockBook(ctx context.Context, bookID int64) (*models.Book, error) {
+	defer s.rlock()()
+
+	if _, ok := s.store.books[bookID]; !ok {
+		return nil, book_errors.ErrBookNotFound
+	}
+
+	book := *s.store.books[bookID]
+	book.Author = &models.Author{ID: book.Author.ID}
+	return &book, nil
+}
+
+// IncrementTimesOrdered counts the order once for every author credited on
+// the book.
+func (s *BookStorage) IncrementTimesOrdered(ctx context.Context, bookID int64) error {
+	defer s.lock()()
+
+	book, ok := s.store.books[bookID]
+	if !ok {
+		return nil
+	}
+	for _, author := range s.store.authors {
+		if credited(book, author.ID) {
+			journal(s, s.store.authors, author.ID)
+			author.Times_ordered++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"context"
+
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+)
+
+// copyCounts are the available and total copies of the book, lost copies
+// are not counted.
+func (s *Store) copyCounts(bookID int64) (available, total int) {
+	for _, c := range s.copies {
+		if c.BookID != bookID {
+			continue
+		}
+		if c.Status == models.CopyAvailable {
+			available++
+		}
+		if c.Status != models.CopyLost {
+			total++
+		}
+	}
+	return available, total
+}
+
+func (s *Store) duplicateBarcode(copy *models.Copy) bool {
+	for _, other := range s.copies {
+		if other.ID != copy.ID && other.Barcode == copy.Barcode {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BookStorage) InsertCopy(ctx context.Context, copy *models.Copy) error {
+	defer s.lock()()
+
+	if _, ok := s.store.books[copy.BookID]; !ok {
+		return book_errors.ErrBookNotFound
+	}
+	if s.store.duplicateBarcode(copy) {
+		return book_errors.ErrDuplicateBarcode
+	}
+
+	s.store.copySeq++
+	copy.ID = s.store.copySeq
+	journal(s, s.store.copies, copy.ID)
+	stored := *copy
+	s.store.copies[copy.ID] = &stored
+
+	return nil
+}
+
+func (s *BookStorage) GetCopy(ctx context.Context, copyID int64) (*models.Copy, error) {
+	defer s.rlock()()
+
+	c, ok := s.store.copies[copyID]
+	if !ok {
+		return nil, book_errors.ErrCopyNotFound
+	}
+	copy := *c
+
+	return &copy, nil
+}
+
+func (s *BookStorage) AvailableCopy(ctx context.Context, bookID int64) (*models.Copy, error) {
+	defer s.rlock()()
+
+	for _, id := range sortedKeys(s.store.copies) {
+		if c := s.store.copies[id]; c.BookID == bookID && c.Status == models.CopyAvailable {
+			copy := *c
+			return &copy, nil
+		}
+	}
+
+	return nil, book_errors.ErrCopyNotFound
+}
+
+func (s *BookStorage) UpdateCopy(ctx context.Context, update *models.Copy) error {
+	defer s.lock()()
+
+	c, ok := s.store.copies[update.ID]
+	if !ok {
+		return book_errors.ErrCopyNotFound
+	}
+	if s.store.duplicateBarcode(update) {
+		return book_errors.ErrDuplicateBarcode
+	}
+	journal(s, s.store.copies, c.ID)
+	c.Barcode = update.Barcode
+	c.Condition = update.Condition
+	c.Location = update.Location
+	c.Status = update.Status
+
+	return nil
+}
+
+func (s *BookStorage) ListCopies(ctx context.Context, bookID int64) ([]*models.Copy, error) {
+	defer s.rlock()()
+
+	copies := []*models.Copy{}
+	for _, id := range sortedKeys(s.store.copies) {
+		if c := s.store.copies[id]; c.BookID == bookID {
+			copy := *c
+			copies = append(copies, &copy)
+		}
+	}
+
+	return copies, nil
+}
+
+func (s *BookStorage) SyncAvailable(ctx context.Context, bookID int64) error {
+	defer s.lock()()
+
+	if book, ok := s.store.books[bookID]; ok {
+		available, _ := s.store.copyCounts(bookID)
+		journal(s, s.store.books, bookID)
+		book.Available = available > 0
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+)
+
+var ledgerSortColumns = sortColumns[*models.LedgerEntry]{
+	"id":         func(e *models.LedgerEntry) any { return e.ID },
+	"created_at": func(e *models.LedgerEntry) any { return e.CreatedAt },
+	"amount":     func(e *models.LedgerEntry) any { return e.Amount },
+}
+
+func ledgerEntryID(e *models.LedgerEntry) int64 { return e.ID }
+
+func (s *BookStorage) SetFine(ctx context.Context, rental *models.Rental, amount int64, at time.Time) error {
+	defer s.lock()()
+
+	for _, entry := range s.store.fines {
+		if entry.RentalID != nil && *entry.RentalID == rental.ID {
+			journal(s, s.store.fines, entry.ID)
+			entry.Amount = amount
+			entry.UpdatedAt = at
+			return nil
+		}
+	}
+
+	rentalID := rental.ID
+	s.insertLedgerEntry(&models.LedgerEntry{
+		UserID:    rental.User.ID,
+		RentalID:  &rentalID,
+		Kind:      models.LedgerFine,
+		Amount:    amount,
+		CreatedAt: at,
+		UpdatedAt: at,
+	})
+
+	return nil
+}
+
+func (s *BookStorage) InsertLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error {
+	defer s.lock()()
+
+	s.insertLedgerEntry(entry)
+
+	return nil
+}
+
+func (s *BookStorage) insertLedgerEntry(entry *models.LedgerEntry) {
+	s.store.fineSeq++
+	entry.ID = s.store.fineSeq
+	journal(s, s.store.fines, entry.ID)
+	stored := *entry
+	s.store.fines[entry.ID] = &stored
+}
+
+// LockUser needs no row lock of its own, Atomic holds the store lock.
+func (s *BookStorage) LockUser(ctx context.Context, userID int64) error {
+	defer s.rlock()()
+
+	if user, ok := s.store.users[userID]; !ok || user.Deleted {
+		return book_errors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *BookStorage) FineBalance(ctx context.Context, userID int64) (int64, error) {
+	defer s.rlock()()
+
+	var balance int64
+	for _, entry := range s.store.fines {
+		if entry.UserID == userID {
+			balance += entry.Amount
+		}
+	}
+
+	return balance, nil
+}
+
+func (s *BookStorage) ListLedger(ctx context.Context, userID int64, filters filter.Filters) ([]*models.LedgerEntry, filter.Metadata, error) {
+	defer s.rlock()()
+
+	entries := []*models.LedgerEntry{}
+	for _, stored := range s.store.fines {
+		if stored.UserID == userID {
+			entry := *stored
+			entries = append(entries, &entry)
+		}
+	}
+
+	return page(entries, filters, ledgerSortColumns, ledgerEntryID)
+}
@@ -0,0 +1,217 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+)
+
+type hold struct {
+	id     int64
+	bookID int64
+	userID int64
+	// copyID is the copy kept for a ready hold, 0 while it waits
+	copyID    int64
+	status    string
+	createdAt time.Time
+	readyAt   *time.Time
+	expiresAt *time.Time
+	closedAt  *time.Time
+}
+
+var holdSortColumns = sortColumns[*models.Hold]{
+	"id":         func(h *models.Hold) any { return h.ID },
+	"created_at": func(h *models.Hold) any { return h.CreatedAt },
+}
+
+func holdID(h *models.Hold) int64 { return h.ID }
+
+func (h *hold) active() bool {
+	return h.status == models.HoldWaiting || h.status == models.HoldReady
+}
+
+func (h *hold) model() *models.Hold {
+	hold := &models.Hold{
+		ID:        h.id,
+		Status:    h.status,
+		CreatedAt: h.createdAt,
+		ReadyAt:   copyTime(h.readyAt),
+		ExpiresAt: copyTime(h.expiresAt),
+		ClosedAt:  copyTime(h.closedAt),
+	}
+	if h.copyID != 0 {
+		hold.Copy = &models.Copy{ID: h.copyID}
+	}
+	return hold
+}
+
+func (h *hold) withIDs() *models.Hold {
+	hold := h.model()
+	hold.Book = &models.Book{ID: h.bookID}
+	hold.User = &models.User{ID: h.userID}
+	return hold
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// position is the hold's place in its book's queue, 0 once it is closed.
+func (s *Store) position(h *hold) int {
+	if !h.active() {
+		return 0
+	}
+	position := 0
+	for _, other := range s.holds {
+		if other.bookID == h.bookID && other.active() && other.id <= h.id {
+			position++
+		}
+	}
+	return position
+}
+
+func (s *BookStorage) InsertHold(ctx context.Context, h *models.Hold) error {
+	defer s.lock()()
+
+	if _, ok := s.store.books[h.Book.ID]; !ok {
+		return book_errors.ErrHoldInvalid
+	}
+	if _, ok := s.store.users[h.User.ID]; !ok {
+		return book_errors.ErrHoldInvalid
+	}
+	for _, other := range s.store.holds {
+		if other.bookID == h.Book.ID && other.userID == h.User.ID && other.active() {
+			return book_errors.ErrHoldInvalid
+		}
+	}
+
+	s.store.holdSeq++
+	h.ID = s.store.holdSeq
+	journal(s, s.store.holds, h.ID)
+	s.store.holds[h.ID] = &hold{
+		id:        h.ID,
+		bookID:    h.Book.ID,
+		userID:    h.User.ID,
+		status:    h.Status,
+		createdAt: h.CreatedAt,
+	}
+
+	return nil
+}
+
+func (s *BookStorage) NextHold(ctx context.Context, bookID int64, status string) (*models.Hold, error) {
+	defer s.rlock()()
+
+	for _, id := range sortedKeys(s.store.holds) {
+		if h := s.store.holds[id]; h.bookID == bookID && h.status == status {
+			hold := h.withIDs()
+			hold.Position = s.store.position(h)
+			return hold, nil
+		}
+	}
+
+	return nil, book_errors.ErrHoldNotFound
+}
+
+func (s *BookStorage) ActiveHold(ctx context.Context, bookID, userID int64) (*models.Hold, error) {
+	defer s.rlock()()
+
+	for _, id := range sortedKeys(s.store.holds) {
+		if h := s.store.holds[id]; h.bookID == bookID && h.userID == userID && h.active() {
+			hold := h.withIDs()
+			hold.Position = s.store.position(h)
+			return hold, nil
+		}
+	}
+
+	return nil, book_errors.ErrHoldNotFound
+}
+
+func (s *BookStorage) GetHold(ctx context.Context, holdID int64) (*models.Hold, error) {
+	defer s.rlock()()
+
+	h, ok := s.store.holds[holdID]
+	if !ok {
+		return nil, book_errors.ErrHoldNotFound
+	}
+	hold := h.withIDs()
+	hold.Position = s.store.position(h)
+
+	return hold, nil
+}
+
+func (s *BookStorage) UpdateHold(ctx context.Context, update *models.Hold) error {
+	defer s.lock()()
+
+	h, ok := s.store.holds[update.ID]
+	if !ok {
+		return book_errors.ErrHoldNotFound
+	}
+	journal(s, s.store.holds, h.id)
+	h.status = update.Status
+	h.copyID = 0
+	if update.Copy != nil {
+		h.copyID = update.Copy.ID
+	}
+	h.readyAt = copyTime(update.ReadyAt)
+	h.expiresAt = copyTime(update.ExpiresAt)
+	h.closedAt = copyTime(update.ClosedAt)
+
+	return nil
+}
+
+func (s *BookStorage) ListExpiredHolds(ctx context.Context, before time.Time) ([]*models.Hold, error) {
+	defer s.rlock()()
+
+	holds := []*models.Hold{}
+	for _, id := range sortedKeys(s.store.holds) {
+		if h := s.store.holds[id]; h.status == models.HoldReady && h.expiresAt.Before(before) {
+			holds = append(holds, h.withIDs())
+		}
+	}
+
+	return holds, nil
+}
+
+func (s *BookStorage) ListUserHolds(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	defer s.rlock()()
+
+	holds := []*models.Hold{}
+	for _, h := range s.store.holds {
+		if h.userID != userID || status != "" && h.status != status {
+			continue
+		}
+		hold := h.model()
+		hold.Position = s.store.position(h)
+		book := s.store.book(h.bookID)
+		hold.Book = &book
+		holds = append(holds, hold)
+	}
+
+	return page(holds, filters, holdSortColumns, holdID)
+}
+
+func (s *BookStorage) ListBookHolds(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	defer s.rlock()()
+
+	holds := []*models.Hold{}
+	for _, h := range s.store.holds {
+		if h.bookID != bookID || !h.active() {
+			continue
+		}
+		user := s.store.users[h.userID]
+		hold := h.model()
+		hold.Position = s.store.position(h)
+		hold.User = &models.User{ID: user.ID, Name: user.Name, Email: user.Email, Deleted: user.Deleted}
+		holds = append(holds, hold)
+	}
+
+	return page(holds, filters, holdSortColumns, holdID)
+}
@@ -0,0 +1,235 @@
+package memory
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+)
+
+type rental struct {
+	id         int64
+	bookID     int64
+	copyID     int64
+	userID     int64
+	rentedAt   time.Time
+	dueAt      time.Time
+	returnedAt *time.Time
+}
+
+// Store holds the tables shared by the in-memory storages. Every storage
+// built on the same Store sees the same data, like two repositories sharing
+// one database.
+type Store struct {
+	mu sync.RWMutex
+
+	users   map[int64]*models.User
+	authors map[int64]*models.Author
+	books   map[int64]*models.Book
+	copies  map[int64]*models.Copy
+	rented  map[int64]*rental
+	fines   map[int64]*models.LedgerEntry
+	holds   map[int64]*hold
+	// sessions are the logins of the users
+	sessions map[int64]*models.Session
+	// resets are the mailed password reset tokens
+	resets map[int64]*models.PasswordReset
+
+	userSeq   int64
+	authorSeq int64
+	bookSeq   int64
+	copySeq   int64
+	rentSeq   int64
+	fineSeq   int64
+	holdSeq   int64
+
+	sessionSeq int64
+	resetSeq   int64
+}
+
+func New() *Store {
+	return &Store{
+		users:   make(map[int64]*models.User),
+		authors: make(map[int64]*models.Author),
+		books:   make(map[int64]*models.Book),
+		copies:  make(map[int64]*models.Copy),
+		rented:  make(map[int64]*rental),
+		fines:   make(map[int64]*models.LedgerEntry),
+		holds:   make(map[int64]*hold),
+
+		sessions: make(map[int64]*models.Session),
+		resets:   make(map[int64]*models.PasswordReset),
+	}
+}
+
+// sortColumns maps a sort column to the sort key of a row, an int64,
+// string, bool or time.Time, or nil for a NULL, the keys cursors are made of.
+type sortColumns[T any] map[string]func(T) any
+
+// fieldMatchers maps a field filter to a test of a row against its value,
+// the counterpart of the sql storages' conditions.
+type fieldMatchers[T any] map[string]func(value any, row T) bool
+
+// matches reports whether a row passes every field filter that is set.
+func matches[T any](filters filter.Filters, matchers fieldMatchers[T], row T) (bool, error) {
+	for name, value := range filters.Fields {
+		match, ok := matchers[name]
+		if !ok {
+			return false, fmt.Errorf("unknown filter %q", name)
+		}
+		if !match(value, row) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// containsFold reports whether substr is within s, ignoring case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// page sorts rows the way the sql storages do (sort columns, then id) and
+// cuts out the requested page, by number or after the cursor.
+func page[T any](rows []T, filters filter.Filters, columns sortColumns[T], id func(T) int64) ([]T, filter.Metadata, error) {
+	// the sort columns up to the id, which comes last
+	type sortKey struct {
+		key  func(T) any
+		desc bool
+	}
+	sortKeys := []sortKey{}
+	idDesc := false
+	for _, term := range filters.SortTerms() {
+		key, ok := columns[term.Column]
+		if !ok {
+			return nil, filter.Metadata{}, fmt.Errorf("unknown sort column %q", term.Column)
+		}
+		if term.Column == "id" {
+			idDesc = term.Desc
+			break
+		}
+		sortKeys = append(sortKeys, sortKey{key, term.Desc})
+	}
+
+	keysOf := func(row T) ([]any, int64) {
+		keys := make([]any, 0, len(sortKeys))
+		for _, k := range sortKeys {
+			keys = append(keys, k.key(row))
+		}
+		return keys, id(row)
+	}
+	// order compares two positions in the listing
+	order := func(aKeys []any, aID int64, bKeys []any, bID int64) int {
+		for i, k := range sortKeys {
+			c := compareKeys(aKeys[i], bKeys[i])
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		if idDesc {
+			return cmp.Compare(bID, aID)
+		}
+		return cmp.Compare(aID, bID)
+	}
+	compareRows := func(a, b T) int {
+		aKeys, aID := keysOf(a)
+		bKeys, bID := keysOf(b)
+		return order(aKeys, aID, bKeys, bID)
+	}
+	slices.SortStableFunc(rows, compareRows)
+
+	if !filters.Keyset {
+		total := len(rows)
+		start := min(filters.Offset(), total)
+		end := min(start+filters.Limit(), total)
+
+		return rows[start:end], filter.CalculateMetadata(total, filters.Page, filters.PageSize), nil
+	}
+
+	c := filters.Cursor
+	if c != nil && len(c.Keys) != len(sortKeys) {
+		return nil, filter.Metadata{}, fmt.Errorf("%w: cursor has %d keys, the sort %d", filter.ErrCursorMismatch, len(c.Keys), len(sortKeys))
+	}
+	// read the rows beyond the cursor in the order the sql storages do, going
+	// away from it, with one more to tell whether there is a next page
+	beyond := func(row T) int {
+		keys, rowID := keysOf(row)
+		return order(keys, rowID, c.Keys, c.ID)
+	}
+	fetched := []T{}
+	if c != nil && c.Before {
+		for i := len(rows) - 1; i >= 0 && len(fetched) <= filters.Limit(); i-- {
+			if beyond(rows[i]) < 0 {
+				fetched = append(fetched, rows[i])
+			}
+		}
+	} else {
+		for _, row := range rows {
+			if len(fetched) > filters.Limit() {
+				break
+			}
+			if c == nil || beyond(row) > 0 {
+				fetched = append(fetched, row)
+			}
+		}
+	}
+
+	rows, metadata := filter.KeysetPage(fetched, filters, keysOf)
+	return rows, metadata, nil
+}
+
+// compareKeys compares two sort keys of the same column. NULLs sort last,
+// like postgres does.
+func compareKeys(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		return compareBool(a, b.(bool))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("unsupported sort key %T", a))
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func sameEmail(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+func sortedKeys[V any](m map[int64]V) []int64 {
+	keys := make([]int64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,249 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+	user_repository "test/internal/modules/user/repository"
+)
+
+func newUser(name, email string) *models.User {
+	return &models.User{Name: name, Email: email, Password: models.Password{Hash: []byte("hash")}}
+}
+
+func listFilters(sort string) filter.Filters {
+	return filter.Filters{Page: 1, PageSize: 2, Sort: sort, SortSafelist: []string{sort}}
+}
+
+func TestUserStorage(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserStorage(New())
+
+	for _, user := range []*models.User{newUser("carl", "carl@example.com"), newUser("ryder", "ryder@example.com"), newUser("sweet", "sweet@example.com")} {
+		if err := users.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("duplicate email", func(t *testing.T) {
+		if err := users.Insert(ctx, newUser("big smoke", "CARL@example.com")); !errors.Is(err, user_repository.ErrDuplicateEmail) {
+			t.Errorf("expected %v, got %v", user_repository.ErrDuplicateEmail, err)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		if err := users.Insert(ctx, newUser("carl", "cj@example.com")); !errors.Is(err, user_repository.ErrDuplicateName) {
+			t.Errorf("expected %v, got %v", user_repository.ErrDuplicateName, err)
+		}
+	})
+
+	t.Run("optimistic locking", func(t *testing.T) {
+		first, err := users.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := users.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first.Name = "cj"
+		if err := users.Update(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		second.Name = "carl johnson"
+		if err := users.Update(ctx, second); !errors.Is(err, user_repository.ErrEditConflict) {
+			t.Errorf("expected %v, got %v", user_repository.ErrEditConflict, err)
+		}
+	})
+
+	t.Run("role", func(t *testing.T) {
+		user, err := users.Get(ctx, 3)
+		if err != nil || user.Role != models.RoleMember {
+			t.Fatalf("expected a member, got %+v (%v)", user, err)
+		}
+		user.Role = models.RoleAdmin
+		if err := users.Update(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if user, _ = users.Get(ctx, 3); user.Role != models.RoleAdmin {
+			t.Errorf("expected an admin, got %+v", user)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		got, metadata, err := users.GetAll(ctx, listFilters("-name"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Name != "sweet" || got[1].Name != "ryder" {
+			t.Errorf("unexpected page %+v", got)
+		}
+		if metadata.TotalRecords != 3 || metadata.LastPage != 2 {
+			t.Errorf("unexpected metadata %+v", metadata)
+		}
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		if err := users.Delete(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.Get(ctx, 2); !errors.Is(err, user_repository.ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", user_repository.ErrRecordNotFound, err)
+		}
+		if _, err := users.GetByName(ctx, "ryder"); !errors.Is(err, user_repository.ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", user_repository.ErrRecordNotFound, err)
+		}
+		if err := users.Delete(ctx, 2); !errors.Is(err, user_repository.ErrEditConflict) {
+			t.Errorf("expected %v, got %v", user_repository.ErrEditConflict, err)
+		}
+		if err := users.Insert(ctx, newUser("ryder", "ryder@example.com")); !errors.Is(err, user_repository.ErrDuplicateEmail) {
+			t.Errorf("expected deleted users to keep their email, got %v", err)
+		}
+	})
+}
+
+func TestBookStorage(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+	users := NewUserStorage(store)
+	books := NewBookStorage(store)
+
+	if err := users.Insert(ctx, newUser("carl", "carl@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	author := &models.Author{Name: "Stanislaw Lem"}
+	if err := books.CreateAuthor(ctx, author); err != nil {
+		t.Fatal(err)
+	}
+	book := &models.Book{Title: "Solaris", Year: 1961, Author: &models.Author{ID: author.ID}}
+	if err := books.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Available || book.CopiesTotal != 0 || book.Author.Name != author.Name {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	copy := &models.Copy{BookID: book.ID, Barcode: "BK1", Status: models.CopyAvailable}
+	if err := books.InsertCopy(ctx, copy); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.SyncAvailable(ctx, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.InsertCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK1"}); !errors.Is(err, book_errors.ErrDuplicateBarcode) {
+		t.Errorf("expected %v, got %v", book_errors.ErrDuplicateBarcode, err)
+	}
+	if err := books.InsertCopy(ctx, &models.Copy{BookID: 42, Barcode: "BK2"}); !errors.Is(err, book_errors.ErrBookNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+	}
+
+	if err := books.CreateBook(ctx, &models.Book{Title: "Orphan", Author: &models.Author{ID: 42}}); !errors.Is(err, book_errors.ErrAuthorNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrAuthorNotFound, err)
+	}
+
+	t.Run("atomic rollback", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		err := books.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+			if err := tx.InsertRental(ctx, newRental(copy, 1)); err != nil {
+				return err
+			}
+			lent := *copy
+			lent.Status = models.CopyOnLoan
+			if err := tx.UpdateCopy(ctx, &lent); err != nil {
+				return err
+			}
+			if err := tx.SyncAvailable(ctx, book.ID); err != nil {
+				return err
+			}
+			if err := tx.IncrementTimesOrdered(ctx, book.ID); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("expected %v, got %v", errBoom, err)
+		}
+
+		locked, err := books.LockBook(ctx, book.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !locked.Available {
+			t.Error("expected the availability change to be rolled back")
+		}
+		if stored, err := books.GetCopy(ctx, copy.ID); err != nil || stored.Status != models.CopyAvailable {
+			t.Errorf("expected the copy update to be rolled back, got %+v %v", stored, err)
+		}
+		authors, _, err := books.ListAuthors(ctx, listFilters("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authors[0].Times_ordered != 0 {
+			t.Errorf("expected the order count to be rolled back, got %d", authors[0].Times_ordered)
+		}
+		list, _, err := books.ListUsers(ctx, listFilters("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list[0].RentedBooks) != 0 {
+			t.Errorf("expected the rental to be rolled back, got %+v", list[0].RentedBooks)
+		}
+	})
+
+	t.Run("rentals", func(t *testing.T) {
+		if err := books.InsertRental(ctx, newRental(copy, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := books.InsertRental(ctx, newRental(copy, 1)); !errors.Is(err, book_errors.ErrRentInvalid) {
+			t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+		}
+		if err := books.InsertRental(ctx, newRental(copy, 42)); !errors.Is(err, book_errors.ErrRentInvalid) {
+			t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+		}
+		if err := books.IncrementTimesOrdered(ctx, book.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		authors, _, err := books.ListAuthors(ctx, listFilters("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authors[0].Times_ordered != 1 || len(authors[0].Books) != 1 {
+			t.Errorf("unexpected author %+v", authors[0])
+		}
+
+		rental, err := books.OpenRental(ctx, book.ID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rental.Copy.ID != copy.ID {
+			t.Errorf("expected the rental of copy %d, got %+v", copy.ID, rental.Copy)
+		}
+		if err := books.CloseRental(ctx, rental.ID, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+		if err := books.CloseRental(ctx, rental.ID, time.Now().UTC()); !errors.Is(err, book_errors.ErrBookNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+		}
+		if _, err := books.OpenRental(ctx, book.ID, 1); !errors.Is(err, book_errors.ErrBookNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+		}
+	})
+}
+
+func newRental(copy *models.Copy, userID int64) *models.Rental {
+	now := time.Now().UTC()
+	return &models.Rental{
+		Book:     &models.Book{ID: copy.BookID},
+		Copy:     copy,
+		User:     &models.User{ID: userID},
+		RentedAt: now,
+		DueAt:    now.Add(14 * 24 * time.Hour),
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+)
+
+func (s *UserStorage) InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	s.store.resetSeq++
+	reset.ID = s.store.resetSeq
+	stored := *reset
+	s.store.resets[reset.ID] = &stored
+
+	return nil
+}
+
+func (s *UserStorage) UsePasswordReset(ctx context.Context, hash string, passwordHash []byte, at time.Time) (*models.PasswordReset, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	var found *models.PasswordReset
+	for _, reset := range s.store.resets {
+		if reset.TokenHash == hash {
+			found = reset
+			break
+		}
+	}
+	if found == nil || !found.Active(at) {
+		return nil, repository.ErrRecordNotFound
+	}
+	user, ok := s.store.users[found.UserID]
+	if !ok || user.Deleted {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	// like the SQL storage, using one token spends every open token of the
+	// user
+	for _, reset := range s.store.resets {
+		if reset.UserID == found.UserID && reset.UsedAt == nil {
+			reset.UsedAt = &at
+		}
+	}
+
+	user.Password.Hash = append([]byte(nil), passwordHash...)
+	user.Version++
+
+	reset := *found
+	return &reset, nil
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+)
+
+// rentalsByBook counts the rentals of every book made since a time, all of
+// them for the zero time.
+func (s *Store) rentalsByBook(since time.Time) map[int64]int64 {
+	counts := make(map[int64]int64)
+	for _, r := range s.rented {
+		if since.IsZero() || !r.rentedAt.Before(since) {
+			counts[r.bookID]++
+		}
+	}
+	return counts
+}
+
+// leaderboard ranks the rows the way RANK does, the most rentals first,
+// before it cuts out the requested page like the sql storages, so that a
+// page read by cursor keeps the ranks.
+func leaderboard[T any](rows []T, filters filter.Filters, rentals func(T) int64, id func(T) int64, setRank func(T, int)) ([]T, filter.Metadata, error) {
+	slices.SortFunc(rows, func(a, b T) int {
+		return cmp.Compare(rentals(b), rentals(a))
+	})
+	rank := 0
+	for i, row := range rows {
+		if i == 0 || rentals(row) != rentals(rows[i-1]) {
+			rank = i + 1
+		}
+		setRank(row, rank)
+	}
+
+	byRentals := sortColumns[T]{
+		"rentals": func(row T) any { return rentals(row) },
+	}
+	return page(rows, filters, byRentals, id)
+}
+
+func (s *BookStorage) RankAuthors(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.AuthorRank, filter.Metadata, error) {
+	defer s.rlock()()
+
+	rentals := make(map[int64]int64)
+	for bookID, count := range s.store.rentalsByBook(since) {
+		// a book credits an author once, whatever the roles
+		seen := make(map[int64]bool)
+		for _, c := range s.store.books[bookID].Contributors {
+			if !seen[c.Author.ID] {
+				seen[c.Author.ID] = true
+				rentals[c.Author.ID] += count
+			}
+		}
+	}
+
+	ranks := []*models.AuthorRank{}
+	for authorID, count := range rentals {
+		stored := s.store.authors[authorID]
+		ok, err := matches(filters, authorMatchers, stored)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		if ok {
+			author := *stored
+			ranks = append(ranks, &models.AuthorRank{Rentals: count, Author: &author})
+		}
+	}
+
+	return leaderboard(ranks, filters,
+		func(r *models.AuthorRank) int64 { return r.Rentals },
+		func(r *models.AuthorRank) int64 { return r.Author.ID },
+		func(r *models.AuthorRank, rank int) { r.Rank = rank })
+}
+
+func (s *BookStorage) RankBooks(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.BookRank, filter.Metadata, error) {
+	defer s.rlock()()
+
+	ranks := []*models.BookRank{}
+	for bookID, count := range s.store.rentalsByBook(since) {
+		book := s.store.catalogBook(bookID)
+		ok, err := matches(filters, bookMatchers, &book)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		if ok {
+			ranks = append(ranks, &models.BookRank{Rentals: count, Book: &book})
+		}
+	}
+
+	return leaderboard(ranks, filters,
+		func(r *models.BookRank) int64 { return r.Rentals },
+		func(r *models.BookRank) int64 { return r.Book.ID },
+		func(r *models.BookRank, rank int) { r.Rank = rank })
+}
@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+)
+
+var rentalSortColumns = sortColumns[*models.Rental]{
+	"id":        func(r *models.Rental) any { return r.ID },
+	"rented_at": func(r *models.Rental) any { return r.RentedAt },
+	"due_at":    func(r *models.Rental) any { return r.DueAt },
+	"returned_at": func(r *models.Rental) any {
+		// open rentals sort last, like postgres sorts NULLs
+		if r.ReturnedAt == nil {
+			return nil
+		}
+		return *r.ReturnedAt
+	},
+}
+
+func rentalID(r *models.Rental) int64 { return r.ID }
+
+func (r *rental) model() *models.Rental {
+	rental := &models.Rental{ID: r.id, RentedAt: r.rentedAt, DueAt: r.dueAt}
+	if r.returnedAt != nil {
+		returnedAt := *r.returnedAt
+		rental.ReturnedAt = &returnedAt
+	}
+	return rental
+}
+
+// withIDs is the rental with only the ids of its book, copy and user, the shape
+// of the sql queries that don't join them.
+func (r *rental) withIDs() *models.Rental {
+	rental := r.model()
+	rental.Book = &models.Book{ID: r.bookID}
+	rental.Copy = &models.Copy{ID: r.copyID}
+	rental.User = &models.User{ID: r.userID}
+	return rental
+}
+
+func (s *BookStorage) InsertRental(ctx context.Context, loan *models.Rental) error {
+	defer s.lock()()
+
+	if _, ok := s.store.books[loan.Book.ID]; !ok {
+		return book_errors.ErrRentInvalid
+	}
+	if _, ok := s.store.users[loan.User.ID]; !ok {
+		return book_errors.ErrRentInvalid
+	}
+	if c, ok := s.store.copies[loan.Copy.ID]; !ok || c.BookID != loan.Book.ID {
+		return book_errors.ErrRentInvalid
+	}
+	for _, r := range s.store.rented {
+		if r.copyID == loan.Copy.ID && r.returnedAt == nil {
+			return book_errors.ErrRentInvalid
+		}
+	}
+
+	s.store.rentSeq++
+	loan.ID = s.store.rentSeq
+	journal(s, s.store.rented, loan.ID)
+	s.store.rented[loan.ID] = &rental{
+		id:       loan.ID,
+		bookID:   loan.Book.ID,
+		copyID:   loan.Copy.ID,
+		userID:   loan.User.ID,
+		rentedAt: loan.RentedAt,
+		dueAt:    loan.DueAt,
+	}
+
+	return nil
+}
+
+func (s *BookStorage) OpenRental(ctx context.Context, bookID, userID int64) (*models.Rental, error) {
+	defer s.rlock()()
+
+	for _, id := range sortedKeys(s.store.rented) {
+		if r := s.store.rented[id]; r.bookID == bookID && r.userID == userID && r.returnedAt == nil {
+			return r.withIDs(), nil
+		}
+	}
+
+	return nil, book_errors.ErrBookNotFound
+}
+
+func (s *BookStorage) CloseRental(ctx context.Context, rentalID int64, returnedAt time.Time) error {
+	defer s.lock()()
+
+	r, ok := s.store.rented[rentalID]
+	if !ok || r.returnedAt != nil {
+		return book_errors.ErrBookNotFound
+	}
+	journal(s, s.store.rented, r.id)
+	r.returnedAt = &returnedAt
+
+	return nil
+}
+
+func (s *BookStorage) ListOverdueRentals(ctx context.Context, dueBefore time.Time) ([]*models.Rental, error) {
+	defer s.rlock()()
+
+	rentals := []*models.Rental{}
+	for _, id := range sortedKeys(s.store.rented) {
+		if r := s.store.rented[id]; r.returnedAt == nil && r.dueAt.Before(dueBefore) {
+			rentals = append(rentals, r.withIDs())
+		}
+	}
+
+	return rentals, nil
+}
+
+func (s *BookStorage) ListUserRentals(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	defer s.rlock()()
+
+	rentals := []*models.Rental{}
+	for _, r := range s.store.rented {
+		if r.userID != userID {
+			continue
+		}
+		if status == models.RentalCurrent && r.returnedAt != nil || status == models.RentalReturned && r.returnedAt == nil {
+			continue
+		}
+		rental := r.model()
+		rental.Copy = s.store.copyRef(r.copyID)
+		book := s.store.book(r.bookID)
+		rental.Book = &book
+		rentals = append(rentals, rental)
+	}
+
+	return page(rentals, filters, rentalSortColumns, rentalID)
+}
+
+func (s *BookStorage) ListBookRentals(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	defer s.rlock()()
+
+	rentals := []*models.Rental{}
+	for _, r := range s.store.rented {
+		if r.bookID != bookID {
+			continue
+		}
+		user := s.store.users[r.userID]
+		rental := r.model()
+		rental.Copy = s.store.copyRef(r.copyID)
+		rental.User = &models.User{ID: user.ID, Name: user.Name, Email: user.Email, Deleted: user.Deleted}
+		rentals = append(rentals, rental)
+	}
+
+	return page(rentals, filters, rentalSortColumns, rentalID)
+}
+
+// copyRef is the id and barcode of a copy, what rental listings show of it.
+func (s *Store) copyRef(copyID int64) *models.Copy {
+	return &models.Copy{ID: copyID, Barcode: s.copies[copyID].Barcode}
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+)
+
+// copySession detaches a stored session from the caller.
+func copySession(s *models.Session) *models.Session {
+	session := *s
+	if s.RevokedAt != nil {
+		revokedAt := *s.RevokedAt
+		session.RevokedAt = &revokedAt
+	}
+	return &session
+}
+
+func (s *UserStorage) InsertSession(ctx context.Context, session *models.Session) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	s.store.sessionSeq++
+	session.ID = s.store.sessionSeq
+	s.store.sessions[session.ID] = copySession(session)
+
+	return nil
+}
+
+func (s *UserStorage) GetSession(ctx context.Context, id int64) (*models.Session, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	session, ok := s.store.sessions[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	return copySession(session), nil
+}
+
+func (s *UserStorage) GetSessionByToken(ctx context.Context, hash string) (*models.Session, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	for _, session := range s.store.sessions {
+		if session.TokenHash == hash || session.PreviousHash == hash {
+			return copySession(session), nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (s *UserStorage) RotateSession(ctx context.Context, session *models.Session, hash string, expiresAt time.Time) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	stored, ok := s.store.sessions[session.ID]
+	if !ok || stored.TokenHash != session.TokenHash || stored.RevokedAt != nil {
+		return repository.ErrEditConflict
+	}
+
+	stored.PreviousHash = stored.TokenHash
+	stored.TokenHash = hash
+	stored.ExpiresAt = expiresAt
+	*session = *copySession(stored)
+
+	return nil
+}
+
+func (s *UserStorage) RevokeSession(ctx context.Context, id int64, at time.Time) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	if session, ok := s.store.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &at
+	}
+
+	return nil
+}
+
+func (s *UserStorage) RevokeSessions(ctx context.Context, userID int64, at time.Time) (int, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	revoked := 0
+	for _, session := range s.store.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && at.Before(session.ExpiresAt) {
+			session.RevokedAt = &at
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
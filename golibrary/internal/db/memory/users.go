@@ -0,0 +1,188 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+)
+
+type UserStorage struct {
+	store *Store
+}
+
+func NewUserStorage(store *Store) repository.IUserStorage {
+	return &UserStorage{store: store}
+}
+
+var userSortColumns = sortColumns[*models.User]{
+	"id":    func(u *models.User) any { return u.ID },
+	"name":  func(u *models.User) any { return u.Name },
+	"email": func(u *models.User) any { return strings.ToLower(u.Email) },
+}
+
+func userID(u *models.User) int64 { return u.ID }
+
+// copyUser detaches a stored user from the caller, rented books are not part
+// of the users table and are never stored.
+func copyUser(u *models.User) *models.User {
+	user := *u
+	user.RentedBooks = nil
+	user.Password.Plaintext = nil
+	user.Password.Hash = append([]byte(nil), u.Password.Hash...)
+	return &user
+}
+
+func (s *UserStorage) Get(ctx context.Context, id int64) (*models.User, error) {
+	if id < 1 {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	user, ok := s.store.users[id]
+	if !ok || user.Deleted {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (s *UserStorage) GetByName(ctx context.Context, username string) (*models.User, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	var found *models.User
+	for _, user := range s.store.users {
+		if user.Name != username || user.Deleted {
+			continue
+		}
+		if found == nil || user.ID < found.ID {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	return copyUser(found), nil
+}
+
+func (s *UserStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	for _, user := range s.store.users {
+		if !user.Deleted && sameEmail(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (s *UserStorage) Insert(ctx context.Context, user *models.User) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	if s.store.emailTaken(user.Email, 0) {
+		return repository.ErrDuplicateEmail
+	}
+	if s.store.nameTaken(user.Name, 0) {
+		return repository.ErrDuplicateName
+	}
+
+	if user.Role == "" {
+		user.Role = models.RoleMember
+	}
+
+	s.store.userSeq++
+	user.ID = s.store.userSeq
+	user.Version = 1
+	s.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (s *UserStorage) Update(ctx context.Context, user *models.User) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	stored, ok := s.store.users[user.ID]
+	if !ok || stored.Deleted || stored.Version != user.Version {
+		return repository.ErrEditConflict
+	}
+	if s.store.emailTaken(user.Email, user.ID) {
+		return repository.ErrDuplicateEmail
+	}
+	if s.store.nameTaken(user.Name, user.ID) {
+		return repository.ErrDuplicateName
+	}
+
+	stored.Name = user.Name
+	stored.Email = user.Email
+	stored.Role = user.Role
+	stored.Password.Hash = append([]byte(nil), user.Password.Hash...)
+	stored.Version++
+	user.Version = stored.Version
+
+	return nil
+}
+
+func (s *UserStorage) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return repository.ErrRecordNotFound
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	stored, ok := s.store.users[id]
+	if !ok || stored.Deleted {
+		return repository.ErrEditConflict
+	}
+
+	stored.Deleted = true
+	stored.Version++
+
+	return nil
+}
+
+func (s *UserStorage) GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	users := []*models.User{}
+	for _, user := range s.store.users {
+		if !user.Deleted {
+			users = append(users, copyUser(user))
+		}
+	}
+
+	return page(users, filters, userSortColumns, userID)
+}
+
+// emailTaken reports whether another user, deleted ones included, already
+// owns the email. The unique index on users.email behaves the same way.
+func (s *Store) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && sameEmail(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameTaken reports whether another user than exceptID has the name, like
+// the unique index on users.name.
+func (s *Store) nameTaken(name string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && user.Name == name {
+			return true
+		}
+	}
+	return false
+}
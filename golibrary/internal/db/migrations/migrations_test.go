@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newSqlite(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql", "sqlite3"} {
+		migrations, err := Load(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("%s: no migrations", driver)
+		}
+		for _, m := range migrations {
+			if m.Up == "" || m.Down == "" {
+				t.Errorf("%s: migration %d is missing a direction", driver, m.Version)
+			}
+		}
+	}
+
+	if _, err := Load("oracle"); err == nil {
+		t.Error("expected unknown driver error")
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db := newSqlite(t)
+	ctx := context.Background()
+
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrator.migrations[len(migrator.migrations)-1].Version
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("second up should be a no-op: %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d is still pending", status.Version)
+		}
+	}
+
+	if _, err := db.Exec("SELECT count(*) FROM books"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrator.Goto(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT count(*) FROM books"); err == nil {
+		t.Error("expected books table to be dropped")
+	}
+
+	if err := migrator.Goto(ctx, latest); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[len(statuses)-1].AppliedAt != nil {
+		t.Error("expected the latest migration to be reverted")
+	}
+
+	if err := migrator.Goto(ctx, 999999); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected %v, got %v", ErrUnknownVersion, err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	script := `
+-- create things; carefully
+CREATE TABLE a (name text DEFAULT 'x;y');
+INSERT INTO a VALUES ('z');
+
+`
+	want := []string{"CREATE TABLE a (name text DEFAULT 'x;y')", "INSERT INTO a VALUES ('z')"}
+	if got := Split(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSplitDollarQuotes(t *testing.T) {
+	script := `
+CREATE FUNCTION f() RETURNS trigger AS $$
+BEGIN
+    RETURN NULL; -- not the end
+END;
+$$ LANGUAGE plpgsql;
+CREATE FUNCTION g() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql;
+SELECT $1, a$b FROM t;
+`
+	want := []string{
+		"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n    RETURN NULL; -- not the end\nEND;\n$$ LANGUAGE plpgsql",
+		"CREATE FUNCTION g() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql",
+		"SELECT $1, a$b FROM t",
+	}
+	if got := Split(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+// TestSplitScripts splits every embedded script the way the migrator runs
+// them. A statement cut inside a $$ body leaves its halves with an odd
+// number of $$.
+func TestSplitScripts(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql", "sqlite3"} {
+		migrations, err := Load(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range migrations {
+			for direction, script := range map[string]string{"up": m.Up, "down": m.Down} {
+				statements := Split(script)
+				if len(statements) == 0 {
+					t.Errorf("%s %06d_%s.%s: no statements", driver, m.Version, m.Name, direction)
+				}
+				for _, statement := range statements {
+					if strings.Count(statement, "$$")%2 != 0 {
+						t.Errorf("%s %06d_%s.%s: statement cut inside a $$ body: %q", driver, m.Version, m.Name, direction, statement)
+					}
+				}
+			}
+		}
+	}
+}
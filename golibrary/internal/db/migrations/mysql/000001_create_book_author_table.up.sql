@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name  TEXT NOT NULL,
+    email VARCHAR(255) COLLATE utf8mb4_0900_ai_ci UNIQUE NOT NULL,
+    password_hash VARBINARY(255) NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS authors (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name  VARCHAR(255) NOT NULL,
+    times_ordered INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    year INTEGER NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    available BOOLEAN NOT NULL DEFAULT true,
+    author_id BIGINT NOT NULL,
+    FOREIGN KEY (author_id) REFERENCES authors(id) ON DELETE CASCADE
+);
+
+
+CREATE TABLE IF NOT EXISTS rented (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  book_id BIGINT UNIQUE NOT NULL,
+  user_id BIGINT NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT true,
+  FOREIGN KEY (book_id) REFERENCES books(id),
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,12 @@
+DELETE FROM rented WHERE returned_at IS NOT NULL;
+
+ALTER TABLE rented DROP INDEX rented_open_book_idx;
+ALTER TABLE rented DROP COLUMN open_book_id;
+
+ALTER TABLE rented ADD UNIQUE INDEX book_id (book_id);
+ALTER TABLE rented DROP INDEX rented_book_history_idx;
+
+ALTER TABLE rented DROP COLUMN returned_at;
+ALTER TABLE rented DROP COLUMN due_at;
+ALTER TABLE rented DROP COLUMN rented_at;
+ALTER TABLE rented ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;
@@ -0,0 +1,17 @@
+-- Rentals are kept once the book is back, returned_at closes them.
+ALTER TABLE rented DROP COLUMN active;
+ALTER TABLE rented ADD COLUMN rented_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6);
+ALTER TABLE rented ADD COLUMN due_at DATETIME(6) NULL;
+ALTER TABLE rented ADD COLUMN returned_at DATETIME(6) NULL;
+UPDATE rented SET due_at = rented_at + INTERVAL 14 DAY;
+ALTER TABLE rented MODIFY due_at DATETIME(6) NOT NULL;
+
+-- The foreign key needs another index on book_id before the unique one can go.
+ALTER TABLE rented ADD INDEX rented_book_history_idx (book_id, rented_at);
+ALTER TABLE rented DROP INDEX book_id;
+ALTER TABLE rented ADD INDEX rented_user_history_idx (user_id, rented_at);
+
+-- A book can only be out once at a time. Mysql has no partial indexes, but
+-- a unique index ignores NULLs and open_book_id is NULL once the book is back.
+ALTER TABLE rented ADD COLUMN open_book_id BIGINT AS (IF(returned_at IS NULL, book_id, NULL)) STORED;
+ALTER TABLE rented ADD UNIQUE INDEX rented_open_book_idx (open_book_id);
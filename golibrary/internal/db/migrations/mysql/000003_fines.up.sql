@@ -0,0 +1,15 @@
+-- Fines ledger, amounts in cents. A fine is charged once per rental and
+-- grows while the book is out; payments and waivers are negative.
+CREATE TABLE IF NOT EXISTS fines (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    rental_id BIGINT UNIQUE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('fine', 'payment', 'waiver')),
+    amount BIGINT NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    INDEX fines_user_id_idx (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (rental_id) REFERENCES rented(id)
+);
@@ -0,0 +1,20 @@
+-- Hold queue per book. The oldest waiting hold is promoted to ready when the
+-- book comes back and stays ready until expires_at.
+CREATE TABLE IF NOT EXISTS holds (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    book_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('waiting', 'ready', 'fulfilled', 'expired', 'cancelled')),
+    created_at DATETIME(6) NOT NULL,
+    ready_at DATETIME(6) NULL,
+    expires_at DATETIME(6) NULL,
+    closed_at DATETIME(6) NULL,
+    -- A patron can only be in a book's queue once, active_user_id is NULL
+    -- once the hold is closed and unique indexes ignore NULLs.
+    active_user_id BIGINT AS (IF(status IN ('waiting', 'ready'), user_id, NULL)) STORED,
+    UNIQUE INDEX holds_active_idx (book_id, active_user_id),
+    INDEX holds_queue_idx (book_id, status, id),
+    INDEX holds_user_id_idx (user_id, created_at),
+    FOREIGN KEY (book_id) REFERENCES books(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
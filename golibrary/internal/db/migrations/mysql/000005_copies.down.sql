@@ -0,0 +1,15 @@
+-- A title can only be out once again, the oldest open rental of each title
+-- is kept.
+DELETE FROM rented WHERE returned_at IS NULL AND id NOT IN (
+    SELECT id FROM (SELECT min(id) AS id FROM rented WHERE returned_at IS NULL GROUP BY book_id) AS kept
+);
+
+ALTER TABLE rented DROP INDEX rented_open_copy_idx;
+ALTER TABLE rented DROP COLUMN open_copy_id;
+ALTER TABLE rented ADD COLUMN open_book_id BIGINT AS (IF(returned_at IS NULL, book_id, NULL)) STORED;
+ALTER TABLE rented ADD UNIQUE INDEX rented_open_book_idx (open_book_id);
+ALTER TABLE holds DROP FOREIGN KEY holds_copy_id_fk;
+ALTER TABLE holds DROP COLUMN copy_id;
+ALTER TABLE rented DROP FOREIGN KEY rented_copy_id_fk;
+ALTER TABLE rented DROP COLUMN copy_id;
+DROP TABLE IF EXISTS copies;
@@ -0,0 +1,36 @@
+-- Physical copies of a book. Rentals and ready holds point at the copy they
+-- are for; books.available says whether any copy is on the shelf.
+CREATE TABLE IF NOT EXISTS copies (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    book_id BIGINT NOT NULL,
+    barcode VARCHAR(64) NOT NULL UNIQUE,
+    `condition` VARCHAR(100) NOT NULL DEFAULT '',
+    location VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost', 'damaged', 'in_repair')),
+    INDEX copies_book_status_idx (book_id, status),
+    FOREIGN KEY (book_id) REFERENCES books(id)
+);
+
+-- Every existing book becomes one copy.
+INSERT INTO copies (book_id, barcode, status)
+SELECT books.id, CONCAT('BK', LPAD(books.id, 8, '0')),
+    CASE
+        WHEN EXISTS (SELECT 1 FROM rented WHERE rented.book_id = books.id AND rented.returned_at IS NULL) THEN 'on_loan'
+        WHEN books.available THEN 'available'
+        ELSE 'on_hold'
+    END
+FROM books;
+
+ALTER TABLE rented ADD COLUMN copy_id BIGINT NULL;
+ALTER TABLE rented ADD CONSTRAINT rented_copy_id_fk FOREIGN KEY (copy_id) REFERENCES copies(id);
+UPDATE rented SET copy_id = (SELECT copies.id FROM copies WHERE copies.book_id = rented.book_id);
+
+ALTER TABLE holds ADD COLUMN copy_id BIGINT NULL;
+ALTER TABLE holds ADD CONSTRAINT holds_copy_id_fk FOREIGN KEY (copy_id) REFERENCES copies(id);
+UPDATE holds SET copy_id = (SELECT copies.id FROM copies WHERE copies.book_id = holds.book_id) WHERE status = 'ready';
+
+-- A copy can only be out once at a time, a title as often as it has copies.
+ALTER TABLE rented DROP INDEX rented_open_book_idx;
+ALTER TABLE rented DROP COLUMN open_book_id;
+ALTER TABLE rented ADD COLUMN open_copy_id BIGINT AS (IF(returned_at IS NULL, copy_id, NULL)) STORED;
+ALTER TABLE rented ADD UNIQUE INDEX rented_open_copy_idx (open_copy_id);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS book_genres;
+
+ALTER TABLE books
+    DROP INDEX books_language_idx,
+    DROP INDEX books_publisher_idx,
+    DROP INDEX books_isbn_idx,
+    DROP COLUMN description,
+    DROP COLUMN pages,
+    DROP COLUMN language,
+    DROP COLUMN edition,
+    DROP COLUMN publisher,
+    DROP COLUMN isbn;
@@ -0,0 +1,22 @@
+-- Bibliographic details of a book. isbn is the normalized ISBN-13, NULL
+-- when unknown; the other details are empty when unknown. The publisher
+-- collation compares case-insensitively.
+ALTER TABLE books
+    ADD COLUMN isbn VARCHAR(13) NULL,
+    ADD COLUMN publisher VARCHAR(255) COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT '',
+    ADD COLUMN edition VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN language VARCHAR(3) NOT NULL DEFAULT '',
+    ADD COLUMN pages INTEGER NOT NULL DEFAULT 0 CHECK (pages >= 0),
+    ADD COLUMN description TEXT NOT NULL DEFAULT (''),
+    ADD UNIQUE INDEX books_isbn_idx (isbn),
+    ADD INDEX books_publisher_idx (publisher),
+    ADD INDEX books_language_idx (language);
+
+-- Genre and subject tags, lower case.
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id BIGINT NOT NULL,
+    genre VARCHAR(50) NOT NULL,
+    PRIMARY KEY (book_id, genre),
+    INDEX book_genres_genre_idx (genre, book_id),
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
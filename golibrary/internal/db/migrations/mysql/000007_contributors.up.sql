@@ -0,0 +1,16 @@
+-- Everyone credited on a book, in the order of the credits. books.author_id
+-- stays as the first contributor so nested books can show one author
+-- without another join.
+CREATE TABLE IF NOT EXISTS book_contributors (
+    book_id BIGINT NOT NULL,
+    author_id BIGINT NOT NULL,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+    position INTEGER NOT NULL CHECK (position > 0),
+    PRIMARY KEY (book_id, author_id, role),
+    INDEX book_contributors_author_idx (author_id, book_id),
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES authors(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_contributors (book_id, author_id, role, position)
+SELECT id, author_id, 'author', 1 FROM books;
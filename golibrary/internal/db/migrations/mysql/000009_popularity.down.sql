@@ -0,0 +1 @@
+DROP INDEX rented_rented_at_idx ON rented;
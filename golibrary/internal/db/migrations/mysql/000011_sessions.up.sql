@@ -0,0 +1,16 @@
+-- Login sessions. The refresh token of a session is only stored hashed and
+-- is replaced on every refresh, previous_hash keeps the one it replaced so a
+-- reused token is recognised and ends the session.
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    previous_hash CHAR(64) NULL,
+    created_at DATETIME(6) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    revoked_at DATETIME(6) NULL,
+    UNIQUE INDEX sessions_token_hash_idx (token_hash),
+    UNIQUE INDEX sessions_previous_hash_idx (previous_hash),
+    INDEX sessions_user_id_idx (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
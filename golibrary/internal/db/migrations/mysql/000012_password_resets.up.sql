@@ -0,0 +1,13 @@
+-- Password reset tokens. Like refresh tokens they are only stored hashed,
+-- used_at marks a token as spent so each can set a password once.
+CREATE TABLE IF NOT EXISTS password_resets (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    used_at DATETIME(6) NULL,
+    UNIQUE INDEX password_resets_token_hash_idx (token_hash),
+    INDEX password_resets_user_id_idx (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
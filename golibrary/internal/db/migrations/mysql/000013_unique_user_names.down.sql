@@ -0,0 +1 @@
+ALTER TABLE users DROP INDEX users_name_key, MODIFY name TEXT NOT NULL;
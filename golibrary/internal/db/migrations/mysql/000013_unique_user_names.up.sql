@@ -0,0 +1,3 @@
+-- Users log in and are managed by name, so a name belongs to one account.
+-- A TEXT column can't be indexed whole, names are at most 500 bytes anyway.
+ALTER TABLE users MODIFY name VARCHAR(500) NOT NULL, ADD UNIQUE INDEX users_name_key (name);
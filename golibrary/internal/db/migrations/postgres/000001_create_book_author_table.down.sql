@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rented;
+DROP TABLE IF EXISTS books;
+DROP TABLE IF EXISTS authors;
+DROP TABLE IF EXISTS users;
\ No newline at end of file
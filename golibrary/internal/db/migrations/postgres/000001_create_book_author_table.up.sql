@@ -1,5 +1,3 @@
--- gobook/init.sql
-\c gobook;
 CREATE EXTENSION IF NOT EXISTS citext;
 
 CREATE TABLE IF NOT EXISTS users (
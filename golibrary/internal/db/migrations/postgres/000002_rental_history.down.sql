@@ -0,0 +1,12 @@
+DELETE FROM rented WHERE returned_at IS NOT NULL;
+
+DROP INDEX IF EXISTS rented_user_history_idx;
+DROP INDEX IF EXISTS rented_book_history_idx;
+DROP INDEX IF EXISTS rented_open_book_idx;
+
+ALTER TABLE rented DROP COLUMN returned_at;
+ALTER TABLE rented DROP COLUMN due_at;
+ALTER TABLE rented DROP COLUMN rented_at;
+
+ALTER TABLE rented ADD COLUMN active bool NOT NULL DEFAULT true;
+ALTER TABLE rented ADD CONSTRAINT rented_book_id_key UNIQUE (book_id);
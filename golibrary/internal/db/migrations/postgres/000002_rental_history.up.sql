@@ -0,0 +1,14 @@
+-- Rentals are kept once the book is back, returned_at closes them.
+ALTER TABLE rented DROP CONSTRAINT IF EXISTS rented_book_id_key;
+ALTER TABLE rented DROP COLUMN IF EXISTS active;
+
+ALTER TABLE rented ADD COLUMN rented_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE rented ADD COLUMN due_at timestamptz;
+ALTER TABLE rented ADD COLUMN returned_at timestamptz;
+UPDATE rented SET due_at = rented_at + interval '14 days';
+ALTER TABLE rented ALTER COLUMN due_at SET NOT NULL;
+
+-- A book can only be out once at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS rented_open_book_idx ON rented (book_id) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS rented_book_history_idx ON rented (book_id, rented_at);
+CREATE INDEX IF NOT EXISTS rented_user_history_idx ON rented (user_id, rented_at);
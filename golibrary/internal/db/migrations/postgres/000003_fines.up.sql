@@ -0,0 +1,14 @@
+-- Fines ledger, amounts in cents. A fine is charged once per rental and
+-- grows while the book is out; payments and waivers are negative.
+CREATE TABLE IF NOT EXISTS fines (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id),
+    rental_id bigint UNIQUE REFERENCES rented(id),
+    kind text NOT NULL CHECK (kind IN ('fine', 'payment', 'waiver')),
+    amount bigint NOT NULL,
+    note text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS fines_user_id_idx ON fines (user_id, created_at);
@@ -0,0 +1,17 @@
+-- Hold queue per book. The oldest waiting hold is promoted to ready when the
+-- book comes back and stays ready until expires_at.
+CREATE TABLE IF NOT EXISTS holds (
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books(id),
+    user_id bigint NOT NULL REFERENCES users(id),
+    status text NOT NULL CHECK (status IN ('waiting', 'ready', 'fulfilled', 'expired', 'cancelled')),
+    created_at timestamptz NOT NULL,
+    ready_at timestamptz,
+    expires_at timestamptz,
+    closed_at timestamptz
+);
+
+-- A patron can only be in a book's queue once.
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX IF NOT EXISTS holds_queue_idx ON holds (book_id, status, id);
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id, created_at);
@@ -0,0 +1,11 @@
+-- A title can only be out once again, the oldest open rental of each title
+-- is kept.
+DELETE FROM rented WHERE returned_at IS NULL AND id NOT IN (
+    SELECT min(id) FROM rented WHERE returned_at IS NULL GROUP BY book_id
+);
+
+DROP INDEX IF EXISTS rented_open_copy_idx;
+ALTER TABLE holds DROP COLUMN IF EXISTS copy_id;
+ALTER TABLE rented DROP COLUMN IF EXISTS copy_id;
+DROP TABLE IF EXISTS copies;
+CREATE UNIQUE INDEX IF NOT EXISTS rented_open_book_idx ON rented (book_id) WHERE returned_at IS NULL;
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS book_genres;
+
+DROP INDEX IF EXISTS books_language_idx;
+DROP INDEX IF EXISTS books_publisher_idx;
+DROP INDEX IF EXISTS books_isbn_idx;
+
+ALTER TABLE books DROP COLUMN IF EXISTS description;
+ALTER TABLE books DROP COLUMN IF EXISTS pages;
+ALTER TABLE books DROP COLUMN IF EXISTS language;
+ALTER TABLE books DROP COLUMN IF EXISTS edition;
+ALTER TABLE books DROP COLUMN IF EXISTS publisher;
+ALTER TABLE books DROP COLUMN IF EXISTS isbn;
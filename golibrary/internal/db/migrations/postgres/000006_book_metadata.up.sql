@@ -0,0 +1,22 @@
+-- Bibliographic details of a book. isbn is the normalized ISBN-13, NULL
+-- when unknown; the other details are empty when unknown. Publishers
+-- compare case-insensitively.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn varchar(13);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher citext NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS edition varchar(100) NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS language varchar(3) NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS pages integer NOT NULL DEFAULT 0 CHECK (pages >= 0);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn_idx ON books (isbn);
+CREATE INDEX IF NOT EXISTS books_publisher_idx ON books (publisher);
+CREATE INDEX IF NOT EXISTS books_language_idx ON books (language);
+
+-- Genre and subject tags, lower case.
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    genre varchar(50) NOT NULL,
+    PRIMARY KEY (book_id, genre)
+);
+
+CREATE INDEX IF NOT EXISTS book_genres_genre_idx ON book_genres (genre, book_id);
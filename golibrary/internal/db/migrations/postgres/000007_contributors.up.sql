@@ -0,0 +1,15 @@
+-- Everyone credited on a book, in the order of the credits. books.author_id
+-- stays as the first contributor so nested books can show one author
+-- without another join.
+CREATE TABLE IF NOT EXISTS book_contributors (
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    author_id bigint NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+    position integer NOT NULL CHECK (position > 0),
+    PRIMARY KEY (book_id, author_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS book_contributors_author_idx ON book_contributors (author_id, book_id);
+
+INSERT INTO book_contributors (book_id, author_id, role, position)
+SELECT id, author_id, 'author', 1 FROM books;
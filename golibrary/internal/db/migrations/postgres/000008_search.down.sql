@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS books_search_author ON authors;
+DROP TRIGGER IF EXISTS books_search_contributor ON book_contributors;
+DROP TRIGGER IF EXISTS books_search_book ON books;
+
+DROP FUNCTION IF EXISTS books_search_on_author();
+DROP FUNCTION IF EXISTS books_search_on_contributor();
+DROP FUNCTION IF EXISTS books_search_on_book();
+DROP FUNCTION IF EXISTS refresh_books_search(bigint[]);
+
+DROP INDEX IF EXISTS books_search_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS search;
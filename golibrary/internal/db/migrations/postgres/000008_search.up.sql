@@ -0,0 +1,61 @@
+-- The full-text search document of a book: its title, everyone credited on
+-- it and its description, weighted in that order. The contributors live in
+-- other tables, so triggers keep the column up to date instead of a
+-- generated column. The other databases search with LIKE and need no schema.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search tsvector NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS books_search_idx ON books USING GIN (search);
+
+CREATE OR REPLACE FUNCTION refresh_books_search(book_ids bigint[]) RETURNS void AS $$
+    UPDATE books
+    SET search =
+        setweight(to_tsvector('english', books.title), 'A') ||
+        setweight(to_tsvector('english', COALESCE((
+            SELECT string_agg(authors.name, ' ' ORDER BY book_contributors.position)
+            FROM book_contributors
+            INNER JOIN authors ON book_contributors.author_id = authors.id
+            WHERE book_contributors.book_id = books.id
+        ), '')), 'B') ||
+        setweight(to_tsvector('english', books.description), 'C')
+    WHERE books.id = ANY(book_ids);
+$$ LANGUAGE sql;
+
+CREATE OR REPLACE FUNCTION books_search_on_book() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_books_search(ARRAY[NEW.id]);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION books_search_on_contributor() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM refresh_books_search(ARRAY[OLD.book_id]);
+    ELSE
+        PERFORM refresh_books_search(ARRAY[NEW.book_id]);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION books_search_on_author() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_books_search(ARRAY(SELECT book_id FROM book_contributors WHERE author_id = NEW.id));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the refresh only writes search, so it doesn't fire the books trigger again
+CREATE TRIGGER books_search_book
+    AFTER INSERT OR UPDATE OF title, description ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_on_book();
+
+CREATE TRIGGER books_search_contributor
+    AFTER INSERT OR DELETE ON book_contributors
+    FOR EACH ROW EXECUTE FUNCTION books_search_on_contributor();
+
+CREATE TRIGGER books_search_author
+    AFTER UPDATE OF name ON authors
+    FOR EACH ROW EXECUTE FUNCTION books_search_on_author();
+
+SELECT refresh_books_search(ARRAY(SELECT id FROM books));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS rented_rented_at_idx;
@@ -0,0 +1,4 @@
+-- What a user may do: members borrow, librarians run the catalog and the
+-- desk, admins also manage the accounts.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(20) NOT NULL DEFAULT 'member'
+    CHECK (role IN ('member', 'librarian', 'admin'));
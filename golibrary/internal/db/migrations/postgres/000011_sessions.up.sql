@@ -0,0 +1,14 @@
+-- Login sessions. The refresh token of a session is only stored hashed and
+-- is replaced on every refresh, previous_hash keeps the one it replaced so a
+-- reused token is recognised and ends the session.
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id),
+    token_hash char(64) NOT NULL UNIQUE,
+    previous_hash char(64) UNIQUE,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
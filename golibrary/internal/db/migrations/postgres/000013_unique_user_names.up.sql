@@ -0,0 +1,2 @@
+-- Users log in and are managed by name, so a name belongs to one account.
+ALTER TABLE users ADD CONSTRAINT users_name_key UNIQUE (name);
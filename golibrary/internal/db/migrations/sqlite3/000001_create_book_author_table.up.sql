@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY,
+    name  TEXT NOT NULL,
+    email TEXT COLLATE NOCASE UNIQUE NOT NULL,
+    password_hash BLOB NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS authors (
+    id INTEGER PRIMARY KEY,
+    name  VARCHAR(255) NOT NULL,
+    times_ordered INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id INTEGER PRIMARY KEY,
+    year INTEGER NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    available BOOLEAN NOT NULL DEFAULT true,
+    author_id INTEGER NOT NULL REFERENCES authors(id) ON DELETE CASCADE
+);
+
+
+CREATE TABLE IF NOT EXISTS rented (
+  id INTEGER PRIMARY KEY,
+  book_id INTEGER UNIQUE NOT NULL REFERENCES books(id),
+  user_id INTEGER NOT NULL REFERENCES users(id),
+  active BOOLEAN NOT NULL DEFAULT true
+);
@@ -0,0 +1,12 @@
+CREATE TABLE rented_current (
+  id INTEGER PRIMARY KEY,
+  book_id INTEGER UNIQUE NOT NULL REFERENCES books(id),
+  user_id INTEGER NOT NULL REFERENCES users(id),
+  active BOOLEAN NOT NULL DEFAULT true
+);
+
+INSERT INTO rented_current (id, book_id, user_id)
+SELECT id, book_id, user_id FROM rented WHERE returned_at IS NULL;
+
+DROP TABLE rented;
+ALTER TABLE rented_current RENAME TO rented;
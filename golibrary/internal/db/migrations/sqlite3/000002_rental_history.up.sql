@@ -0,0 +1,21 @@
+-- Rentals are kept once the book is back, returned_at closes them. Sqlite
+-- can't drop the unique constraint on book_id, so the table is rebuilt.
+CREATE TABLE rented_history (
+  id INTEGER PRIMARY KEY,
+  book_id INTEGER NOT NULL REFERENCES books(id),
+  user_id INTEGER NOT NULL REFERENCES users(id),
+  rented_at TIMESTAMP NOT NULL,
+  due_at TIMESTAMP NOT NULL,
+  returned_at TIMESTAMP
+);
+
+INSERT INTO rented_history (id, book_id, user_id, rented_at, due_at)
+SELECT id, book_id, user_id, CURRENT_TIMESTAMP, datetime(CURRENT_TIMESTAMP, '+14 days') FROM rented;
+
+DROP TABLE rented;
+ALTER TABLE rented_history RENAME TO rented;
+
+-- A book can only be out once at a time.
+CREATE UNIQUE INDEX rented_open_book_idx ON rented (book_id) WHERE returned_at IS NULL;
+CREATE INDEX rented_book_history_idx ON rented (book_id, rented_at);
+CREATE INDEX rented_user_history_idx ON rented (user_id, rented_at);
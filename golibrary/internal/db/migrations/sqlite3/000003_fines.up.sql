@@ -0,0 +1,14 @@
+-- Fines ledger, amounts in cents. A fine is charged once per rental and
+-- grows while the book is out; payments and waivers are negative.
+CREATE TABLE IF NOT EXISTS fines (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    rental_id INTEGER UNIQUE REFERENCES rented(id),
+    kind TEXT NOT NULL CHECK (kind IN ('fine', 'payment', 'waiver')),
+    amount INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS fines_user_id_idx ON fines (user_id, created_at);
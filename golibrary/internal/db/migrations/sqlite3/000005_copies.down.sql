@@ -0,0 +1,48 @@
+-- Sqlite can't drop columns that reference another table, so rented and
+-- holds are rebuilt without copy_id. A title can only be out once again,
+-- the oldest open rental of each title is kept.
+DELETE FROM rented WHERE returned_at IS NULL AND id NOT IN (
+    SELECT min(id) FROM rented WHERE returned_at IS NULL GROUP BY book_id
+);
+
+CREATE TABLE rented_titles (
+  id INTEGER PRIMARY KEY,
+  book_id INTEGER NOT NULL REFERENCES books(id),
+  user_id INTEGER NOT NULL REFERENCES users(id),
+  rented_at TIMESTAMP NOT NULL,
+  due_at TIMESTAMP NOT NULL,
+  returned_at TIMESTAMP
+);
+
+INSERT INTO rented_titles (id, book_id, user_id, rented_at, due_at, returned_at)
+SELECT id, book_id, user_id, rented_at, due_at, returned_at FROM rented;
+
+DROP TABLE rented;
+ALTER TABLE rented_titles RENAME TO rented;
+
+CREATE UNIQUE INDEX rented_open_book_idx ON rented (book_id) WHERE returned_at IS NULL;
+CREATE INDEX rented_book_history_idx ON rented (book_id, rented_at);
+CREATE INDEX rented_user_history_idx ON rented (user_id, rented_at);
+
+CREATE TABLE holds_titles (
+    id INTEGER PRIMARY KEY,
+    book_id INTEGER NOT NULL REFERENCES books(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    status TEXT NOT NULL CHECK (status IN ('waiting', 'ready', 'fulfilled', 'expired', 'cancelled')),
+    created_at TIMESTAMP NOT NULL,
+    ready_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    closed_at TIMESTAMP
+);
+
+INSERT INTO holds_titles (id, book_id, user_id, status, created_at, ready_at, expires_at, closed_at)
+SELECT id, book_id, user_id, status, created_at, ready_at, expires_at, closed_at FROM holds;
+
+DROP TABLE holds;
+ALTER TABLE holds_titles RENAME TO holds;
+
+CREATE UNIQUE INDEX holds_active_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX holds_queue_idx ON holds (book_id, status, id);
+CREATE INDEX holds_user_id_idx ON holds (user_id, created_at);
+
+DROP TABLE IF EXISTS copies;
@@ -0,0 +1,32 @@
+-- Physical copies of a book. Rentals and ready holds point at the copy they
+-- are for; books.available says whether any copy is on the shelf.
+CREATE TABLE IF NOT EXISTS copies (
+    id INTEGER PRIMARY KEY,
+    book_id INTEGER NOT NULL REFERENCES books(id),
+    barcode TEXT NOT NULL UNIQUE,
+    condition TEXT NOT NULL DEFAULT '',
+    location TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost', 'damaged', 'in_repair'))
+);
+
+CREATE INDEX IF NOT EXISTS copies_book_status_idx ON copies (book_id, status);
+
+-- Every existing book becomes one copy.
+INSERT INTO copies (book_id, barcode, status)
+SELECT books.id, 'BK' || substr('00000000' || books.id, -8),
+    CASE
+        WHEN EXISTS (SELECT 1 FROM rented WHERE rented.book_id = books.id AND rented.returned_at IS NULL) THEN 'on_loan'
+        WHEN books.available THEN 'available'
+        ELSE 'on_hold'
+    END
+FROM books;
+
+ALTER TABLE rented ADD COLUMN copy_id INTEGER REFERENCES copies(id);
+UPDATE rented SET copy_id = (SELECT copies.id FROM copies WHERE copies.book_id = rented.book_id);
+
+ALTER TABLE holds ADD COLUMN copy_id INTEGER REFERENCES copies(id);
+UPDATE holds SET copy_id = (SELECT copies.id FROM copies WHERE copies.book_id = holds.book_id) WHERE status = 'ready';
+
+-- A copy can only be out once at a time, a title as often as it has copies.
+DROP INDEX IF EXISTS rented_open_book_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS rented_open_copy_idx ON rented (copy_id) WHERE returned_at IS NULL;
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS book_genres;
+
+DROP INDEX IF EXISTS books_language_idx;
+DROP INDEX IF EXISTS books_publisher_idx;
+DROP INDEX IF EXISTS books_isbn_idx;
+
+ALTER TABLE books DROP COLUMN description;
+ALTER TABLE books DROP COLUMN pages;
+ALTER TABLE books DROP COLUMN language;
+ALTER TABLE books DROP COLUMN edition;
+ALTER TABLE books DROP COLUMN publisher;
+ALTER TABLE books DROP COLUMN isbn;
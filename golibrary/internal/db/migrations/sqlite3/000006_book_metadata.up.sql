@@ -0,0 +1,22 @@
+-- Bibliographic details of a book. isbn is the normalized ISBN-13, NULL
+-- when unknown; the other details are empty when unknown. Publishers
+-- compare case-insensitively.
+ALTER TABLE books ADD COLUMN isbn VARCHAR(13);
+ALTER TABLE books ADD COLUMN publisher VARCHAR(255) COLLATE NOCASE NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN edition VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN language VARCHAR(3) NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN pages INTEGER NOT NULL DEFAULT 0 CHECK (pages >= 0);
+ALTER TABLE books ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn_idx ON books (isbn);
+CREATE INDEX IF NOT EXISTS books_publisher_idx ON books (publisher);
+CREATE INDEX IF NOT EXISTS books_language_idx ON books (language);
+
+-- Genre and subject tags, lower case.
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    genre VARCHAR(50) NOT NULL,
+    PRIMARY KEY (book_id, genre)
+);
+
+CREATE INDEX IF NOT EXISTS book_genres_genre_idx ON book_genres (genre, book_id);
@@ -0,0 +1,2 @@
+-- The leaderboards count the rentals since the start of their window.
+CREATE INDEX IF NOT EXISTS rented_rented_at_idx ON rented (rented_at, book_id);
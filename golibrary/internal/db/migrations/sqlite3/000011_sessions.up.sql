@@ -0,0 +1,14 @@
+-- Login sessions. The refresh token of a session is only stored hashed and
+-- is replaced on every refresh, previous_hash keeps the one it replaced so a
+-- reused token is recognised and ends the session.
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    token_hash TEXT NOT NULL UNIQUE,
+    previous_hash TEXT UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
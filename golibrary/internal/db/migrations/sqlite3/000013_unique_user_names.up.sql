@@ -0,0 +1,2 @@
+-- Users log in and are managed by name, so a name belongs to one account.
+CREATE UNIQUE INDEX IF NOT EXISTS users_name_key ON users (name);
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"test/internal/infrastructure/responder"
+	"test/internal/models"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("not allowed")
+	ErrSessionEnded    = errors.New("session has ended, log in again")
+)
+
+// The private claims of a token, the subject is the user id.
+const (
+	claimName    = "username"
+	claimRole    = "role"
+	claimSession = "sid"
+)
+
+// Claims are what a verified token says about its bearer.
+type Claims struct {
+	UserID    int64
+	Name      string
+	Role      string
+	SessionID int64
+}
+
+// Sessions tells whether the session a token was issued for is still going,
+// tokens of sessions that were logged out or revoked are refused.
+type Sessions interface {
+	SessionActive(ctx context.Context, id int64) (bool, error)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the claims of the caller.
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims of the caller, false for anonymous
+// requests.
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// Allowed reports whether the caller is the user with the id or has a role
+// that includes role.
+func Allowed(ctx context.Context, userID int64, role string) bool {
+	claims, ok := FromContext(ctx)
+	return ok && (claims.UserID == userID || models.RoleIncludes(claims.Role, role))
+}
+
+// Middleware guards the routes, answering the requests it turns away
+// through the responder.
+type Middleware struct {
+	tokens    *Tokens
+	sessions  Sessions
+	responder responder.Responder
+}
+
+func New(tokens *Tokens, sessions Sessions, responder responder.Responder) *Middleware {
+	return &Middleware{tokens: tokens, sessions: sessions, responder: responder}
+}
+
+// Authenticate lets through requests with a valid token of a session that
+// is still going in the Authorization header or the jwt cookie and puts the
+// claims of the token in their context. The others get 401.
+func (m *Middleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := jwtauth.TokenFromHeader(r)
+		if token == "" {
+			token = jwtauth.TokenFromCookie(r)
+		}
+		if token == "" {
+			m.responder.ErrorUnauthorized(w, ErrUnauthenticated)
+			return
+		}
+		claims, err := m.tokens.Verify(token)
+		if err != nil {
+			m.responder.ErrorUnauthorized(w, jwtauth.ErrorReason(err))
+			return
+		}
+		active, err := m.sessions.SessionActive(r.Context(), claims.SessionID)
+		if err != nil {
+			m.responder.ErrorInternal(w, err)
+			return
+		}
+		if !active {
+			m.responder.ErrorUnauthorized(w, ErrSessionEnded)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+	})
+}
+
+// RequireRole lets through the callers whose role includes role, the others
+// get 403. It goes after Authenticate.
+func (m *Middleware) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := FromContext(r.Context())
+			if !ok {
+				m.responder.ErrorUnauthorized(w, ErrUnauthenticated)
+				return
+			}
+			if !models.RoleIncludes(claims.Role, role) {
+				m.responder.ErrorForbidden(w, ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// OwnerOrRole lets through the user whose id is in the URL parameter and the
+// callers whose role includes role, the others get 403. It goes after
+// Authenticate.
+func (m *Middleware) OwnerOrRole(param, role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := FromContext(r.Context()); !ok {
+				m.responder.ErrorUnauthorized(w, ErrUnauthenticated)
+				return
+			}
+			id, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+			if err != nil {
+				m.responder.ErrorBadRequest(w, err)
+				return
+			}
+			if !Allowed(r.Context(), id, role) {
+				m.responder.ErrorForbidden(w, ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// JWKS publishes the public verification keys as a JSON Web Key Set so
+// clients can check tokens themselves. It is empty for HS256.
+func (m *Middleware) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	m.responder.OutputJSON(w, m.tokens.PublicKeys())
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"test/config"
+	"test/internal/infrastructure/responder"
+	"test/internal/models"
+
+	"github.com/go-chi/chi"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/ptflp/godecoder"
+	"go.uber.org/zap"
+)
+
+func newTokens(t *testing.T, opts ...config.Option) *Tokens {
+	t.Helper()
+	tokens, err := NewTokens(config.NewConfig(opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// sessions are the ids of the sessions still going.
+type sessions map[int64]bool
+
+func (s sessions) SessionActive(ctx context.Context, id int64) (bool, error) {
+	return s[id], nil
+}
+
+func newMiddleware(t *testing.T, active sessions) (*Middleware, *Tokens) {
+	tokens := newTokens(t)
+	return New(tokens, active, responder.NewResponder(godecoder.NewDecoder(jsoniter.Config{}), zap.NewNop())), tokens
+}
+
+// token issues a token for the user in a session with the user's id.
+func token(t *testing.T, tokens *Tokens, id int64, role string) string {
+	t.Helper()
+	token, err := tokens.Issue(&models.User{ID: id, Name: "carl", Role: role}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	m, tokens := newMiddleware(t, sessions{7: true, 8: true})
+
+	var got Claims
+	handler := m.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	serve := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token(t, newTokens(t), 1, models.RoleMember))
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a foreign signature, got %d", code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token(t, tokens, 0, models.RoleMember))
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a token without a user, got %d", code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token(t, tokens, 7, models.RoleLibrarian))
+	if code := serve(req); code != http.StatusOK {
+		t.Errorf("expected 200 for a header token, got %d", code)
+	}
+	if got != (Claims{UserID: 7, Name: "carl", Role: models.RoleLibrarian, SessionID: 7}) {
+		t.Errorf("unexpected claims %+v", got)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "jwt", Value: token(t, tokens, 8, models.RoleMember)})
+	if code := serve(req); code != http.StatusOK || got.UserID != 8 {
+		t.Errorf("expected 200 for user 8 with a cookie token, got %d and %+v", code, got)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token(t, tokens, 9, models.RoleMember))
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a session that ended, got %d", code)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	m, _ := newMiddleware(t, nil)
+	handler := m.RequireRole(models.RoleLibrarian)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		role string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{models.RoleMember, http.StatusForbidden},
+		{models.RoleLibrarian, http.StatusOK},
+		{models.RoleAdmin, http.StatusOK},
+		{"janitor", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.role != "" {
+			req = req.WithContext(NewContext(req.Context(), Claims{UserID: 1, Role: tc.role}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("role %q: expected %d, got %d", tc.role, tc.code, w.Code)
+		}
+	}
+}
+
+func TestOwnerOrRole(t *testing.T) {
+	m, _ := newMiddleware(t, nil)
+	r := chi.NewRouter()
+	r.With(m.OwnerOrRole("userID", models.RoleLibrarian)).Get("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		path   string
+		claims Claims
+		code   int
+	}{
+		{"/users/3", Claims{UserID: 3, Role: models.RoleMember}, http.StatusOK},
+		{"/users/4", Claims{UserID: 3, Role: models.RoleMember}, http.StatusForbidden},
+		{"/users/4", Claims{UserID: 3, Role: models.RoleLibrarian}, http.StatusOK},
+		{"/users/x", Claims{UserID: 3, Role: models.RoleMember}, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req = req.WithContext(NewContext(req.Context(), tc.claims))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%s as %+v: expected %d, got %d", tc.path, tc.claims, tc.code, w.Code)
+		}
+	}
+}
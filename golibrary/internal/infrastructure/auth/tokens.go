@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"test/config"
+	"test/internal/models"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Algorithms are the signing algorithms tokens can be configured with.
+var Algorithms = []string{"HS256", "RS256", "EdDSA"}
+
+// ErrNoSigningKey is returned in production when no signing key is
+// configured, a made-up one would log everybody out on every restart.
+var ErrNoSigningKey = errors.New("a signing key file is required in production")
+
+// Tokens signs the access tokens with the current key and verifies them
+// with any of the configured keys, picked by the kid header.
+type Tokens struct {
+	alg  jwa.SignatureAlgorithm
+	sign jwk.Key
+	// keys verify the tokens, public holds what may be published of them
+	keys   jwk.Set
+	public jwk.Set
+	ttl    time.Duration
+	// generated is set when the signing key was made up at start
+	generated bool
+	now       func() time.Time
+}
+
+// NewTokens loads the signing configuration. Retired keys only verify, for
+// asymmetric algorithms their public half is enough.
+func NewTokens(cfg *config.Config) (*Tokens, error) {
+	var alg jwa.SignatureAlgorithm
+	if err := alg.Accept(cfg.Auth.Algorithm); err != nil || !isAlgorithm(alg) {
+		return nil, fmt.Errorf("unsupported token algorithm %q, use one of %v", cfg.Auth.Algorithm, Algorithms)
+	}
+	ttl, err := time.ParseDuration(cfg.Auth.TokenTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("token ttl %q must be a positive duration", cfg.Auth.TokenTTL)
+	}
+
+	t := &Tokens{alg: alg, keys: jwk.NewSet(), public: jwk.NewSet(), ttl: ttl, now: time.Now}
+
+	if cfg.Auth.KeyFile == "" {
+		if cfg.Env == "production" {
+			return nil, ErrNoSigningKey
+		}
+		t.sign, err = generateKey(alg)
+		t.generated = true
+	} else {
+		t.sign, err = loadKey(alg, cfg.Auth.KeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := t.add(t.sign, cfg.Auth.KeyID); err != nil {
+		return nil, err
+	}
+
+	for id, file := range cfg.Auth.VerifyKeys {
+		key, err := loadKey(alg, file)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.add(key, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func isAlgorithm(alg jwa.SignatureAlgorithm) bool {
+	return alg == jwa.HS256 || alg == jwa.RS256 || alg == jwa.EdDSA
+}
+
+// generateKey makes up a signing key for the algorithm.
+func generateKey(alg jwa.SignatureAlgorithm) (jwk.Key, error) {
+	var raw interface{}
+	var err error
+	switch alg {
+	case jwa.HS256:
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		raw = secret
+	case jwa.RS256:
+		raw, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwa.EdDSA:
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jwk.FromRaw(raw)
+}
+
+// loadKey reads a key file, the secret itself for HS256 and a PEM key
+// otherwise.
+func loadKey(alg jwa.SignatureAlgorithm, file string) (jwk.Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("token key: %w", err)
+	}
+
+	var key jwk.Key
+	if alg == jwa.HS256 {
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) < 32 {
+			return nil, fmt.Errorf("token key %s: an HS256 secret needs at least 32 bytes", file)
+		}
+		key, err = jwk.FromRaw(data)
+	} else {
+		key, err = jwk.ParseKey(data, jwk.WithPEM(true))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token key %s: %w", file, err)
+	}
+
+	if want := map[jwa.SignatureAlgorithm]jwa.KeyType{jwa.HS256: jwa.OctetSeq, jwa.RS256: jwa.RSA, jwa.EdDSA: jwa.OKP}[alg]; key.KeyType() != want {
+		return nil, fmt.Errorf("token key %s: a %s key can't be used with %s", file, key.KeyType(), alg)
+	}
+	return key, nil
+}
+
+// add registers a verification key under its id, the key's thumbprint when
+// none is configured.
+func (t *Tokens) add(key jwk.Key, id string) error {
+	if id != "" {
+		if err := key.Set(jwk.KeyIDKey, id); err != nil {
+			return err
+		}
+	} else if err := jwk.AssignKeyID(key); err != nil {
+		return err
+	}
+	if err := key.Set(jwk.AlgorithmKey, t.alg); err != nil {
+		return err
+	}
+	if _, ok := t.keys.LookupKeyID(key.KeyID()); ok {
+		return fmt.Errorf("token key id %q is used twice", key.KeyID())
+	}
+
+	if err := t.keys.AddKey(key); err != nil {
+		return err
+	}
+	if t.alg == jwa.HS256 {
+		// secrets are never published
+		return nil
+	}
+	public, err := key.PublicKey()
+	if err != nil {
+		return err
+	}
+	if err := public.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+		return err
+	}
+	return t.public.AddKey(public)
+}
+
+// Generated reports whether the signing key was made up at start, its
+// tokens don't survive a restart.
+func (t *Tokens) Generated() bool {
+	return t.generated
+}
+
+// TTL is how long the tokens are valid.
+func (t *Tokens) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue signs a token for a user in a session, its subject is the user id.
+func (t *Tokens) Issue(user *models.User, sessionID int64) (string, error) {
+	now := t.now()
+
+	token, err := jwt.NewBuilder().
+		Subject(strconv.FormatInt(user.ID, 10)).
+		IssuedAt(now).
+		Expiration(now.Add(t.ttl)).
+		Claim(claimName, user.Name).
+		Claim(claimRole, user.Role).
+		Claim(claimSession, sessionID).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := jwt.Sign(token, jwt.WithKey(t.alg, t.sign))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// Verify checks the signature and the lifetime of a token and returns its
+// claims.
+func (t *Tokens) Verify(token string) (Claims, error) {
+	parsed, err := jwt.ParseString(token,
+		jwt.WithKeySet(t.keys),
+		jwt.WithValidate(true),
+		jwt.WithClock(jwt.ClockFunc(t.now)),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithRequiredClaim(jwt.IssuedAtKey),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	id, err := strconv.ParseInt(parsed.Subject(), 10, 64)
+	name, _ := parsed.PrivateClaims()[claimName].(string)
+	role, _ := parsed.PrivateClaims()[claimRole].(string)
+	// numbers come back from JSON as float64
+	sid, _ := parsed.PrivateClaims()[claimSession].(float64)
+	if err != nil || id < 1 || role == "" || sid < 1 {
+		return Claims{}, errInvalidClaims
+	}
+
+	return Claims{UserID: id, Name: name, Role: role, SessionID: int64(sid)}, nil
+}
+
+var errInvalidClaims = errors.New("token doesn't name its user and session")
+
+// PublicKeys are the public halves of the verification keys, empty for
+// HS256.
+func (t *Tokens) PublicKeys() jwk.Set {
+	return t.public
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"test/config"
+	"test/internal/models"
+)
+
+// writeKey stores a PEM private key for the algorithm in a temporary file.
+func writeKey(t *testing.T, alg string) string {
+	t.Helper()
+
+	var raw any
+	var err error
+	switch alg {
+	case "RS256":
+		raw, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), alg+".pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTokensAlgorithms(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte(strings.Repeat("s", 32)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		alg, file string
+		published int
+	}{
+		{"HS256", secret, 0},
+		{"RS256", writeKey(t, "RS256"), 1},
+		{"EdDSA", writeKey(t, "EdDSA"), 1},
+	} {
+		tokens := newTokens(t, config.WithTokenAlgorithm(tc.alg), config.WithSigningKey(tc.file, "current"))
+		if tokens.Generated() {
+			t.Errorf("%s: expected the configured key to be used", tc.alg)
+		}
+
+		token := token(t, tokens, 5, models.RoleMember)
+		claims, err := tokens.Verify(token)
+		if err != nil || claims.UserID != 5 || claims.Role != models.RoleMember {
+			t.Errorf("%s: unexpected claims %+v (%v)", tc.alg, claims, err)
+		}
+
+		// the header names the key and the algorithm, the payload the
+		// standard claims
+		parts := strings.Split(token, ".")
+		var header, payload map[string]any
+		decodeSegment(t, parts[0], &header)
+		decodeSegment(t, parts[1], &payload)
+		if header["kid"] != "current" || header["alg"] != tc.alg {
+			t.Errorf("%s: unexpected header %v", tc.alg, header)
+		}
+		if payload["sub"] != "5" || payload["exp"] == nil || payload["iat"] == nil {
+			t.Errorf("%s: unexpected payload %v", tc.alg, payload)
+		}
+
+		jwks, err := json.Marshal(tokens.PublicKeys())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var set struct {
+			Keys []map[string]any `json:"keys"`
+		}
+		if err := json.Unmarshal(jwks, &set); err != nil {
+			t.Fatal(err)
+		}
+		if len(set.Keys) != tc.published {
+			t.Fatalf("%s: expected %d published keys, got %s", tc.alg, tc.published, jwks)
+		}
+		for _, key := range set.Keys {
+			if key["kid"] != "current" || key["use"] != "sig" || key["d"] != nil {
+				t.Errorf("%s: unexpected published key %v", tc.alg, key)
+			}
+		}
+	}
+}
+
+func decodeSegment(t *testing.T, segment string, v any) {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokensRotation(t *testing.T) {
+	oldKey, newKey := writeKey(t, "EdDSA"), writeKey(t, "EdDSA")
+
+	old := newTokens(t, config.WithTokenAlgorithm("EdDSA"), config.WithSigningKey(oldKey, "2023"))
+	issued := token(t, old, 3, models.RoleMember)
+
+	rotated := newTokens(t, config.WithTokenAlgorithm("EdDSA"), config.WithSigningKey(newKey, "2024"), config.WithVerifyKey("2023", oldKey))
+	if _, err := rotated.Verify(issued); err != nil {
+		t.Errorf("expected a token of the retired key to verify, got %v", err)
+	}
+	if _, err := old.Verify(token(t, rotated, 3, models.RoleMember)); err == nil {
+		t.Error("expected a token of an unknown key to be refused")
+	}
+	if n := rotated.PublicKeys().Len(); n != 2 {
+		t.Errorf("expected both keys to be published, got %d", n)
+	}
+
+	retired := newTokens(t, config.WithTokenAlgorithm("EdDSA"), config.WithSigningKey(newKey, "2024"))
+	if _, err := retired.Verify(issued); err == nil {
+		t.Error("expected a token of a dropped key to be refused")
+	}
+}
+
+func TestTokensExpiry(t *testing.T) {
+	tokens := newTokens(t, config.WithTokenTTL("15m"))
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	issued := token(t, tokens, 3, models.RoleMember)
+
+	now = now.Add(14 * time.Minute)
+	if _, err := tokens.Verify(issued); err != nil {
+		t.Errorf("expected the token to be valid, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := tokens.Verify(issued); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+}
+
+func TestNewTokensErrors(t *testing.T) {
+	short := filepath.Join(t.TempDir(), "short")
+	if err := os.WriteFile(short, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, opts := range map[string][]config.Option{
+		"unknown algorithm": {config.WithTokenAlgorithm("none")},
+		"bad ttl":           {config.WithTokenTTL("soon")},
+		"missing file":      {config.WithSigningKey(filepath.Join(t.TempDir(), "missing"), "")},
+		"short secret":      {config.WithSigningKey(short, "")},
+		"wrong key type":    {config.WithTokenAlgorithm("RS256"), config.WithSigningKey(writeKey(t, "EdDSA"), "")},
+		"reused key id":     {config.WithTokenAlgorithm("EdDSA"), config.WithSigningKey(writeKey(t, "EdDSA"), "a"), config.WithVerifyKey("a", writeKey(t, "EdDSA"))},
+	} {
+		if _, err := NewTokens(config.NewConfig(opts...)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := NewTokens(config.NewConfig(config.WithEnv("production"))); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected %v, got %v", ErrNoSigningKey, err)
+	}
+}
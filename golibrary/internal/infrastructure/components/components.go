@@ -1,6 +1,8 @@
 package components
 
 import (
+	"test/internal/infrastructure/auth"
+	"test/internal/infrastructure/mailer"
 	"test/internal/infrastructure/responder"
 
 	"github.com/jmoiron/sqlx"
@@ -13,13 +15,17 @@ type Components struct {
 	Decoder   godecoder.Decoder
 	Logger    *zap.Logger
 	DB        *sqlx.DB
+	Tokens    *auth.Tokens
+	Mailer    mailer.Mailer
 }
 
-func NewComponents(responder responder.Responder, decoder godecoder.Decoder, logger *zap.Logger, db *sqlx.DB) *Components {
+func NewComponents(responder responder.Responder, decoder godecoder.Decoder, logger *zap.Logger, db *sqlx.DB, tokens *auth.Tokens, mailer mailer.Mailer) *Components {
 	return &Components{
 		Responder: responder,
 		Decoder:   decoder,
 		Logger:    logger,
 		DB:        db,
+		Tokens:    tokens,
+		Mailer:    mailer,
 	}
 }
@@ -0,0 +1,142 @@
+package filters
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Cursor is a position in a listing paged by keyset: the sort keys and id
+// of the row it was made from. The page starts after that row, or ends
+// before it when Before is set. Clients get cursors as opaque strings, see
+// String and ParseCursor.
+type Cursor struct {
+	// Sort is the sort of the listing the cursor was made for.
+	Sort string
+	// Keys are the row's values of the sort columns other than the id, each
+	// an int64, string, bool or time.Time, or nil for a NULL.
+	Keys   []any
+	ID     int64
+	Before bool
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ErrCursorMismatch is returned by the storages for a cursor whose keys
+// don't fit the sort of the listing.
+var ErrCursorMismatch = errors.New("cursor doesn't fit the sort of the listing")
+
+// cursorJSON is the encoded form of a cursor, the kinds keep the types of
+// the keys across the round trip.
+type cursorJSON struct {
+	Sort   string            `json:"s"`
+	Kinds  []string          `json:"t,omitempty"`
+	Keys   []json.RawMessage `json:"k,omitempty"`
+	ID     int64             `json:"i"`
+	Before bool              `json:"b,omitempty"`
+}
+
+func (c Cursor) String() string {
+	encoded := cursorJSON{Sort: c.Sort, ID: c.ID, Before: c.Before}
+	for _, key := range c.Keys {
+		var kind string
+		switch key.(type) {
+		case nil:
+			kind = "null"
+		case int64:
+			kind = "int"
+		case string:
+			kind = "string"
+		case bool:
+			kind = "bool"
+		case time.Time:
+			kind = "time"
+		default:
+			panic(fmt.Sprintf("unsupported cursor key %T", key))
+		}
+		raw, _ := json.Marshal(key)
+		encoded.Kinds = append(encoded.Kinds, kind)
+		encoded.Keys = append(encoded.Keys, raw)
+	}
+
+	b, _ := json.Marshal(encoded)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor made by String.
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var encoded cursorJSON
+	if err := json.Unmarshal(b, &encoded); err != nil || len(encoded.Kinds) != len(encoded.Keys) {
+		return nil, errInvalidCursor
+	}
+
+	c := &Cursor{Sort: encoded.Sort, ID: encoded.ID, Before: encoded.Before}
+	for i, raw := range encoded.Keys {
+		var key any
+		switch encoded.Kinds[i] {
+		case "null":
+		case "int":
+			key, err = decodeKey[int64](raw)
+		case "string":
+			key, err = decodeKey[string](raw)
+		case "bool":
+			key, err = decodeKey[bool](raw)
+		case "time":
+			key, err = decodeKey[time.Time](raw)
+		default:
+			return nil, errInvalidCursor
+		}
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		c.Keys = append(c.Keys, key)
+	}
+	return c, nil
+}
+
+func decodeKey[T any](raw json.RawMessage) (any, error) {
+	var key T
+	err := json.Unmarshal(raw, &key)
+	return key, err
+}
+
+// KeysetPage finishes a page fetched by keyset: rows are the PageSize+1
+// rows that follow the cursor in the order of the query, the extra one only
+// tells there is more. It returns the page in sort order with the cursors of
+// the pages around it; key gives the sort keys and id of a row.
+func KeysetPage[T any](rows []T, f Filters, key func(T) ([]any, int64)) ([]T, Metadata) {
+	backward := f.Cursor != nil && f.Cursor.Before
+	more := len(rows) > f.PageSize
+	if more {
+		rows = rows[:f.PageSize]
+	}
+	if backward {
+		slices.Reverse(rows)
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+	if len(rows) == 0 {
+		return rows, metadata
+	}
+
+	cursor := func(row T, before bool) string {
+		keys, id := key(row)
+		return Cursor{Sort: f.Sort, Keys: keys, ID: id, Before: before}.String()
+	}
+	// a page reached from a cursor has rows on the side it came from
+	if more && !backward || backward {
+		metadata.Next = cursor(rows[len(rows)-1], false)
+	}
+	if more && backward || !backward && f.Cursor != nil {
+		metadata.Prev = cursor(rows[0], true)
+	}
+
+	return rows, metadata
+}
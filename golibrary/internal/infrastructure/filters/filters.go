@@ -1,6 +1,9 @@
 package filters
 
 import (
+	"fmt"
+	"net/url"
+	"strconv"
 	"strings" // New import
 	//"math" // New import
 
@@ -8,10 +11,107 @@ import (
 )
 
 type Filters struct {
-	Page         int
-	PageSize     int
+	Page     int
+	PageSize int
+	// Sort is a comma separated list of sort columns, each prefixed with -
+	// for descending, e.g. -year,title. Every one of them must be in
+	// SortSafelist, see Sortable.
 	Sort         string
 	SortSafelist []string
+	// Fields are the field filters of a listing by name, set by ReadFields.
+	// FieldSafelist names the fields the listing accepts and their kinds;
+	// the storages translate each field to a condition of their own.
+	Fields        map[string]any
+	FieldSafelist map[string]FieldKind
+	// Keyset pages by cursor instead of by page number, without counting
+	// the rows. Cursor is where the page starts, nil for the first page.
+	Keyset bool
+	Cursor *Cursor
+}
+
+// FieldKind is the type a field filter is parsed as.
+type FieldKind int
+
+const (
+	// Text fields are kept as trimmed strings.
+	Text FieldKind = iota
+	// Int fields are parsed as int64.
+	Int
+	// Bool fields are parsed with strconv.ParseBool.
+	Bool
+)
+
+// ReadFields reads the safelisted field filters from the query string.
+// Empty parameters are left out and values that don't parse as their kind
+// are reported to v.
+func (f *Filters) ReadFields(qs url.Values, v *validator.Validator) {
+	for name, kind := range f.FieldSafelist {
+		s := strings.TrimSpace(qs.Get(name))
+		if s == "" {
+			continue
+		}
+
+		var value any
+		switch kind {
+		case Int:
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				v.AddError(name, "must be an integer value")
+				continue
+			}
+			value = i
+		case Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				v.AddError(name, "must be true or false")
+				continue
+			}
+			value = b
+		default:
+			value = s
+		}
+
+		if f.Fields == nil {
+			f.Fields = make(map[string]any)
+		}
+		f.Fields[name] = value
+	}
+}
+
+// ReadCursor switches to keyset paging when the query string has a cursor
+// parameter, an empty one asks for the first page. The cursor must have
+// been made for the listing's sort, read Sort first.
+func (f *Filters) ReadCursor(qs url.Values, v *validator.Validator) {
+	if !qs.Has("cursor") {
+		return
+	}
+	f.Keyset = true
+	if s := qs.Get("cursor"); s != "" {
+		cursor, err := ParseCursor(s)
+		if err != nil || cursor.Sort != f.Sort {
+			v.AddError("cursor", "must be a cursor of this listing")
+			return
+		}
+		f.Cursor = cursor
+	}
+}
+
+// Text returns the value of a Text field filter and whether it is set.
+func (f Filters) Text(name string) (string, bool) {
+	s, ok := f.Fields[name].(string)
+	return s, ok
+}
+
+// Int returns the value of an Int field filter and whether it is set.
+func (f Filters) Int(name string) (int64, bool) {
+	i, ok := f.Fields[name].(int64)
+	return i, ok
+}
+
+// Bool returns the value of a Bool field filter and whether it is set.
+func (f Filters) Bool(name string) (bool, bool) {
+	b, ok := f.Fields[name].(bool)
+	return b, ok
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -20,27 +120,62 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
-}
-
-func (f Filters) SortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	terms := strings.Split(f.Sort, ",")
+	columns := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !validator.PermittedValue(term, f.SortSafelist...) {
+			v.AddError("sort", "invalid sort value")
+			break
 		}
+		columns = append(columns, strings.TrimPrefix(term, "-"))
 	}
+	v.Check(len(terms) <= MaxSortColumns, "sort", fmt.Sprintf("must be a maximum of %d columns", MaxSortColumns))
+	v.Check(validator.Unique(columns), "sort", "must not sort by a column twice")
 
-	panic("unsafe sort parameter: " + f.Sort)
+	for name := range f.Fields {
+		_, ok := f.FieldSafelist[name]
+		v.Check(ok, name, "is not a filter of this listing")
+	}
 }
 
-func (f Filters) SortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+// MaxSortColumns is the most columns a listing can be sorted by at once.
+const MaxSortColumns = 3
+
+// SortTerm is a column of a sort and its direction.
+type SortTerm struct {
+	Column string
+	Desc   bool
+}
+
+// Direction is the SQL direction of the term.
+func (t SortTerm) Direction() string {
+	if t.Desc {
 		return "DESC"
 	}
-
 	return "ASC"
 }
 
+// SortTerms splits Sort into its columns, in order. Check Sort with
+// ValidateFilters first, the storages look the columns up in their own
+// sort definitions.
+func (f Filters) SortTerms() []SortTerm {
+	terms := []SortTerm{}
+	for _, term := range strings.Split(f.Sort, ",") {
+		terms = append(terms, SortTerm{Column: strings.TrimPrefix(term, "-"), Desc: strings.HasPrefix(term, "-")})
+	}
+	return terms
+}
+
+// Sortable makes the sort safelist of a listing from its sort columns, each
+// of them ascending and descending.
+func Sortable(columns ...string) []string {
+	safelist := make([]string, 0, 2*len(columns))
+	for _, column := range columns {
+		safelist = append(safelist, column, "-"+column)
+	}
+	return safelist
+}
+
 func (f Filters) Limit() int {
 	return f.PageSize
 }
@@ -49,12 +184,16 @@ func (f Filters) Offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Metadata describes a page. Pages by number carry the counts, pages by
+// cursor only the cursors of the next and previous pages, if there are any.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	Next         string `json:"next,omitempty"`
+	Prev         string `json:"prev,omitempty"`
 }
 
 func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,154 @@
+package filters
+
+import (
+	"net/url"
+	"reflect"
+	"test/internal/infrastructure/validator"
+	"testing"
+	"time"
+)
+
+func TestReadFields(t *testing.T) {
+	safelist := map[string]FieldKind{"name": Text, "year_min": Int, "available": Bool}
+
+	tests := []struct {
+		name   string
+		qs     url.Values
+		want   map[string]any
+		errors []string
+	}{
+		{"none", url.Values{}, nil, nil},
+		{"parsed", url.Values{"name": {" lem "}, "year_min": {"1961"}, "available": {"true"}},
+			map[string]any{"name": "lem", "year_min": int64(1961), "available": true}, nil},
+		{"not safelisted", url.Values{"email": {"a@b.c"}}, nil, nil},
+		{"empty", url.Values{"name": {" "}}, nil, nil},
+		{"invalid", url.Values{"year_min": {"old"}, "available": {"maybe"}}, nil, []string{"available", "year_min"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{FieldSafelist: safelist}
+			v := validator.New()
+			f.ReadFields(tt.qs, v)
+			if !reflect.DeepEqual(f.Fields, tt.want) {
+				t.Errorf("ReadFields() = %v, want %v", f.Fields, tt.want)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("expected an error for %s, got %v", key, v.Err())
+				}
+			}
+			if len(v.Errors) != len(tt.errors) {
+				t.Errorf("unexpected errors %v", v.Err())
+			}
+		})
+	}
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	safelist := Sortable("id", "title", "year")
+	tests := []struct {
+		sort  string
+		valid bool
+	}{
+		{"id", true},
+		{"-year,title", true},
+		{"-year,title,-id", true},
+		{"name", false},
+		{"year,", false},
+		{"year,-year", false},
+		{"year,title,id,-id", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: safelist})
+			if v.Valid() != tt.valid {
+				t.Errorf("ValidateFilters(%q) errors %v", tt.sort, v.Err())
+			}
+		})
+	}
+
+	terms := Filters{Sort: "-year,title"}.SortTerms()
+	want := []SortTerm{{"year", true}, {"title", false}}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("SortTerms() = %v, want %v", terms, want)
+	}
+}
+
+func TestValidateFiltersFields(t *testing.T) {
+	f := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"},
+		FieldSafelist: map[string]FieldKind{"name": Text}, Fields: map[string]any{"email": "a@b.c"}}
+	v := validator.New()
+	if ValidateFilters(v, f); v.Valid() {
+		t.Error("expected a field outside the safelist to be invalid")
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	for _, keys := range [][]any{nil, {int64(1961)}, {"Solaris", true}, {at, nil}} {
+		c := Cursor{Sort: "-year", Keys: keys, ID: 7, Before: true}
+		got, err := ParseCursor(c.String())
+		if err != nil {
+			t.Fatalf("ParseCursor(%v): %v", keys, err)
+		}
+		if !reflect.DeepEqual(*got, c) {
+			t.Errorf("ParseCursor() = %+v, want %+v", *got, c)
+		}
+	}
+
+	for _, s := range []string{"!", "bm90IGpzb24", Cursor{Sort: "id"}.String()[:4]} {
+		if _, err := ParseCursor(s); err == nil {
+			t.Errorf("ParseCursor(%q) expected an error", s)
+		}
+	}
+}
+
+func TestReadCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		qs     url.Values
+		keyset bool
+		cursor bool
+		valid  bool
+	}{
+		{"offset", url.Values{}, false, false, true},
+		{"first page", url.Values{"cursor": {""}}, true, false, true},
+		{"next page", url.Values{"cursor": {Cursor{Sort: "-year", Keys: []any{int64(1961)}, ID: 3}.String()}}, true, true, true},
+		{"other sort", url.Values{"cursor": {Cursor{Sort: "title", Keys: []any{"Solaris"}, ID: 3}.String()}}, true, false, false},
+		{"garbage", url.Values{"cursor": {"garbage"}}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: "-year"}
+			v := validator.New()
+			f.ReadCursor(tt.qs, v)
+			if f.Keyset != tt.keyset || (f.Cursor != nil) != tt.cursor || v.Valid() != tt.valid {
+				t.Errorf("ReadCursor() keyset %v, cursor %v, errors %v", f.Keyset, f.Cursor, v.Err())
+			}
+		})
+	}
+}
+
+func TestKeysetPage(t *testing.T) {
+	key := func(i int64) ([]any, int64) { return nil, i }
+	f := Filters{PageSize: 2, Sort: "id"}
+
+	rows, metadata := KeysetPage([]int64{1, 2, 3}, f, key)
+	if !reflect.DeepEqual(rows, []int64{1, 2}) || metadata.Prev != "" || metadata.Next == "" {
+		t.Fatalf("first page = %v, %+v", rows, metadata)
+	}
+
+	f.Cursor, _ = ParseCursor(metadata.Next)
+	rows, metadata = KeysetPage([]int64{3}, f, key)
+	if !reflect.DeepEqual(rows, []int64{3}) || metadata.Prev == "" || metadata.Next != "" {
+		t.Fatalf("last page = %v, %+v", rows, metadata)
+	}
+
+	// going back the rows come in reverse
+	f.Cursor, _ = ParseCursor(metadata.Prev)
+	rows, metadata = KeysetPage([]int64{2, 1}, f, key)
+	if !reflect.DeepEqual(rows, []int64{1, 2}) || metadata.Prev != "" || metadata.Next == "" {
+		t.Fatalf("previous page = %v, %+v", rows, metadata)
+	}
+}
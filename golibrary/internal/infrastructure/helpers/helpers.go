@@ -6,24 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"test/internal/infrastructure/validator"
-
-	"github.com/go-chi/jwtauth/v5"
 )
 
-var TokenAuth *jwtauth.JWTAuth
-
-func init() {
-	TokenAuth = jwtauth.New("HS256", []byte("secret"), nil)
-
-	_, tokenString, _ := TokenAuth.Encode(map[string]interface{}{"user_id": 123})
-	fmt.Printf("DEBUG: a sample jwt is %s\n\n", tokenString)
-}
-
-func GenerateToken(name string) string {
-	_, tokenString, _ := TokenAuth.Encode(map[string]interface{}{"username": name})
-	return tokenString
-}
-
 func ReadString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 	if s == "" {
@@ -40,6 +24,23 @@ func ReadCSV(qs url.Values, key string, defaultValue []string) []string {
 	return strings.Split(csv, ",")
 }
 
+// LinkHeader makes an RFC 5988 Link header value that points at the next and
+// previous pages of a listing paged by cursor, the request URL with its
+// cursor replaced. It is empty when there are neither.
+func LinkHeader(u *url.URL, next, prev string) string {
+	links := []string{}
+	for _, link := range []struct{ cursor, rel string }{{next, "next"}, {prev, "prev"}} {
+		if link.cursor == "" {
+			continue
+		}
+		qs := u.Query()
+		qs.Set("cursor", link.cursor)
+		target := url.URL{Path: u.Path, RawQuery: qs.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, target.String(), link.rel))
+	}
+	return strings.Join(links, ", ")
+}
+
 func ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	s := qs.Get(key)
 	if s == "" {
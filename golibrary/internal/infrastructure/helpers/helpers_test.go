@@ -73,3 +73,23 @@ func TestReadInt(t *testing.T) {
 		})
 	}
 }
+
+func TestLinkHeader(t *testing.T) {
+	u, _ := url.Parse("http://localhost/books?cursor=a&sort=-year")
+	tests := []struct {
+		name       string
+		next, prev string
+		want       string
+	}{
+		{"none", "", "", ""},
+		{"next", "b", "", `</books?cursor=b&sort=-year>; rel="next"`},
+		{"both", "b", "c", `</books?cursor=b&sort=-year>; rel="next", </books?cursor=c&sort=-year>; rel="prev"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LinkHeader(u, tt.next, tt.prev); got != tt.want {
+				t.Errorf("LinkHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
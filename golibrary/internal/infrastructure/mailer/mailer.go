@@ -0,0 +1,67 @@
+// Package mailer delivers the mail the application sends. The default
+// writes the messages to a file instead of handing them to a mail server,
+// deployments that send real mail plug in their own Mailer.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text mail.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers mail.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FileMailer appends every message to a file, the way it would go over the
+// wire, and stands in for an SMTP server in development.
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	now  func() time.Time
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path, now: time.Now}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", m.now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n\r\n")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the messages carry reset tokens, only the owner may read them
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("mail file: %w", err)
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return fmt.Errorf("mail file: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.txt")
+	m := NewFileMailer(path)
+
+	for _, to := range []string{"carl@example.com", "sweet@example.com"} {
+		if err := m.Send(context.Background(), Message{From: "library@example.com", To: to, Subject: "Hi", Body: "first line\nsecond line"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{"To: carl@example.com\r\n", "To: sweet@example.com\r\n", "Subject: Hi\r\n", "\r\n\r\nfirst line\r\nsecond line\r\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the mail file, got %q", want, out)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a file only its owner reads, got %v (%v)", info.Mode(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Send(ctx, Message{To: "ryder@example.com"}); err == nil {
+		t.Error("expected a cancelled send to fail")
+	}
+}
@@ -3,7 +3,11 @@ package responder
 import (
 	"context"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"test/internal/db/dberrors"
+	"time"
 
 	"github.com/ptflp/godecoder"
 
@@ -23,6 +27,9 @@ type Responder interface {
 	ErrorUnauthorized(w http.ResponseWriter, err error)
 	ErrorBadRequest(w http.ResponseWriter, err error)
 	ErrorForbidden(w http.ResponseWriter, err error)
+	ErrorNotFound(w http.ResponseWriter, err error)
+	ErrorConflict(w http.ResponseWriter, err error)
+	ErrorTooManyRequests(w http.ResponseWriter, err error, retryAfter time.Duration)
 	ErrorInternal(w http.ResponseWriter, err error)
 }
 
@@ -69,6 +76,34 @@ func (r *Respond) ErrorForbidden(w http.ResponseWriter, err error) {
 	}
 }
 
+func (r *Respond) ErrorNotFound(w http.ResponseWriter, err error) {
+	r.log.Info("http response not found", zap.Error(err))
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := r.Encode(w, Response{
+		Success: false,
+		Message: err.Error(),
+		Data:    nil,
+	}); err != nil {
+		r.log.Error("response writer error on write", zap.Error(err))
+	}
+}
+
+// ErrorConflict answers requests the current state of a resource does not
+// allow, like renting a book without a free copy.
+func (r *Respond) ErrorConflict(w http.ResponseWriter, err error) {
+	r.log.Info("http response conflict", zap.Error(err))
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(http.StatusConflict)
+	if err := r.Encode(w, Response{
+		Success: false,
+		Message: err.Error(),
+		Data:    nil,
+	}); err != nil {
+		r.log.Error("response writer error on write", zap.Error(err))
+	}
+}
+
 func (r *Respond) ErrorUnauthorized(w http.ResponseWriter, err error) {
 	r.log.Warn("http resposne Unauthorized", zap.Error(err))
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
@@ -82,10 +117,30 @@ func (r *Respond) ErrorUnauthorized(w http.ResponseWriter, err error) {
 	}
 }
 
+// ErrorTooManyRequests answers clients that have to wait retryAfter before
+// trying again.
+func (r *Respond) ErrorTooManyRequests(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	r.log.Warn("http response too many requests", zap.Error(err))
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(math.Ceil(retryAfter.Seconds()), 1))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := r.Encode(w, Response{
+		Success: false,
+		Message: err.Error(),
+		Data:    nil,
+	}); err != nil {
+		r.log.Error("response writer error on write", zap.Error(err))
+	}
+}
+
 func (r *Respond) ErrorInternal(w http.ResponseWriter, err error) {
 	if errors.Is(err, context.Canceled) {
 		return
 	}
+	if dberrors.Temporary(err) {
+		r.errorUnavailable(w, err)
+		return
+	}
 	r.log.Error("http response internal error", zap.Error(err))
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	w.WriteHeader(http.StatusInternalServerError)
@@ -97,3 +152,19 @@ func (r *Respond) ErrorInternal(w http.ResponseWriter, err error) {
 		r.log.Error("response writer error on write", zap.Error(err))
 	}
 }
+
+// errorUnavailable answers database timeouts and serialization failures,
+// the client may simply retry those.
+func (r *Respond) errorUnavailable(w http.ResponseWriter, err error) {
+	r.log.Warn("http response service unavailable", zap.Error(err))
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := r.Encode(w, Response{
+		Success: false,
+		Message: "the database is busy, please retry",
+		Data:    nil,
+	}); err != nil {
+		r.log.Error("response writer error on write", zap.Error(err))
+	}
+}
@@ -1,7 +1,13 @@
 package responder
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"test/internal/db/dberrors"
 	"testing"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/ptflp/godecoder"
@@ -25,3 +31,48 @@ func TestResponder(t *testing.T) {
 		t.Fatal("r is nil")
 	}
 }
+
+func TestErrorInternalTemporary(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	r := NewResponder(decoder, zap.NewNop())
+
+	w := httptest.NewRecorder()
+	r.ErrorInternal(w, &dberrors.Error{Kind: dberrors.ErrTimeout, Err: context.DeadlineExceeded})
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a retryable 503, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.ErrorInternal(w, errors.New("boom"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+}
+
+func TestErrorTooManyRequests(t *testing.T) {
+	r := NewResponder(godecoder.NewDecoder(jsoniter.Config{}), zap.NewNop())
+
+	for retryAfter, want := range map[time.Duration]string{90 * time.Second: "90", 1500 * time.Millisecond: "2", -time.Second: "1"} {
+		w := httptest.NewRecorder()
+		r.ErrorTooManyRequests(w, errors.New("slow down"), retryAfter)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != want {
+			t.Errorf("%v: expected 429 with Retry-After %s, got %d %v", retryAfter, want, w.Code, w.Header())
+		}
+	}
+}
+
+func TestErrorNotFoundAndConflict(t *testing.T) {
+	r := NewResponder(godecoder.NewDecoder(jsoniter.Config{}), zap.NewNop())
+
+	w := httptest.NewRecorder()
+	r.ErrorNotFound(w, errors.New("book not found"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ErrorConflict(w, errors.New("this book cannot be rented"))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", w.Code)
+	}
+}
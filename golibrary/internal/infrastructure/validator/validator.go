@@ -1,8 +1,10 @@
 package validator
 
 import (
+	"errors"
 	"regexp"
 	"slices"
+	"strings"
 )
 
 var (
@@ -27,6 +29,26 @@ func (v *Validator) AddError(key, message string) {
 	}
 }
 
+// Err returns the collected errors as one error, sorted by key so the
+// message is stable, or nil when everything passed.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, key+" "+v.Errors[key])
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
 func (v *Validator) Check(ok bool, key, message string) {
 	if !ok {
 		v.AddError(key, message)
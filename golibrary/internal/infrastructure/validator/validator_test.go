@@ -14,3 +14,17 @@ func TestValidator(t *testing.T) {
 	}
 
 }
+
+func TestValidatorErr(t *testing.T) {
+	v := New()
+	if v.Err() != nil {
+		t.Fatal("expected no error from a valid validator")
+	}
+
+	v.AddError("sort", "invalid sort value")
+	v.AddError("page", "must be greater than zero")
+
+	if err := v.Err(); err == nil || err.Error() != "page must be greater than zero; sort invalid sort value" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
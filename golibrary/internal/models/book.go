@@ -1,9 +1,48 @@
 package models
 
+// Book is a bibliographic record, the physical items are its copies.
+// Available is set while at least one copy is on the shelf; the counts are
+// filled in by listings, lost copies don't count. The catalog details are
+// only filled in by the books endpoints, books nested in authors and users
+// leave them out.
 type Book struct {
-	ID        int64   `json:"id"`
-	Title     string  `json:"title"`
-	Author    *Author `json:"author"`
-	Year      int     `json:"year"`
-	Available bool    `json:"available"`
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	// Author is the first of the contributors.
+	Author          *Author `json:"author"`
+	Year            int     `json:"year"`
+	Available       bool    `json:"available"`
+	CopiesAvailable int     `json:"copies_available"`
+	CopiesTotal     int     `json:"copies_total"`
+	// ISBN is stored as a normalized ISBN-13, see NormalizeISBN.
+	ISBN        string `json:"isbn,omitempty"`
+	Publisher   string `json:"publisher,omitempty"`
+	Edition     string `json:"edition,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Pages       int    `json:"pages,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Genres are lower case genre and subject tags.
+	Genres []string `json:"genres,omitempty"`
+	// Contributors are everyone credited on the book in the order of the
+	// credits; like the catalog details, nested books leave them out.
+	Contributors []Contributor `json:"contributors,omitempty"`
+}
+
+// Contributor roles.
+const (
+	RoleAuthor      = "author"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+)
+
+// ContributorRoles are the roles an author can be credited with.
+var ContributorRoles = []string{RoleAuthor, RoleEditor, RoleTranslator, RoleIllustrator}
+
+// Contributor credits an author on a book. Position orders the credits,
+// starting from 1; an author can be credited once per role.
+type Contributor struct {
+	Author   *Author `json:"author"`
+	Role     string  `json:"role"`
+	Position int     `json:"position"`
 }
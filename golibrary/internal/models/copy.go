@@ -0,0 +1,28 @@
+package models
+
+// Copy statuses. Circulation moves a copy between available, on_loan and
+// on_hold; the others are set by librarians and take it out of circulation.
+const (
+	CopyAvailable = "available"
+	CopyOnLoan    = "on_loan"
+	CopyOnHold    = "on_hold"
+	CopyLost      = "lost"
+	CopyDamaged   = "damaged"
+	CopyInRepair  = "in_repair"
+)
+
+// Copy is one physical item of a book.
+type Copy struct {
+	ID        int64  `json:"id"`
+	BookID    int64  `json:"book_id"`
+	Barcode   string `json:"barcode"`
+	Condition string `json:"condition"`
+	Location  string `json:"location"`
+	Status    string `json:"status"`
+}
+
+// Circulating reports whether the status is managed by rents, returns and
+// holds rather than set by hand.
+func (c *Copy) Circulating() bool {
+	return c.Status == CopyOnLoan || c.Status == CopyOnHold
+}
@@ -3,9 +3,17 @@ package errors
 import "errors"
 
 var (
-	ErrUserNotFound   = errors.New("user not found")
-	ErrBookNotFound   = errors.New("book not found")
-	ErrAuthorNotFound = errors.New("author not found")
-	ErrRentInvalid    = errors.New("this book cannot be rented")
-	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrBookNotFound     = errors.New("book not found")
+	ErrAuthorNotFound   = errors.New("author not found")
+	ErrRentInvalid      = errors.New("this book cannot be rented")
+	ErrDuplicateEmail   = errors.New("duplicate email")
+	ErrRentBlocked      = errors.New("outstanding fines are over the limit")
+	ErrInvalidAmount    = errors.New("amount must be positive and at most the balance")
+	ErrHoldInvalid      = errors.New("this book cannot be put on hold")
+	ErrHoldNotFound     = errors.New("hold not found")
+	ErrCopyNotFound     = errors.New("copy not found")
+	ErrCopyInvalid      = errors.New("copies on loan or on hold can only change condition and location")
+	ErrDuplicateBarcode = errors.New("duplicate barcode")
+	ErrDuplicateISBN    = errors.New("duplicate isbn")
 )
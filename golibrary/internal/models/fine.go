@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Kinds of ledger entries.
+const (
+	LedgerFine    = "fine"
+	LedgerPayment = "payment"
+	LedgerWaiver  = "waiver"
+)
+
+// LedgerEntry is one line of a patron's fines ledger. Amounts are in cents:
+// fines are positive, payments and waivers negative, so the balance is
+// their sum. A fine belongs to the rental it was charged for and grows while
+// the book stays out.
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	RentalID  *int64    `json:"rental_id,omitempty"`
+	Kind      string    `json:"kind"`
+	Amount    int64     `json:"amount"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Hold statuses. A hold waits in the book's queue until the book comes back,
+// is ready for pickup until it expires and ends up fulfilled, expired or
+// cancelled.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldExpired   = "expired"
+	HoldCancelled = "cancelled"
+)
+
+// Hold is a patron's place in the queue for a book that is out. Position
+// counts from 1 for the hold that is ready or next in line and is 0 once
+// the hold is closed. A ready hold has the copy kept for the patron.
+type Hold struct {
+	ID        int64      `json:"id"`
+	Book      *Book      `json:"book,omitempty"`
+	Copy      *Copy      `json:"copy,omitempty"`
+	User      *User      `json:"user,omitempty"`
+	Status    string     `json:"status"`
+	Position  int        `json:"position"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// Active reports whether the hold is still in the queue.
+func (h *Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
@@ -0,0 +1,56 @@
+package models
+
+import "strings"
+
+// NormalizeISBN checks an ISBN-10 or ISBN-13 and returns it as ISBN-13
+// digits. Hyphens and spaces are ignored, an ISBN-10 may end in X. ok is
+// false when the length or the check digit is wrong.
+func NormalizeISBN(isbn string) (normalized string, ok bool) {
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			digit := int(c - '0')
+			switch {
+			case c == 'X' && i == 9:
+				digit = 10
+			case c < '0' || c > '9':
+				return "", false
+			}
+			sum += (10 - i) * digit
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + string(rune('0'+isbn13CheckDigit(isbn13))), true
+	case 13:
+		for _, c := range isbn {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+		}
+		if int(isbn[12]-'0') != isbn13CheckDigit(isbn[:12]) {
+			return "", false
+		}
+		return isbn, true
+	default:
+		return "", false
+	}
+}
+
+// isbn13CheckDigit computes the last digit of an ISBN-13 from the first 12,
+// which weigh 1 and 3 in turn.
+func isbn13CheckDigit(digits string) int {
+	sum := 0
+	for i, c := range digits[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(c-'0')
+	}
+	return (10 - sum%10) % 10
+}
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want string
+		ok   bool
+	}{
+		{"978-0-306-40615-7", "9780306406157", true},
+		{"9780306406157", "9780306406157", true},
+		{"0-306-40615-2", "9780306406157", true},
+		{"0 8044 2957 x", "9780804429573", true},
+		{"080442957X", "9780804429573", true},
+		{"978-0-306-40615-8", "", false},
+		{"0-306-40615-3", "", false},
+		{"X306406152", "", false},
+		{"97803064061", "", false},
+		{"978030640615a", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeISBN(tt.isbn)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, want %q, %v", tt.isbn, got, ok, tt.want, tt.ok)
+		}
+	}
+}
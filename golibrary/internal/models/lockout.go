@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Lockout is the failed logins of an account or of a client address within
+// the lockout period. LockedUntil is set while its logins are refused.
+type Lockout struct {
+	Account     string     `json:"account,omitempty"`
+	Address     string     `json:"address,omitempty"`
+	Failures    int        `json:"failures"`
+	Since       time.Time  `json:"since"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
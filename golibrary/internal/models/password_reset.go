@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// PasswordReset is a mailed token that lets a user set a new password
+// without knowing the old one. The token is only stored hashed and can be
+// used once before it expires.
+type PasswordReset struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// Active reports whether the token can still be used at now.
+func (r *PasswordReset) Active(now time.Time) bool {
+	return r.UsedAt == nil && now.Before(r.ExpiresAt)
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Popularity windows, the periods the rentals of a leaderboard are counted
+// over, up to now.
+const (
+	WindowWeek  = "7d"
+	WindowMonth = "30d"
+	WindowYear  = "365d"
+	WindowAll   = "all"
+)
+
+// PopularityWindows maps the windows to their lengths, 0 counts every rental
+// ever made.
+var PopularityWindows = map[string]time.Duration{
+	WindowWeek:  7 * 24 * time.Hour,
+	WindowMonth: 30 * 24 * time.Hour,
+	WindowYear:  365 * 24 * time.Hour,
+	WindowAll:   0,
+}
+
+// AuthorRank is an author's place on the leaderboard: the rentals of the
+// books the author is credited on in the window. Authors with as many
+// rentals share a rank.
+type AuthorRank struct {
+	Rank    int     `json:"rank"`
+	Rentals int64   `json:"rentals"`
+	Author  *Author `json:"author"`
+}
+
+// BookRank is a book's place on the leaderboard, see AuthorRank.
+type BookRank struct {
+	Rank    int   `json:"rank"`
+	Rentals int64 `json:"rentals"`
+	Book    *Book `json:"book"`
+}
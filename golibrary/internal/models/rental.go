@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Rental is one loan of a book. It stays open until ReturnedAt is set and is
+// kept afterwards as circulation history.
+type Rental struct {
+	ID         int64      `json:"id"`
+	Book       *Book      `json:"book,omitempty"`
+	Copy       *Copy      `json:"copy,omitempty"`
+	User       *User      `json:"user,omitempty"`
+	RentedAt   time.Time  `json:"rented_at"`
+	DueAt      time.Time  `json:"due_at"`
+	ReturnedAt *time.Time `json:"returned_at"`
+	// Overdue is set on open rentals past their due date when listed.
+	Overdue bool `json:"overdue"`
+}
+
+// Rental statuses a listing can be narrowed to, the empty status lists all.
+const (
+	RentalCurrent  = "current"
+	RentalReturned = "returned"
+)
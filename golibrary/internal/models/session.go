@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session is a login of a user. Its refresh token is only stored hashed and
+// is replaced on every refresh, PreviousHash is the hash it replaced. A
+// session ends when it is revoked or expires.
+type Session struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	TokenHash    string     `json:"-"`
+	PreviousHash string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session can still be refreshed at now.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -2,14 +2,35 @@ package models
 
 import (
 	"errors"
+	"slices"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// User roles, each one may do everything the roles before it may. Members
+// borrow and manage their own account, librarians run the catalog and the
+// desk and admins also manage the other accounts.
+const (
+	RoleMember    = "member"
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+)
+
+// UserRoles are the roles a user can have, the least privileged first.
+var UserRoles = []string{RoleMember, RoleLibrarian, RoleAdmin}
+
+// RoleIncludes reports whether a user with role may do what required
+// allows. Unknown roles include nothing.
+func RoleIncludes(role, required string) bool {
+	have, want := slices.Index(UserRoles, role), slices.Index(UserRoles, required)
+	return have >= 0 && want >= 0 && have >= want
+}
+
 type User struct {
 	ID          int64    `json:"id"`
 	Name        string   `json:"name"`
 	Email       string   `json:"email"`
+	Role        string   `json:"role,omitempty"`
 	RentedBooks []Book   `json:"rented_books,omitempty"`
 	Password    Password `json:"-"`
 	Deleted     bool     `json:"deleted"`
@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"test/internal/infrastructure/auth"
 	"test/internal/infrastructure/filters"
 	"test/internal/infrastructure/helpers"
 	"test/internal/infrastructure/responder"
@@ -22,10 +25,24 @@ type IBookController interface {
 	CreateAuthor(w http.ResponseWriter, r *http.Request)
 	ListUsers(w http.ResponseWriter, r *http.Request)
 	ListBooks(w http.ResponseWriter, r *http.Request)
+	SearchBooks(w http.ResponseWriter, r *http.Request)
 	ListAuthors(w http.ResponseWriter, r *http.Request)
 	ListTopRatedAuthors(w http.ResponseWriter, r *http.Request)
+	ListPopularBooks(w http.ResponseWriter, r *http.Request)
 	RentBook(w http.ResponseWriter, r *http.Request)
 	ReturnBook(w http.ResponseWriter, r *http.Request)
+	ListUserRentals(w http.ResponseWriter, r *http.Request)
+	ListBookRentals(w http.ResponseWriter, r *http.Request)
+	ListFines(w http.ResponseWriter, r *http.Request)
+	PayFine(w http.ResponseWriter, r *http.Request)
+	WaiveFine(w http.ResponseWriter, r *http.Request)
+	PlaceHold(w http.ResponseWriter, r *http.Request)
+	ListBookHolds(w http.ResponseWriter, r *http.Request)
+	ListUserHolds(w http.ResponseWriter, r *http.Request)
+	CancelHold(w http.ResponseWriter, r *http.Request)
+	AddCopy(w http.ResponseWriter, r *http.Request)
+	ListCopies(w http.ResponseWriter, r *http.Request)
+	UpdateCopy(w http.ResponseWriter, r *http.Request)
 }
 
 type BookController struct {
@@ -49,11 +66,20 @@ func (bc *BookController) CreateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	fmt.Println(book)
-	err = bc.service.CreateBook(&book)
+
+	v := validator.New()
+	if service.ValidateBook(v, &book); !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	err = bc.service.CreateBook(r.Context(), &book)
 	if err != nil {
 		switch {
 		case errors.Is(err, book_error.ErrAuthorNotFound):
 			bc.responder.ErrorInternal(w, errors.New("Author not found"))
+		case errors.Is(err, book_error.ErrDuplicateISBN):
+			bc.responder.ErrorBadRequest(w, err)
 		default:
 			bc.responder.ErrorInternal(w, errors.New("Internal server error"))
 		}
@@ -71,7 +97,7 @@ func (bc *BookController) CreateAuthor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = bc.service.CreateAuthor(author)
+	err = bc.service.CreateAuthor(r.Context(), author)
 	if err != nil {
 		bc.responder.ErrorInternal(w, err)
 		return
@@ -82,8 +108,6 @@ func (bc *BookController) CreateAuthor(w http.ResponseWriter, r *http.Request) {
 
 func (bc *BookController) ListUsers(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name  string
-		Email string
 		filters.Filters
 	}
 
@@ -95,25 +119,37 @@ func (bc *BookController) ListUsers(w http.ResponseWriter, r *http.Request) {
 	input.Filters.PageSize = helpers.ReadInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = helpers.ReadString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "email", "-id", "-name", "-email"}
+	input.Filters.SortSafelist = userSorts
 
 	if filters.ValidateFilters(v, input.Filters); !v.Valid() {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error1"))
+		bc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
 
-	users, metadata, err := bc.service.ListUsers(input.Filters)
+	input.Filters.FieldSafelist = userFields
+	input.Filters.ReadFields(qs, v)
+	input.Filters.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	users, metadata, err := bc.service.ListUsers(r.Context(), input.Filters)
 	if err != nil {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		}
 		return
 	}
+	setLinks(w, r, metadata)
 	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": users})
 }
 
 func (bc *BookController) ListBooks(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name  string
-		Email string
 		filters.Filters
 	}
 
@@ -125,55 +161,147 @@ func (bc *BookController) ListBooks(w http.ResponseWriter, r *http.Request) {
 	input.Filters.PageSize = helpers.ReadInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = helpers.ReadString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "email", "-id", "-name", "-email"}
+	input.Filters.SortSafelist = bookSorts
 
 	if filters.ValidateFilters(v, input.Filters); !v.Valid() {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error1"))
+		bc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
 
-	books, metadata, err := bc.service.ListBooks(input.Filters)
+	input.Filters.FieldSafelist = bookFields
+	input.Filters.ReadFields(qs, v)
+	input.Filters.ReadCursor(qs, v)
+	normalizeBookFields(v, &input.Filters)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	books, metadata, err := bc.service.ListBooks(r.Context(), input.Filters)
 	if err != nil {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		}
 		return
 	}
+	setLinks(w, r, metadata)
 	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": books})
 }
 
-func (bc *BookController) ListAuthors(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name  string
-		Email string
-		filters.Filters
+// badCursor answers a cursor the storage couldn't page with, one made for
+// another sort of the listing, like ReadCursor answers one it can't read.
+func (bc *BookController) badCursor(w http.ResponseWriter) {
+	v := validator.New()
+	v.AddError("cursor", "must be a cursor of this listing")
+	bc.responder.ErrorBadRequest(w, v.Err())
+}
+
+// setLinks points the Link header at the pages around a page read by cursor.
+func setLinks(w http.ResponseWriter, r *http.Request, metadata filters.Metadata) {
+	if links := helpers.LinkHeader(r.URL, metadata.Next, metadata.Prev); links != "" {
+		w.Header().Set("Link", links)
 	}
+}
 
+// SearchBooks searches titles, contributors and descriptions for q, the
+// most relevant books first.
+func (bc *BookController) SearchBooks(w http.ResponseWriter, r *http.Request) {
 	v := validator.New()
 
 	qs := r.URL.Query()
 
+	query := helpers.ReadString(qs, "q", "")
+	service.ValidateSearch(v, &query)
+
+	var input struct {
+		filters.Filters
+	}
 	input.Filters.Page = helpers.ReadInt(qs, "page", 1, v)
 	input.Filters.PageSize = helpers.ReadInt(qs, "page_size", 20, v)
-
-	input.Filters.Sort = helpers.ReadString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "email", "-id", "-name", "-email"}
+	// results are always ranked by relevance
+	input.Filters.Sort = "-relevance"
+	input.Filters.SortSafelist = []string{"-relevance"}
 
 	if filters.ValidateFilters(v, input.Filters); !v.Valid() {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error1"))
+		bc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
 
-	authors, metadata, err := bc.service.ListAuthors(input.Filters)
+	input.Filters.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	books, metadata, err := bc.service.SearchBooks(r.Context(), query, input.Filters)
 	if err != nil {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
 		return
 	}
-	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": authors})
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": books})
 }
 
-func (bc *BookController) ListTopRatedAuthors(w http.ResponseWriter, r *http.Request) {
+// The sort columns of the listings, each ascending or with - descending.
+var (
+	userSorts   = filters.Sortable("id", "name", "email")
+	bookSorts   = filters.Sortable("id", "title", "year", "author", "available")
+	authorSorts = filters.Sortable("id", "name", "times_ordered")
+)
+
+// The field filters of the listings, the storages translate each of them.
+var (
+	userFields = map[string]filters.FieldKind{
+		"name":  filters.Text,
+		"email": filters.Text,
+		"role":  filters.Text,
+	}
+	bookFields = map[string]filters.FieldKind{
+		"isbn":      filters.Text,
+		"publisher": filters.Text,
+		"language":  filters.Text,
+		"genre":     filters.Text,
+		"author_id": filters.Int,
+		"year_min":  filters.Int,
+		"year_max":  filters.Int,
+		"available": filters.Bool,
+	}
+	authorFields = map[string]filters.FieldKind{
+		"name": filters.Text,
+	}
+)
+
+// normalizeBookFields checks the catalog filters of a book listing and
+// normalizes them the way ValidateBook stores the values.
+func normalizeBookFields(v *validator.Validator, f *filters.Filters) {
+	if isbn, ok := f.Text("isbn"); ok {
+		normalized, valid := models.NormalizeISBN(isbn)
+		v.Check(valid, "isbn", "must be a valid ISBN-10 or ISBN-13")
+		f.Fields["isbn"] = normalized
+	}
+	if language, ok := f.Text("language"); ok {
+		language = strings.ToLower(language)
+		v.Check(validator.Matches(language, service.LanguageRX), "language", "must be an ISO 639 language code")
+		f.Fields["language"] = language
+	}
+	if genre, ok := f.Text("genre"); ok {
+		f.Fields["genre"] = strings.ToLower(genre)
+	}
+	yearMin, minSet := f.Int("year_min")
+	yearMax, maxSet := f.Int("year_max")
+	v.Check(!minSet || !maxSet || yearMin <= yearMax, "year_max", "must not be less than year_min")
+}
+
+func (bc *BookController) ListAuthors(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name  string
-		Email string
 		filters.Filters
 	}
 
@@ -185,21 +313,134 @@ func (bc *BookController) ListTopRatedAuthors(w http.ResponseWriter, r *http.Req
 	input.Filters.PageSize = helpers.ReadInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = helpers.ReadString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "email", "-id", "-name", "-email"}
+	input.Filters.SortSafelist = authorSorts
 
 	if filters.ValidateFilters(v, input.Filters); !v.Valid() {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error1"))
+		bc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
 
-	authors, metadata, err := bc.service.ListTopRatedAuthors(input.Filters)
+	input.Filters.FieldSafelist = authorFields
+	input.Filters.ReadFields(qs, v)
+	input.Filters.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	authors, metadata, err := bc.service.ListAuthors(r.Context(), input.Filters)
 	if err != nil {
-		bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, errors.New("Internal server error2"))
+		}
 		return
 	}
+	setLinks(w, r, metadata)
 	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": authors})
 }
 
+// leaderboardFilters reads the window and paging of a leaderboard, which is
+// always ranked by rentals, the last 30 days by default.
+func leaderboardFilters(r *http.Request, v *validator.Validator, fields map[string]filters.FieldKind) (string, filters.Filters) {
+	qs := r.URL.Query()
+
+	window := helpers.ReadString(qs, "window", models.WindowMonth)
+	v.Check(validator.PermittedValue(window, models.WindowWeek, models.WindowMonth, models.WindowYear, models.WindowAll),
+		"window", "must be 7d, 30d, 365d or all")
+
+	f := filters.Filters{
+		Page:          helpers.ReadInt(qs, "page", 1, v),
+		PageSize:      helpers.ReadInt(qs, "page_size", 20, v),
+		Sort:          "-rentals",
+		SortSafelist:  []string{"-rentals"},
+		FieldSafelist: fields,
+	}
+	filters.ValidateFilters(v, f)
+	f.ReadFields(qs, v)
+	f.ReadCursor(qs, v)
+
+	return window, f
+}
+
+// ListTopRatedAuthors is the leaderboard of the authors whose books were
+// rented the most in the window.
+func (bc *BookController) ListTopRatedAuthors(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	window, f := leaderboardFilters(r, v, authorFields)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	ranks, metadata, err := bc.service.RankAuthors(r.Context(), window, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": ranks})
+}
+
+// ListPopularBooks is the leaderboard of the books rented the most in the
+// window.
+func (bc *BookController) ListPopularBooks(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	window, f := leaderboardFilters(r, v, bookFields)
+	normalizeBookFields(v, &f)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	ranks, metadata, err := bc.service.RankBooks(r.Context(), window, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": ranks})
+}
+
+// patron reads whom a request borrows, returns or queues for: the userID
+// form value, or the caller when there is none. Only librarians act for
+// somebody else.
+func (bc *BookController) patron(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return 0, false
+	}
+
+	claims, _ := auth.FromContext(r.Context())
+	userID := claims.UserID
+	if raw := r.FormValue("userID"); raw != "" {
+		userID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			bc.responder.ErrorBadRequest(w, err)
+			return 0, false
+		}
+	}
+	if !auth.Allowed(r.Context(), userID, models.RoleLibrarian) {
+		bc.responder.ErrorForbidden(w, auth.ErrForbidden)
+		return 0, false
+	}
+
+	return userID, true
+}
+
 func (bc *BookController) RentBook(w http.ResponseWriter, r *http.Request) {
 	rawID := chi.URLParam(r, "bookID")
 
@@ -209,58 +450,502 @@ func (bc *BookController) RentBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = r.ParseForm()
+	userID, ok := bc.patron(w, r)
+	if !ok {
+		return
+	}
+
+	err = bc.service.RentBook(r.Context(), int64(bookID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrRentBlocked):
+			bc.responder.ErrorForbidden(w, err)
+		case errors.Is(err, book_error.ErrRentInvalid):
+			bc.responder.ErrorConflict(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+
+	bc.responder.OutputJSON(w, "Book rented successfully")
+}
+
+func (bc *BookController) ReturnBook(w http.ResponseWriter, r *http.Request) {
+	rawID := chi.URLParam(r, "bookID")
+
+	bookID, err := strconv.Atoi(rawID)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	userID, ok := bc.patron(w, r)
+	if !ok {
+		return
+	}
+
+	err = bc.service.ReturnBook(r.Context(), int64(bookID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrBookNotFound):
+			bc.responder.ErrorNotFound(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+
+	bc.responder.OutputJSON(w, "Book returned successfully")
+
+}
+
+// rentalFilters reads the paging and sorting of a rental listing, newest
+// rentals come first by default.
+func rentalFilters(r *http.Request, v *validator.Validator) filters.Filters {
+	qs := r.URL.Query()
+
+	f := filters.Filters{
+		Page:         helpers.ReadInt(qs, "page", 1, v),
+		PageSize:     helpers.ReadInt(qs, "page_size", 20, v),
+		Sort:         helpers.ReadString(qs, "sort", "-rented_at"),
+		SortSafelist: filters.Sortable("id", "rented_at", "due_at", "returned_at"),
+	}
+	filters.ValidateFilters(v, f)
+	f.ReadCursor(qs, v)
+	// open rentals have no return date for a cursor to start from
+	if f.Keyset && v.Valid() {
+		for _, term := range f.SortTerms() {
+			v.Check(term.Column != "returned_at", "sort", "returned_at can't be paged by cursor")
+		}
+	}
+
+	return f
+}
+
+// ListUserRentals lists what a user has borrowed. status=current keeps the
+// books still out, status=returned the ones brought back.
+func (bc *BookController) ListUserRentals(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	v := validator.New()
+	status := helpers.ReadString(r.URL.Query(), "status", "")
+	v.Check(validator.PermittedValue(status, "", models.RentalCurrent, models.RentalReturned), "status", "must be current or returned")
+	f := rentalFilters(r, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	rentals, metadata, err := bc.service.ListUserRentals(r.Context(), userID, status, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": rentals})
+}
+
+// ListBookRentals lists who borrowed a book, the latest reader first.
+func (bc *BookController) ListBookRentals(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(chi.URLParam(r, "bookID"), 10, 64)
 	if err != nil {
 		bc.responder.ErrorBadRequest(w, err)
 		return
 	}
 
-	rawID = r.FormValue("userID")
+	v := validator.New()
+	f := rentalFilters(r, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	rentals, metadata, err := bc.service.ListBookRentals(r.Context(), bookID, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": rentals})
+}
 
-	userID, err := strconv.Atoi(rawID)
+// ListFines shows what a user owes and the fines, payments and waivers
+// behind it, newest first.
+func (bc *BookController) ListFines(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		bc.responder.ErrorBadRequest(w, err)
 		return
 	}
 
-	err = bc.service.RentBook(int64(bookID), int64(userID))
+	v := validator.New()
+	qs := r.URL.Query()
+	f := filters.Filters{
+		Page:         helpers.ReadInt(qs, "page", 1, v),
+		PageSize:     helpers.ReadInt(qs, "page_size", 20, v),
+		Sort:         helpers.ReadString(qs, "sort", "-created_at"),
+		SortSafelist: filters.Sortable("id", "created_at", "amount"),
+	}
+	filters.ValidateFilters(v, f)
+	f.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	balance, err := bc.service.FineBalance(r.Context(), userID)
 	if err != nil {
 		bc.responder.ErrorInternal(w, err)
 		return
 	}
+	entries, metadata, err := bc.service.ListLedger(r.Context(), userID, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"balance": balance, "metadata": metadata, "data": entries})
+}
 
-	bc.responder.OutputJSON(w, "Book rented successfully")
+// PayFine records a payment against the user's balance.
+func (bc *BookController) PayFine(w http.ResponseWriter, r *http.Request) {
+	bc.credit(w, r, bc.service.PayFine, false)
 }
 
-func (bc *BookController) ReturnBook(w http.ResponseWriter, r *http.Request) {
-	rawID := chi.URLParam(r, "bookID")
+// WaiveFine forgives part of the user's balance, or all of it when no
+// amount is given.
+func (bc *BookController) WaiveFine(w http.ResponseWriter, r *http.Request) {
+	bc.credit(w, r, bc.service.WaiveFine, true)
+}
 
-	bookID, err := strconv.Atoi(rawID)
+type creditFunc func(ctx context.Context, userID, amount int64, note string) (*models.LedgerEntry, error)
+
+func (bc *BookController) credit(w http.ResponseWriter, r *http.Request, credit creditFunc, wholeBalance bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		bc.responder.ErrorBadRequest(w, err)
 		return
 	}
 
-	err = r.ParseForm()
+	var input struct {
+		Amount int64  `json:"amount"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	v := validator.New()
+	if wholeBalance {
+		v.Check(input.Amount >= 0, "amount", "must not be negative")
+	} else {
+		v.Check(input.Amount > 0, "amount", "must be greater than zero")
+	}
+	v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	entry, err := credit(r.Context(), userID, input.Amount, input.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrInvalidAmount),
+			errors.Is(err, book_error.ErrUserNotFound):
+			bc.responder.ErrorBadRequest(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	bc.responder.OutputJSON(w, entry)
+}
+
+// PlaceHold queues a user for a book that is out. The response has the
+// hold's place in the queue.
+func (bc *BookController) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(chi.URLParam(r, "bookID"), 10, 64)
 	if err != nil {
 		bc.responder.ErrorBadRequest(w, err)
 		return
 	}
 
-	rawID = r.FormValue("userID")
+	userID, ok := bc.patron(w, r)
+	if !ok {
+		return
+	}
 
-	userID, err := strconv.Atoi(rawID)
+	hold, err := bc.service.PlaceHold(r.Context(), bookID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrHoldInvalid):
+			bc.responder.ErrorBadRequest(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	bc.responder.OutputJSON(w, hold)
+}
+
+// ListBookHolds lists the queue of a book, the next patron first.
+func (bc *BookController) ListBookHolds(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(chi.URLParam(r, "bookID"), 10, 64)
 	if err != nil {
 		bc.responder.ErrorBadRequest(w, err)
 		return
 	}
 
-	err = bc.service.ReturnBook(int64(bookID), int64(userID))
+	v := validator.New()
+	qs := r.URL.Query()
+	f := filters.Filters{
+		Page:         helpers.ReadInt(qs, "page", 1, v),
+		PageSize:     helpers.ReadInt(qs, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+	filters.ValidateFilters(v, f)
+	f.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	holds, metadata, err := bc.service.ListBookHolds(r.Context(), bookID, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": holds})
+}
+
+// ListUserHolds lists a user's holds with their places in the queues, a
+// hold with status ready has its book waiting until expires_at.
+func (bc *BookController) ListUserHolds(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	status := helpers.ReadString(qs, "status", "")
+	v.Check(validator.PermittedValue(status, "", models.HoldWaiting, models.HoldReady, models.HoldFulfilled, models.HoldExpired, models.HoldCancelled),
+		"status", "must be waiting, ready, fulfilled, expired or cancelled")
+	f := filters.Filters{
+		Page:         helpers.ReadInt(qs, "page", 1, v),
+		PageSize:     helpers.ReadInt(qs, "page_size", 20, v),
+		Sort:         helpers.ReadString(qs, "sort", "-created_at"),
+		SortSafelist: filters.Sortable("id", "created_at"),
+	}
+	filters.ValidateFilters(v, f)
+	f.ReadCursor(qs, v)
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	holds, metadata, err := bc.service.ListUserHolds(r.Context(), userID, status, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrCursorMismatch):
+			bc.badCursor(w)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	setLinks(w, r, metadata)
+	bc.responder.OutputJSON(w, map[string]interface{}{"metadata": metadata, "data": holds})
+}
+
+// CancelHold takes a user out of a book's queue.
+func (bc *BookController) CancelHold(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+	holdID, err := strconv.ParseInt(chi.URLParam(r, "holdID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	err = bc.service.CancelHold(r.Context(), userID, holdID)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrHoldNotFound):
+			bc.responder.ErrorBadRequest(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	bc.responder.OutputJSON(w, "Hold cancelled")
+}
+
+// AddCopy registers a physical copy of a book. It goes on the shelf, or to
+// the first patron waiting for the book, unless another status is given.
+func (bc *BookController) AddCopy(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(chi.URLParam(r, "bookID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	var input struct {
+		Barcode   string `json:"barcode"`
+		Condition string `json:"condition"`
+		Location  string `json:"location"`
+		Status    string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	v := validator.New()
+	validateCopy(v, &input.Barcode, &input.Condition, &input.Location, &input.Status)
+	v.Check(input.Barcode != "", "barcode", "must be provided")
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	copy := &models.Copy{
+		BookID:    bookID,
+		Barcode:   input.Barcode,
+		Condition: input.Condition,
+		Location:  input.Location,
+		Status:    input.Status,
+	}
+	err = bc.service.AddCopy(r.Context(), copy)
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrBookNotFound),
+			errors.Is(err, book_error.ErrDuplicateBarcode),
+			errors.Is(err, book_error.ErrCopyInvalid):
+			bc.responder.ErrorBadRequest(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	bc.responder.OutputJSON(w, copy)
+}
+
+// ListCopies lists the copies of a book with their status.
+func (bc *BookController) ListCopies(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(chi.URLParam(r, "bookID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	copies, err := bc.service.ListCopies(r.Context(), bookID)
 	if err != nil {
 		bc.responder.ErrorInternal(w, err)
 		return
 	}
+	bc.responder.OutputJSON(w, map[string]interface{}{"data": copies})
+}
 
-	bc.responder.OutputJSON(w, "Book returned successfully")
+// UpdateCopy changes the fields of a copy that are given. Librarians mark
+// copies lost, damaged or in repair and put them back as available.
+func (bc *BookController) UpdateCopy(w http.ResponseWriter, r *http.Request) {
+	copyID, err := strconv.ParseInt(chi.URLParam(r, "copyID"), 10, 64)
+	if err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
 
+	var input struct {
+		Barcode   *string `json:"barcode"`
+		Condition *string `json:"condition"`
+		Location  *string `json:"location"`
+		Status    *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		bc.responder.ErrorBadRequest(w, err)
+		return
+	}
+
+	v := validator.New()
+	validateCopy(v, input.Barcode, input.Condition, input.Location, input.Status)
+	if input.Barcode != nil {
+		v.Check(*input.Barcode != "", "barcode", "must not be empty")
+	}
+	if input.Status != nil {
+		v.Check(*input.Status != "", "status", "must not be empty")
+	}
+	if !v.Valid() {
+		bc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	copy, err := bc.service.UpdateCopy(r.Context(), copyID, service.CopyUpdate{
+		Barcode:   input.Barcode,
+		Condition: input.Condition,
+		Location:  input.Location,
+		Status:    input.Status,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, book_error.ErrCopyNotFound),
+			errors.Is(err, book_error.ErrDuplicateBarcode),
+			errors.Is(err, book_error.ErrCopyInvalid):
+			bc.responder.ErrorBadRequest(w, err)
+		default:
+			bc.responder.ErrorInternal(w, err)
+		}
+		return
+	}
+	bc.responder.OutputJSON(w, copy)
+}
+
+// validateCopy checks the copy fields that are given, nil ones are skipped.
+// Only the statuses librarians set by hand are accepted.
+func validateCopy(v *validator.Validator, barcode, condition, location, status *string) {
+	if barcode != nil {
+		v.Check(len(*barcode) <= 64, "barcode", "must not be more than 64 bytes long")
+	}
+	if condition != nil {
+		v.Check(len(*condition) <= 100, "condition", "must not be more than 100 bytes long")
+	}
+	if location != nil {
+		v.Check(len(*location) <= 100, "location", "must not be more than 100 bytes long")
+	}
+	if status != nil {
+		v.Check(validator.PermittedValue(*status, "", models.CopyAvailable, models.CopyLost, models.CopyDamaged, models.CopyInRepair),
+			"status", "must be available, lost, damaged or in_repair")
+	}
 }
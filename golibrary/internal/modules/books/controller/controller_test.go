@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"test/internal/db/memory"
+	"test/internal/infrastructure/auth"
+	"test/internal/infrastructure/responder"
+	"test/internal/models"
+	"test/internal/modules/books/service"
+	"testing"
+
+	"github.com/go-chi/chi"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/ptflp/godecoder"
+	"go.uber.org/zap"
+)
+
+func TestRentAndReturnHandlers(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	users := memory.NewUserStorage(store)
+	for _, name := range []string{"carl", "dana"} {
+		if err := users.Insert(ctx, &models.User{Name: name, Email: name + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	books := service.NewBookService(memory.NewBookStorage(store), service.Policy{})
+	author := &models.Author{Name: "Stanislaw Lem"}
+	if err := books.CreateAuthor(ctx, author); err != nil {
+		t.Fatal(err)
+	}
+	book := &models.Book{Title: "Solaris", Year: 1961, Author: &models.Author{ID: author.ID}}
+	if err := books.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.AddCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK1"}); err != nil {
+		t.Fatal(err)
+	}
+	controller := NewBookController(responder.NewResponder(godecoder.NewDecoder(jsoniter.Config{}), zap.NewNop()), books)
+
+	serve := func(handler http.HandlerFunc, bookID string, claims auth.Claims) int {
+		req := httptest.NewRequest("POST", "/books/"+bookID, nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("bookID", bookID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), claims))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	carl := auth.Claims{UserID: 1, Role: models.RoleMember}
+	dana := auth.Claims{UserID: 2, Role: models.RoleMember}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		bookID  string
+		claims  auth.Claims
+		code    int
+	}{
+		{"unknown book rented", controller.RentBook, "42", carl, http.StatusConflict},
+		{"bad book id", controller.RentBook, "solaris", carl, http.StatusBadRequest},
+		{"not renting returns", controller.ReturnBook, "1", carl, http.StatusNotFound},
+		{"rented", controller.RentBook, "1", carl, http.StatusOK},
+		{"rented twice", controller.RentBook, "1", carl, http.StatusConflict},
+		{"no free copy", controller.RentBook, "1", dana, http.StatusConflict},
+		{"returned", controller.ReturnBook, "1", carl, http.StatusOK},
+		{"returned twice", controller.ReturnBook, "1", carl, http.StatusNotFound},
+		{"rented after return", controller.RentBook, "1", dana, http.StatusOK},
+	} {
+		if code := serve(tc.handler, tc.bookID, tc.claims); code != tc.code {
+			t.Errorf("%s: expected status code %d but got %d", tc.name, tc.code, code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"test/internal/db"
+	"test/internal/db/dberrors"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+
+	"go.uber.org/zap"
+)
+
+// copyCounts are the available and total copies of the book in the row,
+// lost copies are not counted.
+const copyCounts = `(SELECT count(*) FROM copies WHERE copies.book_id = books.id AND copies.status = 'available'),
+        (SELECT count(*) FROM copies WHERE copies.book_id = books.id AND copies.status <> 'lost')`
+
+func (bs *BookStorage) InsertCopy(ctx context.Context, copy *models.Copy) error {
+	query := `
+        INSERT INTO copies (book_id, barcode, ` + bs.quote("condition") + `, location, status)
+        VALUES (?, ?, ?, ?, ?)`
+
+	args := []any{copy.BookID, copy.Barcode, copy.Condition, copy.Location, copy.Status}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var err error
+	copy.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
+	if err != nil {
+		err = dberrors.Classify(err)
+		switch {
+		case errors.Is(err, dberrors.ErrUniqueViolation):
+			return book_errors.ErrDuplicateBarcode
+		case errors.Is(err, dberrors.ErrForeignKeyViolation):
+			return book_errors.ErrBookNotFound
+		default:
+			bs.logger.Error("error on inserting a copy", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bs *BookStorage) GetCopy(ctx context.Context, copyID int64) (*models.Copy, error) {
+	query := `
+        SELECT ` + bs.copyColumns() + `
+        FROM copies
+        WHERE copies.id = ?`
+
+	return bs.getCopy(ctx, query, copyID)
+}
+
+// AvailableCopy returns the first copy of the book that is on the shelf.
+func (bs *BookStorage) AvailableCopy(ctx context.Context, bookID int64) (*models.Copy, error) {
+	query := `
+        SELECT ` + bs.copyColumns() + `
+        FROM copies
+        WHERE copies.book_id = ? AND copies.status = 'available'
+        ORDER BY copies.id
+        LIMIT 1`
+
+	return bs.getCopy(ctx, query, bookID)
+}
+
+func (bs *BookStorage) getCopy(ctx context.Context, query string, args ...any) (*models.Copy, error) {
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var copy models.Copy
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), args...).Scan(
+		&copy.ID,
+		&copy.BookID,
+		&copy.Barcode,
+		&copy.Condition,
+		&copy.Location,
+		&copy.Status,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, book_errors.ErrCopyNotFound
+		default:
+			bs.logger.Error("error on getting a copy", zap.Error(err))
+			return nil, dberrors.Classify(err)
+		}
+	}
+
+	return &copy, nil
+}
+
+// UpdateCopy saves the barcode, condition, location and status of the copy.
+func (bs *BookStorage) UpdateCopy(ctx context.Context, copy *models.Copy) error {
+	query := `
+        UPDATE copies
+        SET barcode = ?, ` + bs.quote("condition") + ` = ?, location = ?, status = ?
+        WHERE id = ?`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	result, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), copy.Barcode, copy.Condition, copy.Location, copy.Status, copy.ID)
+	if err != nil {
+		err = dberrors.Classify(err)
+		switch {
+		case errors.Is(err, dberrors.ErrUniqueViolation):
+			return book_errors.ErrDuplicateBarcode
+		default:
+			bs.logger.Error("error on updating a copy", zap.Error(err))
+			return err
+		}
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return book_errors.ErrCopyNotFound
+	}
+
+	return nil
+}
+
+// ListCopies lists the copies of the book in the order they were added.
+func (bs *BookStorage) ListCopies(ctx context.Context, bookID int64) ([]*models.Copy, error) {
+	query := `
+        SELECT ` + bs.copyColumns() + `
+        FROM copies
+        WHERE copies.book_id = ?
+        ORDER BY copies.id`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), bookID)
+	if err != nil {
+		bs.logger.Error("error on listing copies", zap.Error(err))
+		return nil, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	copies := []*models.Copy{}
+	for rows.Next() {
+		var copy models.Copy
+		err := rows.Scan(
+			&copy.ID,
+			&copy.BookID,
+			&copy.Barcode,
+			&copy.Condition,
+			&copy.Location,
+			&copy.Status,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a copy", zap.Error(err))
+			return nil, err
+		}
+
+		copies = append(copies, &copy)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+// SyncAvailable sets the book's available flag from the status of its
+// copies. Callers hold the book lock.
+func (bs *BookStorage) SyncAvailable(ctx context.Context, bookID int64) error {
+	query := `
+        UPDATE books
+        SET available = EXISTS (SELECT 1 FROM copies WHERE copies.book_id = books.id AND copies.status = 'available')
+        WHERE id = ?`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	_, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), bookID)
+	if err != nil {
+		bs.logger.Error("error on updating book availability", zap.Error(err))
+		return dberrors.Classify(err)
+	}
+
+	return nil
+}
+
+func (bs *BookStorage) copyColumns() string {
+	return `copies.id, copies.book_id, copies.barcode, copies.` + bs.quote("condition") + `, copies.location, copies.status`
+}
+
+// quote quotes a column name that is a reserved word in mysql.
+func (bs *BookStorage) quote(column string) string {
+	if bs.q.DriverName() == "mysql" {
+		return "`" + column + "`"
+	}
+	return column
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	filter "test/internal/infrastructure/filters"
+)
+
+// fieldCondition turns the value of a field filter into a condition with ?
+// placeholders and its arguments.
+type fieldCondition func(value any) (string, []any)
+
+func equals(column string) fieldCondition {
+	return func(value any) (string, []any) { return column + " = ?", []any{value} }
+}
+
+func atLeast(column string) fieldCondition {
+	return func(value any) (string, []any) { return column + " >= ?", []any{value} }
+}
+
+func atMost(column string) fieldCondition {
+	return func(value any) (string, []any) { return column + " <= ?", []any{value} }
+}
+
+// contains matches a case-insensitive substring of a text column.
+func contains(column string) fieldCondition {
+	return func(value any) (string, []any) {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(value.(string))) + "%"
+		return "LOWER(" + column + ") LIKE ? ESCAPE '!'", []any{pattern}
+	}
+}
+
+// exists matches rows for which a subquery with one placeholder finds a row.
+func exists(subquery string) fieldCondition {
+	return func(value any) (string, []any) { return "EXISTS (" + subquery + ")", []any{value} }
+}
+
+var bookConditions = map[string]fieldCondition{
+	"isbn":      equals("books.isbn"),
+	"publisher": equals("books.publisher"),
+	"language":  equals("books.language"),
+	"genre":     exists("SELECT 1 FROM book_genres WHERE book_genres.book_id = books.id AND book_genres.genre = ?"),
+	"author_id": exists("SELECT 1 FROM book_contributors WHERE book_contributors.book_id = books.id AND book_contributors.author_id = ?"),
+	"year_min":  atLeast("books.year"),
+	"year_max":  atMost("books.year"),
+	"available": equals("books.available"),
+}
+
+var authorConditions = map[string]fieldCondition{
+	"name": contains("authors.name"),
+}
+
+var userConditions = map[string]fieldCondition{
+	"name":  contains("users.name"),
+	"email": equals("users.email"),
+	"role":  equals("users.role"),
+}
+
+// where joins the conditions of the field filters that are set, in name
+// order so the same filters always give the same query.
+func where(filters filter.Filters, conditions map[string]fieldCondition) (string, []any, error) {
+	names := make([]string, 0, len(filters.Fields))
+	for name := range filters.Fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	clauses, args := []string{"1 = 1"}, []any{}
+	for _, name := range names {
+		condition, ok := conditions[name]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown filter %q", name)
+		}
+		clause, clauseArgs := condition(filters.Fields[name])
+		clauses, args = append(clauses, clause), append(args, clauseArgs...)
+	}
+
+	return strings.Join(clauses, " AND "), args, nil
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"test/internal/db"
+	"test/internal/db/dberrors"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+
+	"go.uber.org/zap"
+)
+
+// SetFine charges amount for the rental, replacing what was charged for it
+// before. Callers hold the rental's book lock, so the update and insert don't
+// race.
+func (bs *BookStorage) SetFine(ctx context.Context, rental *models.Rental, amount int64, at time.Time) error {
+	query := `
+        UPDATE fines
+        SET amount = ?, updated_at = ?
+        WHERE rental_id = ?`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	result, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), amount, at, rental.ID)
+	if err != nil {
+		bs.logger.Error("error on updating a fine", zap.Error(err))
+		return dberrors.Classify(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	rentalID := rental.ID
+	return bs.InsertLedgerEntry(ctx, &models.LedgerEntry{
+		UserID:    rental.User.ID,
+		RentalID:  &rentalID,
+		Kind:      models.LedgerFine,
+		Amount:    amount,
+		CreatedAt: at,
+		UpdatedAt: at,
+	})
+}
+
+func (bs *BookStorage) InsertLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error {
+	query := `
+        INSERT INTO fines (user_id, rental_id, kind, amount, note, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	args := []any{entry.UserID, entry.RentalID, entry.Kind, entry.Amount, entry.Note, entry.CreatedAt, entry.UpdatedAt}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var err error
+	entry.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
+	if err != nil {
+		bs.logger.Error("error on inserting into fines", zap.Error(err))
+		return dberrors.Classify(err)
+	}
+
+	return nil
+}
+
+// LockUser locks the user's row until the surrounding transaction ends, so
+// concurrent payments and waivers of the same user read the balance one
+// after another.
+func (bs *BookStorage) LockUser(ctx context.Context, userID int64) error {
+	query := `
+        SELECT id
+        FROM users
+        WHERE id = ?` + db.ForUpdate(bs.q)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var id int64
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), userID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return book_errors.ErrUserNotFound
+		default:
+			bs.logger.Error("error on locking a user", zap.Error(err))
+			return dberrors.Classify(err)
+		}
+	}
+
+	return nil
+}
+
+// FineBalance is what the user owes, in cents.
+func (bs *BookStorage) FineBalance(ctx context.Context, userID int64) (int64, error) {
+	query := `
+        SELECT COALESCE(SUM(amount), 0)
+        FROM fines
+        WHERE user_id = ?`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var balance int64
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), userID).Scan(&balance)
+	if err != nil {
+		bs.logger.Error("error on summing fines", zap.Error(err))
+		return 0, dberrors.Classify(err)
+	}
+
+	return balance, nil
+}
+
+var ledgerSortKeys = sortKeys[*models.LedgerEntry]{
+	"id":         {"id", func(e *models.LedgerEntry) any { return e.ID }},
+	"created_at": {"created_at", func(e *models.LedgerEntry) any { return e.CreatedAt }},
+	"amount":     {"amount", func(e *models.LedgerEntry) any { return e.Amount }},
+}
+
+func ledgerEntryID(e *models.LedgerEntry) int64 { return e.ID }
+
+func (bs *BookStorage) ListLedger(ctx context.Context, userID int64, filters filter.Filters) ([]*models.LedgerEntry, filter.Metadata, error) {
+	paging, err := newPaging(filters, ledgerSortKeys, "id", ledgerEntryID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, id, user_id, rental_id, kind, amount, note, created_at, updated_at
+        FROM fines
+        WHERE user_id = ? AND %s
+        ORDER BY %s
+        %s`, paging.count, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args := append([]any{userID}, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on listing fines", zap.Error(err))
+		return nil, filter.Metadata{}, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*models.LedgerEntry{}
+
+	for rows.Next() {
+		var entry models.LedgerEntry
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.UserID,
+			&entry.RentalID,
+			&entry.Kind,
+			&entry.Amount,
+			&entry.Note,
+			&entry.CreatedAt,
+			&entry.UpdatedAt,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a ledger entry", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	entries, metadata := paging.page(entries, totalRecords)
+	return entries, metadata, nil
+}
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"test/internal/db"
+	"test/internal/db/dberrors"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+
+	"go.uber.org/zap"
+)
+
+const holdColumns = `holds.id, holds.status, holds.created_at, holds.ready_at, holds.expires_at, holds.closed_at, holds.copy_id`
+
+// holdPosition counts the active holds of the same book up to this one, the
+// queue is served in id order so that is the hold's place in it.
+const holdPosition = `CASE WHEN holds.status IN ('waiting', 'ready') THEN (
+            SELECT count(*) FROM holds queue
+            WHERE queue.book_id = holds.book_id AND queue.status IN ('waiting', 'ready') AND queue.id <= holds.id)
+        ELSE 0 END`
+
+func (bs *BookStorage) InsertHold(ctx context.Context, hold *models.Hold) error {
+	query := `
+        INSERT INTO holds (book_id, user_id, status, created_at)
+        VALUES (?, ?, ?, ?)`
+
+	args := []any{hold.Book.ID, hold.User.ID, hold.Status, hold.CreatedAt}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var err error
+	hold.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
+	if err != nil {
+		err = dberrors.Classify(err)
+		switch {
+		case errors.Is(err, dberrors.ErrForeignKeyViolation), errors.Is(err, dberrors.ErrUniqueViolation):
+			bs.logger.Error("attempting to hold a book twice or for an unknown user", zap.Error(err))
+			return book_errors.ErrHoldInvalid
+		default:
+			bs.logger.Error("error on inserting a hold", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NextHold returns the oldest hold of the book with the given status.
+func (bs *BookStorage) NextHold(ctx context.Context, bookID int64, status string) (*models.Hold, error) {
+	query := `
+        SELECT ` + holdColumns + `, ` + holdPosition + `, holds.book_id, holds.user_id
+        FROM holds
+        WHERE holds.book_id = ? AND holds.status = ?
+        ORDER BY holds.id
+        LIMIT 1`
+
+	return bs.getHold(ctx, query, bookID, status)
+}
+
+// ActiveHold returns the user's waiting or ready hold on the book.
+func (bs *BookStorage) ActiveHold(ctx context.Context, bookID, userID int64) (*models.Hold, error) {
+	query := `
+        SELECT ` + holdColumns + `, ` + holdPosition + `, holds.book_id, holds.user_id
+        FROM holds
+        WHERE holds.book_id = ? AND holds.user_id = ? AND holds.status IN ('waiting', 'ready')`
+
+	return bs.getHold(ctx, query, bookID, userID)
+}
+
+func (bs *BookStorage) GetHold(ctx context.Context, holdID int64) (*models.Hold, error) {
+	query := `
+        SELECT ` + holdColumns + `, ` + holdPosition + `, holds.book_id, holds.user_id
+        FROM holds
+        WHERE holds.id = ?`
+
+	return bs.getHold(ctx, query, holdID)
+}
+
+func (bs *BookStorage) getHold(ctx context.Context, query string, args ...any) (*models.Hold, error) {
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	hold := &models.Hold{
+		Book: &models.Book{},
+		User: &models.User{},
+	}
+	var copyID sql.NullInt64
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), args...).Scan(
+		&hold.ID,
+		&hold.Status,
+		&hold.CreatedAt,
+		&hold.ReadyAt,
+		&hold.ExpiresAt,
+		&hold.ClosedAt,
+		&copyID,
+		&hold.Position,
+		&hold.Book.ID,
+		&hold.User.ID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, book_errors.ErrHoldNotFound
+		default:
+			bs.logger.Error("error on getting a hold", zap.Error(err))
+			return nil, dberrors.Classify(err)
+		}
+	}
+	hold.Copy = heldCopy(copyID)
+
+	return hold, nil
+}
+
+// UpdateHold saves the status, dates and kept copy of the hold.
+func (bs *BookStorage) UpdateHold(ctx context.Context, hold *models.Hold) error {
+	query := `
+        UPDATE holds
+        SET status = ?, ready_at = ?, expires_at = ?, closed_at = ?, copy_id = ?
+        WHERE id = ?`
+
+	var copyID *int64
+	if hold.Copy != nil {
+		copyID = &hold.Copy.ID
+	}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	result, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), hold.Status, hold.ReadyAt, hold.ExpiresAt, hold.ClosedAt, copyID, hold.ID)
+	if err != nil {
+		bs.logger.Error("error on updating a hold", zap.Error(err))
+		return dberrors.Classify(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return book_errors.ErrHoldNotFound
+	}
+
+	return nil
+}
+
+// ListExpiredHolds lists the ready holds that were not picked up before.
+func (bs *BookStorage) ListExpiredHolds(ctx context.Context, before time.Time) ([]*models.Hold, error) {
+	query := `
+        SELECT ` + holdColumns + `, holds.book_id, holds.user_id
+        FROM holds
+        WHERE holds.status = 'ready' AND holds.expires_at < ?
+        ORDER BY holds.id`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), before)
+	if err != nil {
+		bs.logger.Error("error on listing expired holds", zap.Error(err))
+		return nil, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	holds := []*models.Hold{}
+	for rows.Next() {
+		hold := models.Hold{
+			Book: &models.Book{},
+			User: &models.User{},
+		}
+		var copyID sql.NullInt64
+		err := rows.Scan(
+			&hold.ID,
+			&hold.Status,
+			&hold.CreatedAt,
+			&hold.ReadyAt,
+			&hold.ExpiresAt,
+			&hold.ClosedAt,
+			&copyID,
+			&hold.Book.ID,
+			&hold.User.ID,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a hold", zap.Error(err))
+			return nil, err
+		}
+		hold.Copy = heldCopy(copyID)
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// ListUserHolds lists the user's holds with their books and queue positions,
+// narrowed to one status unless status is empty.
+func (bs *BookStorage) ListUserHolds(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	paging, err := newPaging(filters, holdSortKeys, "holds.id", holdID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	condition := ""
+	args := []any{userID}
+	if status != "" {
+		condition = " AND holds.status = ?"
+		args = append(args, status)
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, %s, %s, books.id, books.year, books.title, books.available, authors.id, authors.name
+        FROM holds
+		INNER JOIN books ON holds.book_id = books.id
+		INNER JOIN authors ON books.author_id = authors.id
+        WHERE holds.user_id = ?%s AND %s
+        ORDER BY %s
+        %s`, paging.count, holdColumns, holdPosition, condition, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on listing user holds", zap.Error(err))
+		return nil, filter.Metadata{}, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	holds := []*models.Hold{}
+
+	for rows.Next() {
+		hold := models.Hold{
+			Book: &models.Book{Author: &models.Author{}},
+		}
+		var copyID sql.NullInt64
+		err := rows.Scan(
+			&totalRecords,
+			&hold.ID,
+			&hold.Status,
+			&hold.CreatedAt,
+			&hold.ReadyAt,
+			&hold.ExpiresAt,
+			&hold.ClosedAt,
+			&copyID,
+			&hold.Position,
+			&hold.Book.ID,
+			&hold.Book.Year,
+			&hold.Book.Title,
+			&hold.Book.Available,
+			&hold.Book.Author.ID,
+			&hold.Book.Author.Name,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a hold", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+		hold.Copy = heldCopy(copyID)
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	holds, metadata := paging.page(holds, totalRecords)
+	return holds, metadata, nil
+}
+
+// ListBookHolds lists the book's queue in the order it is served.
+func (bs *BookStorage) ListBookHolds(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	// the queue is always in order of the holds
+	filters.Sort, filters.SortSafelist = "id", []string{"id"}
+	paging, err := newPaging(filters, holdSortKeys, "holds.id", holdID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, %s, %s, users.id, users.name, users.email, users.deleted
+        FROM holds
+		INNER JOIN users ON holds.user_id = users.id
+        WHERE holds.book_id = ? AND holds.status IN ('waiting', 'ready') AND %s
+        ORDER BY %s
+        %s`, paging.count, holdColumns, holdPosition, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args := append([]any{bookID}, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on listing book holds", zap.Error(err))
+		return nil, filter.Metadata{}, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	holds := []*models.Hold{}
+
+	for rows.Next() {
+		hold := models.Hold{
+			User: &models.User{},
+		}
+		var copyID sql.NullInt64
+		err := rows.Scan(
+			&totalRecords,
+			&hold.ID,
+			&hold.Status,
+			&hold.CreatedAt,
+			&hold.ReadyAt,
+			&hold.ExpiresAt,
+			&hold.ClosedAt,
+			&copyID,
+			&hold.Position,
+			&hold.User.ID,
+			&hold.User.Name,
+			&hold.User.Email,
+			&hold.User.Deleted,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a hold", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+		hold.Copy = heldCopy(copyID)
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	holds, metadata := paging.page(holds, totalRecords)
+	return holds, metadata, nil
+}
+
+var holdSortKeys = sortKeys[*models.Hold]{
+	"id":         {"holds.id", func(h *models.Hold) any { return h.ID }},
+	"created_at": {"holds.created_at", func(h *models.Hold) any { return h.CreatedAt }},
+}
+
+func holdID(h *models.Hold) int64 { return h.ID }
+
+// heldCopy is the copy kept for a ready hold, waiting holds have none.
+func heldCopy(id sql.NullInt64) *models.Copy {
+	if !id.Valid {
+		return nil
+	}
+	return &models.Copy{ID: id.Int64}
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	filter "test/internal/infrastructure/filters"
+)
+
+// sortKey is a sort column of a listing: its SQL expression and its value
+// in a listed row, which keyset cursors are made from.
+type sortKey[T any] struct {
+	column string
+	value  func(T) any
+}
+
+// sortKeys maps the sort columns a listing accepts to their keys.
+type sortKeys[T any] map[string]sortKey[T]
+
+// paging holds the parts of a listing query that depend on how it is paged.
+// Pages by number count the rows and use LIMIT and OFFSET; pages by cursor
+// skip the count and start after the cursor's row instead, fetching one row
+// more to know whether there is a next page.
+type paging[T any] struct {
+	filters filter.Filters
+	// keys are the sort columns up to the id, which isn't among them
+	keys []sortKey[T]
+	id   func(T) int64
+
+	// count is the total count column
+	count string
+	// where selects the rows after the cursor, with whereArgs
+	where     string
+	whereArgs []any
+	orderBy   string
+	// limit holds LIMIT and OFFSET, with limitArgs
+	limit     string
+	limitArgs []any
+}
+
+// ordered is a column of an ORDER BY.
+type ordered struct {
+	column    string
+	direction string
+}
+
+// newPaging pages a listing sorted by the sort columns in filters and then
+// by idColumn ascending, unless the id is sorted by already.
+func newPaging[T any](filters filter.Filters, keys sortKeys[T], idColumn string, id func(T) int64) (*paging[T], error) {
+	p := &paging[T]{filters: filters, id: id}
+
+	columns := []ordered{}
+	for _, term := range filters.SortTerms() {
+		key, ok := keys[term.Column]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort column %q", term.Column)
+		}
+		columns = append(columns, ordered{key.column, term.Direction()})
+		if key.column == idColumn {
+			break
+		}
+		p.keys = append(p.keys, key)
+	}
+	if len(columns) == len(p.keys) {
+		columns = append(columns, ordered{idColumn, "ASC"})
+	}
+
+	if !filters.Keyset {
+		p.count = "count(*) OVER()"
+		p.where = "1 = 1"
+		p.orderBy = orderBy(columns)
+		p.limit, p.limitArgs = "LIMIT ? OFFSET ?", []any{filters.Limit(), filters.Offset()}
+		return p, nil
+	}
+
+	// going back the rows are read in reverse order and turned around
+	// afterwards
+	if filters.Cursor != nil && filters.Cursor.Before {
+		for i := range columns {
+			columns[i].direction = reverse(columns[i].direction)
+		}
+	}
+
+	p.count = "0"
+	p.where = "1 = 1"
+	p.orderBy = orderBy(columns)
+	if c := filters.Cursor; c != nil {
+		if len(c.Keys) != len(p.keys) {
+			return nil, fmt.Errorf("%w: cursor has %d keys, the sort %d", filter.ErrCursorMismatch, len(c.Keys), len(p.keys))
+		}
+		p.where, p.whereArgs = after(columns, append(append([]any{}, c.Keys...), c.ID))
+	}
+	p.limit, p.limitArgs = "LIMIT ?", []any{filters.Limit() + 1}
+
+	return p, nil
+}
+
+// page finishes the page of rows read with the paging.
+func (p *paging[T]) page(rows []T, totalRecords int) ([]T, filter.Metadata) {
+	if !p.filters.Keyset {
+		return rows, filter.CalculateMetadata(totalRecords, p.filters.Page, p.filters.PageSize)
+	}
+	return filter.KeysetPage(rows, p.filters, func(row T) ([]any, int64) {
+		keys := make([]any, 0, len(p.keys))
+		for _, key := range p.keys {
+			keys = append(keys, key.value(row))
+		}
+		return keys, p.id(row)
+	})
+}
+
+func orderBy(columns []ordered) string {
+	terms := make([]string, 0, len(columns))
+	for _, c := range columns {
+		terms = append(terms, c.column+" "+c.direction)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// after selects the rows that come after the one with values in the order
+// of columns: those beyond it in the first column, or equal there and beyond
+// it in the second, and so on.
+func after(columns []ordered, values []any) (string, []any) {
+	alternatives := make([]string, 0, len(columns))
+	args := []any{}
+	for i, c := range columns {
+		conditions := []string{}
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, columns[j].column+" = ?")
+			args = append(args, values[j])
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s ?", c.column, beyond(c.direction)))
+		args = append(args, values[i])
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+func reverse(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// beyond is the comparison of the rows that come later in a direction.
+func beyond(direction string) string {
+	if direction == "ASC" {
+		return ">"
+	}
+	return "<"
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test/internal/db"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// rentedSince selects the rentals made since a time, all of them for the
+// zero time.
+func rentedSince(since time.Time) (string, []any) {
+	if since.IsZero() {
+		return "1 = 1", nil
+	}
+	return "rented.rented_at >= ?", []any{since}
+}
+
+// rankSortKeys is the sort of the leaderboards, by the rentals of the
+// ranked table.
+func rankSortKeys[T any](rentals func(T) int64) sortKeys[T] {
+	return sortKeys[T]{
+		"rentals": {"ranked.rentals", func(r T) any { return rentals(r) }},
+	}
+}
+
+// RankAuthors ranks the authors by the rentals of the books they are
+// credited on since a time, the most rented first. Authors without rentals
+// are left out and the field filters pick among the others, the ranks count
+// within them. The ranks are taken before the page is, so a page read by
+// cursor keeps them.
+func (bs *BookStorage) RankAuthors(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.AuthorRank, filter.Metadata, error) {
+	window, args := rentedSince(since)
+	where, whereArgs, err := where(filters, authorConditions)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	paging, err := newPaging(filters, rankSortKeys(func(r *models.AuthorRank) int64 { return r.Rentals }),
+		"authors.id", func(r *models.AuthorRank) int64 { return r.Author.ID })
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	// a book credits an author once, whatever the roles
+	query := fmt.Sprintf(`
+        SELECT %s, ranked.rental_rank, ranked.rentals,
+               authors.id, authors.name, authors.times_ordered
+        FROM (
+            SELECT counted.author_id, counted.rentals, RANK() OVER (ORDER BY counted.rentals DESC) AS rental_rank
+            FROM (
+                SELECT credits.author_id, count(*) AS rentals
+                FROM rented
+                INNER JOIN (SELECT DISTINCT book_id, author_id FROM book_contributors) credits ON credits.book_id = rented.book_id
+                WHERE %s
+                GROUP BY credits.author_id
+            ) counted
+            INNER JOIN authors ON authors.id = counted.author_id
+            WHERE %s
+        ) ranked
+        INNER JOIN authors ON authors.id = ranked.author_id
+        WHERE %s
+        ORDER BY %s
+        %s`, paging.count, window, where, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args = append(args, whereArgs...)
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on ranking authors", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+
+	ranks := []*models.AuthorRank{}
+
+	for rows.Next() {
+		rank := models.AuthorRank{Author: &models.Author{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&rank.Rank,
+			&rank.Rentals,
+			&rank.Author.ID,
+			&rank.Author.Name,
+			&rank.Author.Times_ordered,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning an author rank", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		ranks = append(ranks, &rank)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	ranks, metadata := paging.page(ranks, totalRecords)
+
+	return ranks, metadata, nil
+}
+
+// RankBooks ranks the books by their rentals since a time like RankAuthors
+// does the authors.
+func (bs *BookStorage) RankBooks(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.BookRank, filter.Metadata, error) {
+	window, args := rentedSince(since)
+	where, whereArgs, err := where(filters, bookConditions)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	paging, err := newPaging(filters, rankSortKeys(func(r *models.BookRank) int64 { return r.Rentals }),
+		"books.id", func(r *models.BookRank) int64 { return r.Book.ID })
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, ranked.rental_rank, ranked.rentals, %s
+        FROM (
+            SELECT counted.book_id, counted.rentals, RANK() OVER (ORDER BY counted.rentals DESC) AS rental_rank
+            FROM (
+                SELECT rented.book_id, count(*) AS rentals
+                FROM rented
+                WHERE %s
+                GROUP BY rented.book_id
+            ) counted
+            INNER JOIN books ON books.id = counted.book_id
+            INNER JOIN authors ON books.author_id = authors.id
+            WHERE %s
+        ) ranked
+        INNER JOIN books ON books.id = ranked.book_id
+		INNER JOIN authors ON books.author_id = authors.id
+        WHERE %s
+        ORDER BY %s
+        %s`, paging.count, bookColumns, window, where, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args = append(args, whereArgs...)
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on ranking books", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+
+	ranks := []*models.BookRank{}
+	books := []*models.Book{}
+
+	for rows.Next() {
+		var rank models.BookRank
+		rank.Book, err = bs.scanBook(rows, &totalRecords, &rank.Rank, &rank.Rentals)
+		if err != nil {
+			bs.logger.Error("error on scanning a book rank", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		ranks = append(ranks, &rank)
+		books = append(books, rank.Book)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	if err := bs.catalogDetails(ctx, books); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	ranks, metadata := paging.page(ranks, totalRecords)
+
+	return ranks, metadata, nil
+}
@@ -0,0 +1,331 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"test/internal/db"
+	"test/internal/db/dberrors"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+
+	"go.uber.org/zap"
+)
+
+const rentalColumns = `rented.id, rented.rented_at, rented.due_at, rented.returned_at`
+
+func (bs *BookStorage) InsertRental(ctx context.Context, rental *models.Rental) error {
+	query := `
+	INSERT INTO rented  (book_id, copy_id, user_id, rented_at, due_at)
+	VALUES (?, ?, ?, ?, ?)`
+
+	args := []any{rental.Book.ID, rental.Copy.ID, rental.User.ID, rental.RentedAt, rental.DueAt}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	var err error
+	rental.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
+	if err != nil {
+		err = dberrors.Classify(err)
+		switch {
+		case errors.Is(err, dberrors.ErrForeignKeyViolation), errors.Is(err, dberrors.ErrUniqueViolation):
+			bs.logger.Error("attempting to rent a book that is not available", zap.Error(err))
+			return book_errors.ErrRentInvalid
+		default:
+			bs.logger.Error("some error", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OpenRental returns the user's rental of a copy of the book that is not
+// returned yet.
+func (bs *BookStorage) OpenRental(ctx context.Context, bookID, userID int64) (*models.Rental, error) {
+	query := `
+        SELECT ` + rentalColumns + `, rented.book_id, rented.copy_id, rented.user_id
+        FROM rented
+        WHERE rented.book_id = ? AND rented.user_id = ? AND rented.returned_at IS NULL
+        ORDER BY rented.id
+        LIMIT 1`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	rental := &models.Rental{
+		Book: &models.Book{},
+		Copy: &models.Copy{},
+		User: &models.User{},
+	}
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), bookID, userID).Scan(
+		&rental.ID,
+		&rental.RentedAt,
+		&rental.DueAt,
+		&rental.ReturnedAt,
+		&rental.Book.ID,
+		&rental.Copy.ID,
+		&rental.User.ID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, book_errors.ErrBookNotFound
+		default:
+			bs.logger.Error("error on getting an open rental", zap.Error(err))
+			return nil, dberrors.Classify(err)
+		}
+	}
+
+	return rental, nil
+}
+
+// CloseRental marks the rental as returned, the row stays as history.
+func (bs *BookStorage) CloseRental(ctx context.Context, rentalID int64, returnedAt time.Time) error {
+	query := `
+	UPDATE rented
+	SET returned_at = ?
+        WHERE id = ? AND returned_at IS NULL`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	result, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), returnedAt, rentalID)
+	if err != nil {
+		bs.logger.Error("error on closing a rental", zap.Error(err))
+		return dberrors.Classify(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return book_errors.ErrBookNotFound
+	}
+
+	return nil
+}
+
+// ListOverdueRentals lists the open rentals that were due before dueBefore.
+func (bs *BookStorage) ListOverdueRentals(ctx context.Context, dueBefore time.Time) ([]*models.Rental, error) {
+	query := `
+        SELECT ` + rentalColumns + `, rented.book_id, rented.copy_id, rented.user_id
+        FROM rented
+        WHERE rented.returned_at IS NULL AND rented.due_at < ?
+        ORDER BY rented.id`
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), dueBefore)
+	if err != nil {
+		bs.logger.Error("error on listing overdue rentals", zap.Error(err))
+		return nil, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	rentals := []*models.Rental{}
+	for rows.Next() {
+		rental := models.Rental{
+			Book: &models.Book{},
+			Copy: &models.Copy{},
+			User: &models.User{},
+		}
+		err := rows.Scan(
+			&rental.ID,
+			&rental.RentedAt,
+			&rental.DueAt,
+			&rental.ReturnedAt,
+			&rental.Book.ID,
+			&rental.Copy.ID,
+			&rental.User.ID,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a rental", zap.Error(err))
+			return nil, err
+		}
+
+		rentals = append(rentals, &rental)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, err
+	}
+
+	return rentals, nil
+}
+
+// ListUserRentals lists what the user has borrowed with the books, narrowed
+// to open or returned rentals by status.
+func (bs *BookStorage) ListUserRentals(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	paging, err := newPaging(filters, rentalSortKeys, "rented.id", rentalID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, %s, copies.id, copies.barcode, books.id, books.year, books.title, books.available, authors.id, authors.name
+        FROM rented
+		INNER JOIN copies ON rented.copy_id = copies.id
+		INNER JOIN books ON rented.book_id = books.id
+		INNER JOIN authors ON books.author_id = authors.id
+        WHERE rented.user_id = ?%s AND %s
+        ORDER BY %s
+        %s`, paging.count, rentalColumns, statusCondition(status), paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args := append([]any{userID}, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on listing user rentals", zap.Error(err))
+		return nil, filter.Metadata{}, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	rentals := []*models.Rental{}
+
+	for rows.Next() {
+		rental := models.Rental{
+			Book: &models.Book{Author: &models.Author{}},
+			Copy: &models.Copy{},
+		}
+		err := rows.Scan(
+			&totalRecords,
+			&rental.ID,
+			&rental.RentedAt,
+			&rental.DueAt,
+			&rental.ReturnedAt,
+			&rental.Copy.ID,
+			&rental.Copy.Barcode,
+			&rental.Book.ID,
+			&rental.Book.Year,
+			&rental.Book.Title,
+			&rental.Book.Available,
+			&rental.Book.Author.ID,
+			&rental.Book.Author.Name,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a rental", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		rentals = append(rentals, &rental)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	rentals, metadata := paging.page(rentals, totalRecords)
+	return rentals, metadata, nil
+}
+
+// ListBookRentals lists who borrowed the book and when.
+func (bs *BookStorage) ListBookRentals(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	paging, err := newPaging(filters, rentalSortKeys, "rented.id", rentalID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s, %s, copies.id, copies.barcode, users.id, users.name, users.email, users.deleted
+        FROM rented
+		INNER JOIN copies ON rented.copy_id = copies.id
+		INNER JOIN users ON rented.user_id = users.id
+        WHERE rented.book_id = ? AND %s
+        ORDER BY %s
+        %s`, paging.count, rentalColumns, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args := append([]any{bookID}, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on listing book rentals", zap.Error(err))
+		return nil, filter.Metadata{}, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	rentals := []*models.Rental{}
+
+	for rows.Next() {
+		rental := models.Rental{
+			Copy: &models.Copy{},
+			User: &models.User{},
+		}
+		err := rows.Scan(
+			&totalRecords,
+			&rental.ID,
+			&rental.RentedAt,
+			&rental.DueAt,
+			&rental.ReturnedAt,
+			&rental.Copy.ID,
+			&rental.Copy.Barcode,
+			&rental.User.ID,
+			&rental.User.Name,
+			&rental.User.Email,
+			&rental.User.Deleted,
+		)
+		if err != nil {
+			bs.logger.Error("error on scanning a rental", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		rentals = append(rentals, &rental)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	rentals, metadata := paging.page(rentals, totalRecords)
+	return rentals, metadata, nil
+}
+
+// rentalSortKeys are the sort columns of the rental listings. Keyset pages
+// can't sort by returned_at, the open rentals have none.
+var rentalSortKeys = sortKeys[*models.Rental]{
+	"id":        {"rented.id", func(r *models.Rental) any { return r.ID }},
+	"rented_at": {"rented.rented_at", func(r *models.Rental) any { return r.RentedAt }},
+	"due_at":    {"rented.due_at", func(r *models.Rental) any { return r.DueAt }},
+	"returned_at": {"rented.returned_at", func(r *models.Rental) any {
+		if r.ReturnedAt == nil {
+			return nil
+		}
+		return *r.ReturnedAt
+	}},
+}
+
+func rentalID(r *models.Rental) int64 { return r.ID }
+
+func statusCondition(status string) string {
+	switch status {
+	case models.RentalCurrent:
+		return " AND rented.returned_at IS NULL"
+	case models.RentalReturned:
+		return " AND rented.returned_at IS NOT NULL"
+	default:
+		return ""
+	}
+}
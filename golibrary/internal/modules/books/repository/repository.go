@@ -5,7 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"strings"
+	"test/internal/db"
+	"test/internal/db/dberrors"
 	filter "test/internal/infrastructure/filters"
 	"test/internal/models"
 	book_errors "test/internal/models/errors"
@@ -18,130 +19,400 @@ import (
 type BookStorage struct {
 	logger *zap.Logger
 	db     *sqlx.DB
+	q      db.Querier
+	tx     bool
+	// timeout bounds every single operation, on top of the caller's context
+	timeout time.Duration
 }
 
-func NewBookStorage(db *sqlx.DB, logger *zap.Logger) IBookStorage {
+func NewBookStorage(db *sqlx.DB, logger *zap.Logger, timeout time.Duration) IBookStorage {
 	return &BookStorage{
-		logger: logger,
-		db:     db,
+		logger:  logger,
+		db:      db,
+		q:       db,
+		timeout: timeout,
 	}
 }
 
-func (bs *BookStorage) CreateBook(book *models.Book) error {
+func (bs *BookStorage) Atomic(ctx context.Context, fn func(ctx context.Context, tx IBookStorage) error) error {
+	if bs.tx {
+		return fn(ctx, bs)
+	}
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	return db.WithTx(ctx, bs.db, func(tx *sqlx.Tx) error {
+		return fn(ctx, &BookStorage{logger: bs.logger, db: bs.db, q: tx, tx: true, timeout: bs.timeout})
+	})
+}
+
+// bookColumns are the columns scanned by scanBook, the book's author has
+// to be joined.
+const bookColumns = `books.id, books.year, books.title, books.available, ` + copyCounts + `,
+        COALESCE(books.isbn, ''), books.publisher, books.edition, books.language, books.pages, books.description,
+        authors.id, authors.name`
+
+// CreateBook inserts the book with its genres and contributors in one
+// transaction and reads it back. A book without contributors is credited to
+// its author.
+func (bs *BookStorage) CreateBook(ctx context.Context, book *models.Book) error {
+	return bs.Atomic(ctx, func(ctx context.Context, tx IBookStorage) error {
+		return tx.(*BookStorage).createBook(ctx, book)
+	})
+}
+
+func (bs *BookStorage) createBook(ctx context.Context, book *models.Book) error {
 	query := `
-        INSERT INTO books (year, title, author_id) 
-        VALUES ($1, $2, $3)
-        RETURNING id, year, title, available`
+        INSERT INTO books (year, title, author_id, available, isbn, publisher, edition, language, pages, description) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	args := []any{book.Year, book.Title, book.Author.ID}
+	contributors := book.Contributors
+	if len(contributors) == 0 {
+		contributors = []models.Contributor{{Author: book.Author, Role: models.RoleAuthor, Position: 1}}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var isbn *string
+	if book.ISBN != "" {
+		isbn = &book.ISBN
+	}
+	// a new book has no copies yet
+	args := []any{book.Year, book.Title, contributors[0].Author.ID, false, isbn, book.Publisher, book.Edition, book.Language, book.Pages, book.Description}
 
-	err := bs.db.QueryRowContext(ctx, query, args...).Scan(
-		&book.ID,
-		&book.Year,
-		&book.Title,
-		&book.Available)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
 
+	var err error
+	book.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
 	if err != nil {
+		err = dberrors.Classify(err)
 		switch {
-		case strings.Contains(err.Error(), "foreign key"):
+		case errors.Is(err, dberrors.ErrForeignKeyViolation):
 			bs.logger.Error("foreign key constraint error", zap.Error(err))
 			return book_errors.ErrAuthorNotFound
+		case errors.Is(err, dberrors.ErrUniqueViolation):
+			return book_errors.ErrDuplicateISBN
 		default:
 			bs.logger.Error("errors on inserting into books", zap.Error(err))
 			return err
 		}
 	}
 
+	for _, genre := range book.Genres {
+		query = `
+        INSERT INTO book_genres (book_id, genre)
+        VALUES (?, ?)`
+
+		if _, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), book.ID, genre); err != nil {
+			bs.logger.Error("error on inserting a genre", zap.Error(err))
+			return dberrors.Classify(err)
+		}
+	}
+
+	for _, contributor := range contributors {
+		query = `
+        INSERT INTO book_contributors (book_id, author_id, role, position)
+        VALUES (?, ?, ?, ?)`
+
+		_, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), book.ID, contributor.Author.ID, contributor.Role, contributor.Position)
+		if err != nil {
+			err = dberrors.Classify(err)
+			if errors.Is(err, dberrors.ErrForeignKeyViolation) {
+				return book_errors.ErrAuthorNotFound
+			}
+			bs.logger.Error("error on inserting a contributor", zap.Error(err))
+			return err
+		}
+	}
+
 	query = `
-        SELECT  books.id,  books.year, books.title, books.available, authors.id, authors.name
+        SELECT ` + bookColumns + `
         FROM books
 		INNER JOIN authors ON books.author_id = authors.id
-        WHERE books.id = $1
+        WHERE books.id = ?
 	`
-	args = []any{book.ID}
 
-	err = bs.db.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID, &book.Author.Name)
+	created, err := bs.scanBook(bs.q.QueryRowContext(ctx, bs.q.Rebind(query), book.ID))
 	if err != nil {
 		bs.logger.Error("error on getting a book", zap.Error(err))
 		return err
 	}
+	genres, err := bs.genresByBook(ctx, []int64{book.ID})
+	if err != nil {
+		return err
+	}
+	created.Genres = genres[book.ID]
+	credits, err := bs.contributorsByBook(ctx, []int64{book.ID})
+	if err != nil {
+		return err
+	}
+	created.Contributors = credits[book.ID]
+	*book = *created
+
+	return nil
+}
+
+// scanBook scans a row that starts with bookColumns.
+func (bs *BookStorage) scanBook(row interface{ Scan(...any) error }, dest ...any) (*models.Book, error) {
+	book := &models.Book{
+		Author: &models.Author{},
+	}
+	columns := []any{
+		&book.ID,
+		&book.Year,
+		&book.Title,
+		&book.Available,
+		&book.CopiesAvailable,
+		&book.CopiesTotal,
+		&book.ISBN,
+		&book.Publisher,
+		&book.Edition,
+		&book.Language,
+		&book.Pages,
+		&book.Description,
+		&book.Author.ID,
+		&book.Author.Name,
+	}
+	if err := row.Scan(append(dest, columns...)...); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
 
+// catalogDetails loads the genres and contributors of a page of books.
+func (bs *BookStorage) catalogDetails(ctx context.Context, books []*models.Book) error {
+	bookIDs := make([]int64, 0, len(books))
+	for _, book := range books {
+		bookIDs = append(bookIDs, book.ID)
+	}
+	genres, err := bs.genresByBook(ctx, bookIDs)
+	if err != nil {
+		return err
+	}
+	contributors, err := bs.contributorsByBook(ctx, bookIDs)
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		book.Genres = genres[book.ID]
+		book.Contributors = contributors[book.ID]
+	}
 	return nil
 }
 
-func (bs *BookStorage) CreateAuthor(author *models.Author) error {
+// genresByBook loads the genres of all given books with one query.
+func (bs *BookStorage) genresByBook(ctx context.Context, bookIDs []int64) (map[int64][]string, error) {
+	genres := make(map[int64][]string, len(bookIDs))
+	if len(bookIDs) == 0 {
+		return genres, nil
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT book_id, genre
+        FROM book_genres
+        WHERE book_id IN (?)
+        ORDER BY book_id, genre`, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on getting genres", zap.Error(err))
+		return nil, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var genre string
+		if err := rows.Scan(&bookID, &genre); err != nil {
+			bs.logger.Error("error on scanning a genre", zap.Error(err))
+			return nil, err
+		}
+		genres[bookID] = append(genres[bookID], genre)
+	}
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// contributorsByBook loads the contributors of all given books with one
+// query, in the order of the credits.
+func (bs *BookStorage) contributorsByBook(ctx context.Context, bookIDs []int64) (map[int64][]models.Contributor, error) {
+	contributors := make(map[int64][]models.Contributor, len(bookIDs))
+	if len(bookIDs) == 0 {
+		return contributors, nil
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT book_contributors.book_id, authors.id, authors.name, book_contributors.role, book_contributors.position
+        FROM book_contributors
+		INNER JOIN authors ON book_contributors.author_id = authors.id
+        WHERE book_contributors.book_id IN (?)
+        ORDER BY book_contributors.book_id, book_contributors.position`, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
+	if err != nil {
+		bs.logger.Error("error on getting contributors", zap.Error(err))
+		return nil, dberrors.Classify(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		contributor := models.Contributor{Author: &models.Author{}}
+		err := rows.Scan(&bookID, &contributor.Author.ID, &contributor.Author.Name, &contributor.Role, &contributor.Position)
+		if err != nil {
+			bs.logger.Error("error on scanning a contributor", zap.Error(err))
+			return nil, err
+		}
+		contributors[bookID] = append(contributors[bookID], contributor)
+	}
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, err
+	}
+
+	return contributors, nil
+}
+
+func (bs *BookStorage) CreateAuthor(ctx context.Context, author *models.Author) error {
 	query := `
         INSERT INTO authors (name) 
-        VALUES ($1)
-        RETURNING id`
+        VALUES (?)`
 
 	args := []any{author.Name}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
 
-	err := bs.db.QueryRowContext(ctx, query, args...).Scan(&author.ID)
+	var err error
+	author.ID, err = db.InsertReturningID(ctx, bs.q, query, args...)
 	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "foreign key"):
-			bs.logger.Error("foreign ley constraint error", zap.Error(err))
-			return err
-		default:
-			bs.logger.Error("error on inserting into authors", zap.Error(err))
-			return err
-		}
+		bs.logger.Error("error on inserting into authors", zap.Error(err))
+		return dberrors.Classify(err)
 	}
 
-	query = `
-        SELECT  books.id, books.year, books.title, books.available, authors.id, authors.name
+	books, err := bs.booksByAuthor(ctx, []int64{author.ID})
+	if err != nil {
+		return err
+	}
+	author.Books = books[author.ID]
+
+	return nil
+}
+
+// booksByAuthor loads the books of all given authors with one query, every
+// book they are credited on in any role, once.
+func (bs *BookStorage) booksByAuthor(ctx context.Context, authorIDs []int64) (map[int64][]models.Book, error) {
+	query := `
+        SELECT DISTINCT book_contributors.author_id, books.id, books.year, books.title, books.available, ` + copyCounts + `, authors.id, authors.name
+        FROM books
+		INNER JOIN authors ON books.author_id = authors.id 
+		INNER JOIN book_contributors ON books.id = book_contributors.book_id
+        WHERE book_contributors.author_id IN (?)
+        ORDER BY books.id
+	`
+	return bs.booksBy(ctx, query, authorIDs)
+}
+
+// booksByRenter loads the books rented by all given users with one query.
+func (bs *BookStorage) booksByRenter(ctx context.Context, userIDs []int64) (map[int64][]models.Book, error) {
+	query := `
+        SELECT  rented.user_id, books.id, books.year, books.title, books.available, ` + copyCounts + `, authors.id, authors.name
         FROM books
 		INNER JOIN authors ON books.author_id = authors.id 
-        WHERE authors.id = $1
+		INNER JOIN rented ON books.id = rented.book_id
+        WHERE rented.user_id IN (?) AND rented.returned_at IS NULL
+        ORDER BY books.id
 	`
-	args = []any{author.ID}
+	return bs.booksBy(ctx, query, userIDs)
+}
+
+// booksBy runs a books-with-author query whose first column is the id of
+// the parent row, and groups the books by it. The IN (?) list is expanded
+// to one placeholder per id.
+func (bs *BookStorage) booksBy(ctx context.Context, query string, ids []int64) (map[int64][]models.Book, error) {
+	books := make(map[int64][]models.Book, len(ids))
+	if len(ids) == 0 {
+		return books, nil
+	}
 
-	rows, err := bs.db.QueryContext(ctx, query, args...)
+	query, args, err := sqlx.In(query, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
 	if err != nil {
 		bs.logger.Error("some error", zap.Error(err))
-		return err
+		return nil, err
 	}
 
 	defer rows.Close()
+
 	for rows.Next() {
+		var parentID int64
 		book := models.Book{
 			Author: &models.Author{},
 		}
-		err := rows.Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID, &book.Author.Name)
+		err := rows.Scan(&parentID, &book.ID, &book.Year, &book.Title, &book.Available,
+			&book.CopiesAvailable, &book.CopiesTotal, &book.Author.ID, &book.Author.Name)
 		if err != nil {
 			bs.logger.Error("error on creating book array", zap.Error(err))
-			return err
+			return nil, err
 		}
-		author.Books = append(author.Books, book)
+		books[parentID] = append(books[parentID], book)
 	}
 	if err = rows.Err(); err != nil {
 		bs.logger.Error("errors on iterating", zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	return nil
+	return books, nil
 }
 
-func (bs *BookStorage) ListUsers(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+var userSortKeys = sortKeys[*models.User]{
+	"id":    {"users.id", func(u *models.User) any { return u.ID }},
+	"name":  {"users.name", func(u *models.User) any { return u.Name }},
+	"email": {"users.email", func(u *models.User) any { return u.Email }},
+}
+
+func userID(u *models.User) int64 { return u.ID }
+
+func (bs *BookStorage) ListUsers(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+	where, args, err := where(filters, userConditions)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	paging, err := newPaging(filters, userSortKeys, "users.id", userID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), users.*
+        SELECT %s, users.id, users.name, users.email, users.role, users.password_hash, users.deleted, users.version
         FROM users
-        ORDER BY %s %s, id ASC
-        LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+        WHERE %s AND %s
+        ORDER BY %s
+        %s`, paging.count, where, paging.where, paging.orderBy, paging.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
 
-	args := []any{filters.Limit(), filters.Offset()}
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
 
-	rows, err := bs.db.QueryContext(ctx, query, args...)
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
 	if err != nil {
 		bs.logger.Error("error on getting users", zap.Error(err))
 		return nil, filter.Metadata{}, err
@@ -161,6 +432,7 @@ func (bs *BookStorage) ListUsers(filters filter.Filters) ([]*models.User, filter
 			&user.ID,
 			&user.Name,
 			&user.Email,
+			&user.Role,
 			&user.Password.Hash,
 			&user.Deleted,
 			&user.Version,
@@ -170,38 +442,6 @@ func (bs *BookStorage) ListUsers(filters filter.Filters) ([]*models.User, filter
 			return nil, filter.Metadata{}, err
 		}
 
-		query := `
-        SELECT  books.id, books.year,books.title,  books.available, authors.id, authors.name
-        FROM books
-		INNER JOIN authors ON books.author_id = authors.id 
-		INNER JOIN rented ON books.id = rented.book_id
-        WHERE rented.user_id = $1
-	`
-		args := []any{user.ID}
-
-		rows, err := bs.db.QueryContext(ctx, query, args...)
-		if err != nil {
-			bs.logger.Error("some error", zap.Error(err))
-			return nil, filter.Metadata{}, err
-		}
-
-		defer rows.Close()
-		for rows.Next() {
-			book := models.Book{
-				Author: &models.Author{},
-			}
-			err := rows.Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID, &book.Author.Name)
-			if err != nil {
-				bs.logger.Error("some error during iteration", zap.Error(err))
-				return nil, filter.Metadata{}, err
-			}
-			user.RentedBooks = append(user.RentedBooks, book)
-		}
-		if err = rows.Err(); err != nil {
-			bs.logger.Error("some error on closing rows", zap.Error(err))
-			return nil, filter.Metadata{}, err
-		}
-
 		users = append(users, &user)
 	}
 
@@ -210,27 +450,62 @@ func (bs *BookStorage) ListUsers(filters filter.Filters) ([]*models.User, filter
 		return nil, filter.Metadata{}, err
 	}
 
-	metadata := filter.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	userIDs := make([]int64, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+
+	rentedBooks, err := bs.booksByRenter(ctx, userIDs)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	for _, user := range users {
+		user.RentedBooks = rentedBooks[user.ID]
+	}
+
+	users, metadata := paging.page(users, totalRecords)
 
 	return users, metadata, nil
 
 }
 
-func (bs *BookStorage) ListBooks(filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+// the copy counts make a bare id ambiguous
+var bookSortKeys = sortKeys[*models.Book]{
+	"id":        {"books.id", func(b *models.Book) any { return b.ID }},
+	"title":     {"books.title", func(b *models.Book) any { return b.Title }},
+	"year":      {"books.year", func(b *models.Book) any { return int64(b.Year) }},
+	"available": {"books.available", func(b *models.Book) any { return b.Available }},
+	"author":    {"authors.name", func(b *models.Book) any { return b.Author.Name }},
+}
+
+func bookID(b *models.Book) int64 { return b.ID }
+
+// ListBooks lists the books that match every field filter.
+func (bs *BookStorage) ListBooks(ctx context.Context, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	where, args, err := where(filters, bookConditions)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	paging, err := newPaging(filters, bookSortKeys, "books.id", bookID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
 
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), books.*, authors.name
+        SELECT %s, %s
         FROM books
 		INNER JOIN authors on books.author_id = authors.id
-        ORDER BY %s %s, id ASC
-        LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+        WHERE %s AND %s
+        ORDER BY %s
+        %s`, paging.count, bookColumns, where, paging.where, paging.orderBy, paging.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
 
-	args := []any{filters.Limit(), filters.Offset()}
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
 
-	rows, err := bs.db.QueryContext(ctx, query, args...)
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
 	if err != nil {
 		bs.logger.Error("some error", zap.Error(err))
 		return nil, filter.Metadata{}, err
@@ -243,25 +518,13 @@ func (bs *BookStorage) ListBooks(filters filter.Filters) ([]*models.Book, filter
 	books := []*models.Book{}
 
 	for rows.Next() {
-		book := models.Book{
-			Author: &models.Author{},
-		}
-
-		err := rows.Scan(
-			&totalRecords,
-			&book.ID,
-			&book.Year,
-			&book.Title,
-			&book.Available,
-			&book.Author.ID,
-			&book.Author.Name,
-		)
+		book, err := bs.scanBook(rows, &totalRecords)
 		if err != nil {
 			bs.logger.Error("some error during iteration", zap.Error(err))
 			return nil, filter.Metadata{}, err
 		}
 
-		books = append(books, &book)
+		books = append(books, book)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -269,24 +532,47 @@ func (bs *BookStorage) ListBooks(filters filter.Filters) ([]*models.Book, filter
 		return nil, filter.Metadata{}, err
 	}
 
-	metadata := filter.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if err := bs.catalogDetails(ctx, books); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	books, metadata := paging.page(books, totalRecords)
 
 	return books, metadata, nil
 }
 
-func (bs *BookStorage) ListAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
+var authorSortKeys = sortKeys[*models.Author]{
+	"id":            {"authors.id", func(a *models.Author) any { return a.ID }},
+	"name":          {"authors.name", func(a *models.Author) any { return a.Name }},
+	"times_ordered": {"authors.times_ordered", func(a *models.Author) any { return int64(a.Times_ordered) }},
+}
+
+func authorID(a *models.Author) int64 { return a.ID }
+
+func (bs *BookStorage) ListAuthors(ctx context.Context, filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
+	where, args, err := where(filters, authorConditions)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	paging, err := newPaging(filters, authorSortKeys, "authors.id", authorID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), authors.id, authors.name, authors.times_ordered
+        SELECT %s, authors.id, authors.name, authors.times_ordered
         FROM authors
-        ORDER BY %s %s, id ASC
-        LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+        WHERE %s AND %s
+        ORDER BY %s
+        %s`, paging.count, where, paging.where, paging.orderBy, paging.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
 
-	args := []any{filters.Limit(), filters.Offset()}
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
 
-	rows, err := bs.db.QueryContext(ctx, query, args...)
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(query), args...)
 	if err != nil {
 		bs.logger.Error("some error on getting authors", zap.Error(err))
 		return nil, filter.Metadata{}, err
@@ -312,37 +598,6 @@ func (bs *BookStorage) ListAuthors(filters filter.Filters) ([]*models.Author, fi
 			return nil, filter.Metadata{}, err
 		}
 
-		query := `
-        SELECT  books.id, books.year, books.title, books.available, authors.id, authors.name
-        FROM books
-		INNER JOIN authors ON books.author_id = authors.id 
-        WHERE authors.id = $1
-	`
-		args := []any{author.ID}
-
-		rows, err := bs.db.QueryContext(ctx, query, args...)
-		if err != nil {
-			bs.logger.Error("some error", zap.Error(err))
-			return nil, filter.Metadata{}, err
-		}
-
-		defer rows.Close()
-		for rows.Next() {
-			book := models.Book{
-				Author: &models.Author{},
-			}
-			err := rows.Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID, &book.Author.Name)
-			if err != nil {
-				bs.logger.Error("some error", zap.Error(err))
-				return nil, filter.Metadata{}, err
-			}
-			author.Books = append(author.Books, book)
-		}
-		if err = rows.Err(); err != nil {
-			bs.logger.Error("some error", zap.Error(err))
-			return nil, filter.Metadata{}, err
-		}
-
 		authors = append(authors, &author)
 	}
 
@@ -351,125 +606,70 @@ func (bs *BookStorage) ListAuthors(filters filter.Filters) ([]*models.Author, fi
 		return nil, filter.Metadata{}, err
 	}
 
-	metadata := filter.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	authorIDs := make([]int64, 0, len(authors))
+	for _, author := range authors {
+		authorIDs = append(authorIDs, author.ID)
+	}
+
+	books, err := bs.booksByAuthor(ctx, authorIDs)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	for _, author := range authors {
+		author.Books = books[author.ID]
+	}
+
+	authors, metadata := paging.page(authors, totalRecords)
 
 	return authors, metadata, nil
 }
 
-func (bs *BookStorage) RentBook(bookID, userID int64) error {
-
+// LockBook reads a book and locks its row until the surrounding transaction
+// ends, so concurrent rents and returns of the same book run one after
+// another.
+func (bs *BookStorage) LockBook(ctx context.Context, bookID int64) (*models.Book, error) {
 	query := `
-	INSERT INTO rented  (book_id, user_id) 
-	VALUES ($1, $2)
-	RETURNING id`
-
-	args := []any{bookID, userID}
+        SELECT id, year, title, available, author_id
+        FROM books
+        WHERE id = ?` + db.ForUpdate(bs.q)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
-	var id int
-	err := bs.db.QueryRowContext(ctx, query, args...).Scan(&id)
-	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "foreign key"):
-			bs.logger.Error("attempting to rent a book that is not available", zap.Error(err))
-			return book_errors.ErrRentInvalid
-		default:
-			bs.logger.Error("some error", zap.Error(err))
-			return err
-		}
-	}
-
-	query = `
-        UPDATE books
-        SET available = false
-        WHERE id = $1 AND available = true
-		RETURNING author_id
-       `
 
-	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	book := models.Book{
+	book := &models.Book{
 		Author: &models.Author{},
 	}
-	err = bs.db.QueryRowContext(ctx, query, bookID).Scan(&book.Author.ID)
+	err := bs.q.QueryRowContext(ctx, bs.q.Rebind(query), bookID).Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID)
 	if err != nil {
 		switch {
-		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
-			bs.logger.Error("foreign key violation error", zap.Error(err))
-			return err
 		case errors.Is(err, sql.ErrNoRows):
-			bs.logger.Error("no rows", zap.Error(err))
-			return book_errors.ErrRentInvalid
+			return nil, book_errors.ErrBookNotFound
 		default:
-			bs.logger.Error("some error", zap.Error(err))
-			return err
+			bs.logger.Error("error on locking a book", zap.Error(err))
+			return nil, err
 		}
 	}
-	query = `
-	UPDATE authors
-	SET times_ordered = times_ordered + 1
-	WHERE id = $1
-	`
-	err = bs.db.QueryRowContext(ctx, query, book.Author.ID).Err()
-	if err != nil {
-		bs.logger.Error("some error on updating author table", zap.Error(err))
-		return err
-	}
 
-	return nil
+	return book, nil
 }
 
-func (bs *BookStorage) ReturnBook(bookID, userID int64) error {
-
+// IncrementTimesOrdered counts an order of the book for everyone credited on
+// it, once per author whatever their roles.
+func (bs *BookStorage) IncrementTimesOrdered(ctx context.Context, bookID int64) error {
 	query := `
-	DELETE FROM rented
-        WHERE book_id = $1 AND user_id = $2`
-
-	args := []any{bookID, userID}
+	UPDATE authors
+	SET times_ordered = times_ordered + 1
+	WHERE id IN (SELECT author_id FROM book_contributors WHERE book_id = ?)
+	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
 	defer cancel()
 
-	result, err := bs.db.ExecContext(ctx, query, args...)
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := result.RowsAffected()
+	_, err := bs.q.ExecContext(ctx, bs.q.Rebind(query), bookID)
 	if err != nil {
+		bs.logger.Error("some error on updating author table", zap.Error(err))
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return book_errors.ErrBookNotFound
-	}
-
-	query = `
-        UPDATE books 
-        SET available = true
-        WHERE id = $1 AND available = false
-		RETURNING id, year, title, available, author_id
-       `
-
-	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	book := models.Book{
-		Author: &models.Author{},
-	}
-	err = bs.db.QueryRowContext(ctx, query, bookID).Scan(&book.ID, &book.Year, &book.Title, &book.Available, &book.Author.ID)
-	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
-			bs.logger.Error("some error", zap.Error(err))
-			return err
-		case errors.Is(err, sql.ErrNoRows):
-			bs.logger.Error("some error", zap.Error(err))
-			return book_errors.ErrBookNotFound
-		default:
-			bs.logger.Error("some error", zap.Error(err))
-			return err
-		}
-	}
-
 	return nil
 }
@@ -1,16 +1,55 @@
 package repository
 
 import (
+	"context"
 	filter "test/internal/infrastructure/filters"
 	"test/internal/models"
+	"time"
 )
 
 type IBookStorage interface {
-	CreateBook(book *models.Book) error
-	CreateAuthor(author *models.Author) error
-	ListUsers(filters filter.Filters) ([]*models.User, filter.Metadata, error)
-	ListBooks(filters filter.Filters) ([]*models.Book, filter.Metadata, error)
-	ListAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error)
-	RentBook(userID, bookID int64) error
-	ReturnBook(userID, bookID int64) error
+	UnitOfWork
+	CreateBook(ctx context.Context, book *models.Book) error
+	CreateAuthor(ctx context.Context, author *models.Author) error
+	ListUsers(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error)
+	ListBooks(ctx context.Context, filters filter.Filters) ([]*models.Book, filter.Metadata, error)
+	SearchBooks(ctx context.Context, query string, filters filter.Filters) ([]*models.Book, filter.Metadata, error)
+	ListAuthors(ctx context.Context, filters filter.Filters) ([]*models.Author, filter.Metadata, error)
+	RankAuthors(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.AuthorRank, filter.Metadata, error)
+	RankBooks(ctx context.Context, since time.Time, filters filter.Filters) ([]*models.BookRank, filter.Metadata, error)
+	LockBook(ctx context.Context, bookID int64) (*models.Book, error)
+	LockUser(ctx context.Context, userID int64) error
+	SyncAvailable(ctx context.Context, bookID int64) error
+	InsertCopy(ctx context.Context, copy *models.Copy) error
+	GetCopy(ctx context.Context, copyID int64) (*models.Copy, error)
+	AvailableCopy(ctx context.Context, bookID int64) (*models.Copy, error)
+	UpdateCopy(ctx context.Context, copy *models.Copy) error
+	ListCopies(ctx context.Context, bookID int64) ([]*models.Copy, error)
+	InsertRental(ctx context.Context, rental *models.Rental) error
+	OpenRental(ctx context.Context, bookID, userID int64) (*models.Rental, error)
+	CloseRental(ctx context.Context, rentalID int64, returnedAt time.Time) error
+	IncrementTimesOrdered(ctx context.Context, bookID int64) error
+	ListUserRentals(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Rental, filter.Metadata, error)
+	ListBookRentals(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Rental, filter.Metadata, error)
+	ListOverdueRentals(ctx context.Context, dueBefore time.Time) ([]*models.Rental, error)
+	SetFine(ctx context.Context, rental *models.Rental, amount int64, at time.Time) error
+	InsertLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error
+	FineBalance(ctx context.Context, userID int64) (int64, error)
+	ListLedger(ctx context.Context, userID int64, filters filter.Filters) ([]*models.LedgerEntry, filter.Metadata, error)
+	InsertHold(ctx context.Context, hold *models.Hold) error
+	NextHold(ctx context.Context, bookID int64, status string) (*models.Hold, error)
+	ActiveHold(ctx context.Context, bookID, userID int64) (*models.Hold, error)
+	GetHold(ctx context.Context, holdID int64) (*models.Hold, error)
+	UpdateHold(ctx context.Context, hold *models.Hold) error
+	ListExpiredHolds(ctx context.Context, before time.Time) ([]*models.Hold, error)
+	ListUserHolds(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Hold, filter.Metadata, error)
+	ListBookHolds(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Hold, filter.Metadata, error)
+}
+
+// UnitOfWork groups storage calls into one transaction. The storage passed
+// to fn runs every call in that transaction, which commits when fn returns
+// nil and rolls back otherwise; fn gets the transaction's context. Calling
+// Atomic on a storage that is already in a transaction joins it.
+type UnitOfWork interface {
+	Atomic(ctx context.Context, fn func(ctx context.Context, tx IBookStorage) error) error
 }
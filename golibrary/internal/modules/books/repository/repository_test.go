@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"test/config"
+	"test/internal/db"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+
+	"go.uber.org/zap"
+)
+
+func newSqliteStorage(t testing.TB) (*BookStorage, func(query string, args ...any)) {
+	t.Helper()
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(":memory:"))
+	dbx, err := db.NewSqlDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := dbx.Exec(dbx.Rebind(query), args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewBookStorage(dbx, zap.NewNop(), time.Second).(*BookStorage), exec
+}
+
+func listFilters() filter.Filters {
+	return filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+}
+
+func TestSqliteBookStorage(t *testing.T) {
+	ctx := context.Background()
+	storage, exec := newSqliteStorage(t)
+	exec(`INSERT INTO users (name, email, password_hash) VALUES (?, ?, ?)`, "reader", "reader@example.com", []byte("hash"))
+
+	author := &models.Author{Name: "Ursula K. Le Guin"}
+	if err := storage.CreateAuthor(ctx, author); err != nil {
+		t.Fatal(err)
+	}
+	if author.ID == 0 {
+		t.Fatal("expected author id to be set")
+	}
+
+	book := &models.Book{Title: "The Dispossessed", Year: 1974, Author: &models.Author{ID: author.ID}}
+	if err := storage.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.ID == 0 || book.Available || book.Author.Name != author.Name {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	copy := &models.Copy{BookID: book.ID, Barcode: "BK1", Status: models.CopyAvailable}
+	if err := storage.InsertCopy(ctx, copy); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SyncAvailable(ctx, book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("copies", func(t *testing.T) {
+		if err := storage.InsertCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK1", Status: models.CopyAvailable}); !errors.Is(err, book_errors.ErrDuplicateBarcode) {
+			t.Errorf("expected %v, got %v", book_errors.ErrDuplicateBarcode, err)
+		}
+		if err := storage.InsertCopy(ctx, &models.Copy{BookID: 999, Barcode: "BK2", Status: models.CopyAvailable}); !errors.Is(err, book_errors.ErrBookNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+		}
+		if err := storage.UpdateCopy(ctx, &models.Copy{ID: 999, Barcode: "BK3", Status: models.CopyAvailable}); !errors.Is(err, book_errors.ErrCopyNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrCopyNotFound, err)
+		}
+
+		available, err := storage.AvailableCopy(ctx, book.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *available != *copy {
+			t.Errorf("expected %+v, got %+v", copy, available)
+		}
+	})
+
+	t.Run("create book with unknown author", func(t *testing.T) {
+		err := storage.CreateBook(ctx, &models.Book{Title: "Orphan", Year: 2000, Author: &models.Author{ID: 999}})
+		if !errors.Is(err, book_errors.ErrAuthorNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrAuthorNotFound, err)
+		}
+	})
+
+	t.Run("list books", func(t *testing.T) {
+		books, metadata, err := storage.ListBooks(ctx, listFilters())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || metadata.TotalRecords != 1 {
+			t.Errorf("expected one book, got %d (%+v)", len(books), metadata)
+		}
+	})
+
+	t.Run("rent book", func(t *testing.T) {
+		err := storage.Atomic(ctx, func(ctx context.Context, tx IBookStorage) error {
+			locked, err := tx.LockBook(ctx, book.ID)
+			if err != nil {
+				return err
+			}
+			if !locked.Available {
+				t.Error("expected book to be available")
+			}
+			if err := tx.InsertRental(ctx, newRental(copy, 1)); err != nil {
+				return err
+			}
+			lent := *copy
+			lent.Status = models.CopyOnLoan
+			if err := tx.UpdateCopy(ctx, &lent); err != nil {
+				return err
+			}
+			if err := tx.SyncAvailable(ctx, book.ID); err != nil {
+				return err
+			}
+			return tx.IncrementTimesOrdered(ctx, locked.ID)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storage.InsertRental(ctx, newRental(copy, 1)); !errors.Is(err, book_errors.ErrRentInvalid) {
+			t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+		}
+		if err := storage.InsertRental(ctx, newRental(&models.Copy{ID: 999, BookID: 999}, 1)); !errors.Is(err, book_errors.ErrRentInvalid) {
+			t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+		}
+		if _, err := storage.LockBook(ctx, 999); !errors.Is(err, book_errors.ErrBookNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+		}
+
+		users, _, err := storage.ListUsers(ctx, listFilters())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || len(users[0].RentedBooks) != 1 {
+			t.Fatalf("expected one user with one rented book, got %+v", users)
+		}
+
+		authors, _, err := storage.ListAuthors(ctx, listFilters())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(authors) != 1 || authors[0].Times_ordered != 1 || len(authors[0].Books) != 1 {
+			t.Errorf("unexpected authors %+v", authors)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		err := storage.Atomic(ctx, func(ctx context.Context, tx IBookStorage) error {
+			rental, err := tx.OpenRental(ctx, book.ID, 1)
+			if err != nil {
+				return err
+			}
+			if err := tx.CloseRental(ctx, rental.ID, time.Now().UTC()); err != nil {
+				return err
+			}
+			if err := tx.UpdateCopy(ctx, copy); err != nil {
+				return err
+			}
+			if err := tx.SyncAvailable(ctx, book.ID); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("expected %v, got %v", errBoom, err)
+		}
+
+		locked, err := storage.LockBook(ctx, book.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked.Available {
+			t.Error("expected the availability change to be rolled back")
+		}
+	})
+
+	t.Run("return book", func(t *testing.T) {
+		rental, err := storage.OpenRental(ctx, book.ID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rental.Copy.ID != copy.ID {
+			t.Errorf("expected the rental of copy %d, got %+v", copy.ID, rental.Copy)
+		}
+		if err := storage.CloseRental(ctx, rental.ID, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.CloseRental(ctx, rental.ID, time.Now().UTC()); !errors.Is(err, book_errors.ErrBookNotFound) {
+			t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+		}
+		if err := storage.UpdateCopy(ctx, copy); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.SyncAvailable(ctx, book.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		books, _, err := storage.ListBooks(ctx, listFilters())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !books[0].Available || books[0].CopiesAvailable != 1 || books[0].CopiesTotal != 1 {
+			t.Errorf("expected returned book to be available, got %+v", books[0])
+		}
+	})
+}
+
+func TestSqliteBookStorageCancelled(t *testing.T) {
+	storage, _ := newSqliteStorage(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := storage.ListBooks(ctx, listFilters()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	err := storage.Atomic(ctx, func(ctx context.Context, tx IBookStorage) error {
+		t.Error("expected the transaction not to start")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestSqliteBookStorageNestedBooks(t *testing.T) {
+	ctx := context.Background()
+	storage, exec := newSqliteStorage(t)
+	seedCatalog(exec, 3, 2, 2)
+
+	authors, _, err := storage.ListAuthors(ctx, listFilters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 3 {
+		t.Fatalf("expected 3 authors, got %d", len(authors))
+	}
+	for _, author := range authors {
+		if len(author.Books) != 2 {
+			t.Fatalf("expected 2 books for author %d, got %+v", author.ID, author.Books)
+		}
+		for _, book := range author.Books {
+			if book.Author.ID != author.ID {
+				t.Errorf("book %d of author %d belongs to %d", book.ID, author.ID, book.Author.ID)
+			}
+		}
+	}
+
+	users, _, err := storage.ListUsers(ctx, listFilters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+	for _, user := range users {
+		if len(user.RentedBooks) != 2 {
+			t.Errorf("expected 2 rented books for user %d, got %+v", user.ID, user.RentedBooks)
+		}
+	}
+}
+
+// seedCatalog inserts authors with booksPerAuthor books each and users that
+// rent two books apiece.
+func seedCatalog(exec func(query string, args ...any), authors, booksPerAuthor, users int) {
+	now := time.Now().UTC()
+	exec("BEGIN")
+	for a := 1; a <= authors; a++ {
+		exec(`INSERT INTO authors (id, name) VALUES (?, ?)`, a, fmt.Sprintf("author %d", a))
+		for b := 0; b < booksPerAuthor; b++ {
+			exec(`INSERT INTO books (title, year, author_id) VALUES (?, ?, ?)`, fmt.Sprintf("book %d-%d", a, b), 1900+b, a)
+		}
+	}
+	exec(`INSERT INTO book_contributors (book_id, author_id, role, position) SELECT id, author_id, 'author', 1 FROM books`)
+	for u := 1; u <= users; u++ {
+		exec(`INSERT INTO users (id, name, email, password_hash) VALUES (?, ?, ?, ?)`,
+			u, fmt.Sprintf("user %d", u), fmt.Sprintf("user%d@example.com", u), []byte("hash"))
+		exec(`INSERT INTO rented (book_id, user_id, rented_at, due_at) VALUES (?, ?, ?, ?)`, 2*u-1, u, now, now)
+		exec(`INSERT INTO rented (book_id, user_id, rented_at, due_at) VALUES (?, ?, ?, ?)`, 2*u, u, now, now)
+	}
+	exec("COMMIT")
+}
+
+// BenchmarkListAuthors compares the batched listing against the page query
+// followed by one books query per author on the page.
+func BenchmarkListAuthors(b *testing.B) {
+	ctx := context.Background()
+	storage, exec := newSqliteStorage(b)
+	seedCatalog(exec, 1000, 10, 1000)
+
+	filters := listFilters()
+	filters.PageSize = 100
+
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := storage.ListAuthors(ctx, filters); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ids := pageIDs(ctx, b, storage, `SELECT count(*) OVER(), id, name, times_ordered FROM authors ORDER BY id LIMIT ?`, filters.PageSize)
+			for _, id := range ids {
+				if _, err := storage.booksByAuthor(ctx, []int64{id}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkListUsers(b *testing.B) {
+	ctx := context.Background()
+	storage, exec := newSqliteStorage(b)
+	seedCatalog(exec, 1000, 10, 1000)
+
+	filters := listFilters()
+	filters.PageSize = 100
+
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := storage.ListUsers(ctx, filters); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ids := pageIDs(ctx, b, storage, `SELECT count(*) OVER(), id, name, email FROM users WHERE deleted = false ORDER BY id LIMIT ?`, filters.PageSize)
+			for _, id := range ids {
+				if _, err := storage.booksByRenter(ctx, []int64{id}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// pageIDs runs the page query of a listing on its own, without loading the
+// books, and returns the ids on the page. With one books query per id after
+// it, it is the listing the way it was before the books were batched.
+func pageIDs(ctx context.Context, b *testing.B, storage *BookStorage, query string, limit int) []int64 {
+	b.Helper()
+	rows, err := storage.q.QueryContext(ctx, storage.q.Rebind(query), limit)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var total int
+		var id int64
+		var name, extra any
+		if err := rows.Scan(&total, &id, &name, &extra); err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		b.Fatal(err)
+	}
+	return ids
+}
+
+func newRental(copy *models.Copy, userID int64) *models.Rental {
+	now := time.Now().UTC()
+	return &models.Rental{
+		Book:     &models.Book{ID: copy.BookID},
+		Copy:     copy,
+		User:     &models.User{ID: userID},
+		RentedAt: now,
+		DueAt:    now.Add(14 * 24 * time.Hour),
+	}
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"test/internal/db"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// SearchBooks finds the books whose title, contributors or description
+// match the query, most relevant first; filters only pick the page.
+// Postgres uses its full-text search over the books' search documents, the
+// other databases look for every term of the query with LIKE.
+func (bs *BookStorage) SearchBooks(ctx context.Context, query string, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	var (
+		found string
+		args  []any
+	)
+	if bs.q.DriverName() == "postgres" {
+		found, args = fullTextMatch(query)
+	} else {
+		found, args = likeMatch(query)
+	}
+
+	// the relevance of the books read, which cursors are made from
+	relevance := make(map[int64]int64)
+	keys := sortKeys[*models.Book]{
+		"relevance": {"found.relevance", func(b *models.Book) any { return relevance[b.ID] }},
+	}
+	paging, err := newPaging(filters, keys, "books.id", bookID)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	sqlQuery := fmt.Sprintf(`
+        SELECT %s, found.relevance, %s
+        FROM (%s) found
+        INNER JOIN books ON books.id = found.book_id
+		INNER JOIN authors ON books.author_id = authors.id
+        WHERE %s
+        ORDER BY %s
+        %s`, paging.count, bookColumns, found, paging.where, paging.orderBy, paging.limit)
+
+	ctx, cancel := db.WithTimeout(ctx, bs.timeout)
+	defer cancel()
+
+	args = append(args, paging.whereArgs...)
+	args = append(args, paging.limitArgs...)
+
+	rows, err := bs.q.QueryContext(ctx, bs.q.Rebind(sqlQuery), args...)
+	if err != nil {
+		bs.logger.Error("error on searching books", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+
+	books := []*models.Book{}
+
+	for rows.Next() {
+		var score int64
+		book, err := bs.scanBook(rows, &totalRecords, &score)
+		if err != nil {
+			bs.logger.Error("error on scanning a found book", zap.Error(err))
+			return nil, filter.Metadata{}, err
+		}
+
+		relevance[book.ID] = score
+		books = append(books, book)
+	}
+
+	if err = rows.Err(); err != nil {
+		bs.logger.Error("errors on iterating", zap.Error(err))
+		return nil, filter.Metadata{}, err
+	}
+
+	if err := bs.catalogDetails(ctx, books); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	books, metadata := paging.page(books, totalRecords)
+
+	return books, metadata, nil
+}
+
+// fullTextMatch returns the query of the books a Postgres search finds with
+// their relevance, ts_rank over the weighted search document scaled to an
+// integer so that cursors can hold it.
+func fullTextMatch(query string) (string, []any) {
+	return `SELECT books.id AS book_id, CAST(ts_rank(books.search, query) * 1000000 AS bigint) AS relevance
+            FROM books
+            CROSS JOIN websearch_to_tsquery('english', ?) AS query
+            WHERE books.search @@ query`, []any{query}
+}
+
+// likeMatch returns the query of the books a search without full-text
+// support finds with their relevance. Every term has to match the title, a
+// contributor or the description; a match in the title counts most and one
+// in the description least, like the weights of the Postgres search
+// document.
+func likeMatch(query string) (string, []any) {
+	const (
+		title       = `books.title LIKE ? ESCAPE '!'`
+		contributor = `EXISTS (SELECT 1 FROM book_contributors
+            INNER JOIN authors credited ON book_contributors.author_id = credited.id
+            WHERE book_contributors.book_id = books.id AND credited.name LIKE ? ESCAPE '!')`
+		description = `books.description LIKE ? ESCAPE '!'`
+	)
+
+	var where, rank []string
+	var whereArgs, rankArgs []any
+	for _, term := range strings.Fields(query) {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		where = append(where, "("+title+" OR "+contributor+" OR "+description+")")
+		whereArgs = append(whereArgs, pattern, pattern, pattern)
+		rank = append(rank,
+			"CASE WHEN "+title+" THEN 4 ELSE 0 END",
+			"CASE WHEN "+contributor+" THEN 2 ELSE 0 END",
+			"CASE WHEN "+description+" THEN 1 ELSE 0 END")
+		rankArgs = append(rankArgs, pattern, pattern, pattern)
+	}
+	if len(where) == 0 {
+		return "SELECT books.id AS book_id, 0 AS relevance FROM books WHERE 1 = 0", nil
+	}
+
+	found := fmt.Sprintf(`SELECT books.id AS book_id, %s AS relevance
+            FROM books
+            WHERE %s`, strings.Join(rank, " + "), strings.Join(where, " AND "))
+	return found, append(rankArgs, whereArgs...)
+}
+
+// likeEscaper escapes the LIKE wildcards of a search term, with ! as the
+// escape character since a backslash means different things to mysql and
+// sqlite.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+)
+
+// CopyUpdate holds the fields of a copy to change, nil ones are kept.
+type CopyUpdate struct {
+	Barcode   *string
+	Condition *string
+	Location  *string
+	Status    *string
+}
+
+// AddCopy adds a copy of a book, on the shelf unless another status is
+// given. A copy that goes on the shelf is kept for the first patron waiting
+// for the book, if there is one.
+func (s *BookService) AddCopy(ctx context.Context, copy *models.Copy) error {
+	if copy.Status == "" {
+		copy.Status = models.CopyAvailable
+	}
+	if copy.Circulating() {
+		return book_errors.ErrCopyInvalid
+	}
+
+	return s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		if _, err := tx.LockBook(ctx, copy.BookID); err != nil {
+			return err
+		}
+		if err := tx.InsertCopy(ctx, copy); err != nil {
+			return err
+		}
+		if copy.Status == models.CopyAvailable {
+			return s.passOn(ctx, tx, copy, s.now().UTC())
+		}
+		return nil
+	})
+}
+
+// UpdateCopy changes a copy and returns it. Only librarians' statuses can be
+// set, and copies that are on loan or kept for a hold only change their
+// condition and location until they come back.
+func (s *BookService) UpdateCopy(ctx context.Context, copyID int64, update CopyUpdate) (*models.Copy, error) {
+	var copy *models.Copy
+	err := s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		found, err := tx.GetCopy(ctx, copyID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.LockBook(ctx, found.BookID); err != nil {
+			return err
+		}
+		// read it again under the book lock, it may have been lent meanwhile
+		copy, err = tx.GetCopy(ctx, copyID)
+		if err != nil {
+			return err
+		}
+
+		if update.Status != nil || update.Barcode != nil {
+			changed := &models.Copy{Status: copy.Status}
+			if update.Status != nil {
+				changed.Status = *update.Status
+			}
+			if copy.Circulating() || changed.Circulating() {
+				return book_errors.ErrCopyInvalid
+			}
+		}
+		if update.Barcode != nil {
+			copy.Barcode = *update.Barcode
+		}
+		if update.Condition != nil {
+			copy.Condition = *update.Condition
+		}
+		if update.Location != nil {
+			copy.Location = *update.Location
+		}
+		if update.Status != nil {
+			copy.Status = *update.Status
+		}
+
+		if copy.Status == models.CopyAvailable {
+			return s.passOn(ctx, tx, copy, s.now().UTC())
+		}
+		if err := tx.UpdateCopy(ctx, copy); err != nil {
+			return err
+		}
+		return tx.SyncAvailable(ctx, copy.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copy, nil
+}
+
+func (s *BookService) ListCopies(ctx context.Context, bookID int64) ([]*models.Copy, error) {
+	return s.storage.ListCopies(ctx, bookID)
+}
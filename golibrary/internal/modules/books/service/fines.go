@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+)
+
+const day = 24 * time.Hour
+
+// fine is what a rental due at dueAt costs at the given time. Once the grace
+// period is over every started day since the due date is charged.
+func (p Policy) fine(dueAt, at time.Time) int64 {
+	late := at.Sub(dueAt)
+	if p.DailyFine <= 0 || late <= 0 || late <= p.FineGrace {
+		return 0
+	}
+
+	days := int64((late + day - 1) / day)
+	amount := days * p.DailyFine
+	if p.FineCap > 0 && amount > p.FineCap {
+		amount = p.FineCap
+	}
+	return amount
+}
+
+// AccrueFines brings the fines of all overdue books that are still out up to
+// date and returns how many rentals it charged. It is run periodically in
+// the background; a book returned meanwhile has got its final fine from
+// ReturnBook and is skipped.
+func (s *BookService) AccrueFines(ctx context.Context) (int, error) {
+	if s.policy.DailyFine <= 0 {
+		return 0, nil
+	}
+
+	now := s.now().UTC()
+	rentals, err := s.storage.ListOverdueRentals(ctx, now.Add(-s.policy.FineGrace))
+	if err != nil {
+		return 0, err
+	}
+
+	charged := 0
+	for _, rental := range rentals {
+		amount := s.policy.fine(rental.DueAt, now)
+		if amount == 0 {
+			continue
+		}
+
+		err := s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+			if _, err := tx.LockBook(ctx, rental.Book.ID); err != nil {
+				return err
+			}
+			open, err := tx.OpenRental(ctx, rental.Book.ID, rental.User.ID)
+			if err != nil {
+				return err
+			}
+			if open.ID != rental.ID {
+				return book_errors.ErrBookNotFound
+			}
+			return tx.SetFine(ctx, rental, amount, now)
+		})
+		if errors.Is(err, book_errors.ErrBookNotFound) {
+			continue
+		}
+		if err != nil {
+			return charged, err
+		}
+		charged++
+	}
+
+	return charged, nil
+}
+
+// FineBalance is what the user owes, in cents.
+func (s *BookService) FineBalance(ctx context.Context, userID int64) (int64, error) {
+	return s.storage.FineBalance(ctx, userID)
+}
+
+func (s *BookService) ListLedger(ctx context.Context, userID int64, filters filter.Filters) ([]*models.LedgerEntry, filter.Metadata, error) {
+	return s.storage.ListLedger(ctx, userID, filters)
+}
+
+// PayFine records a payment of amount cents, which may not be more than the
+// user owes.
+func (s *BookService) PayFine(ctx context.Context, userID, amount int64, note string) (*models.LedgerEntry, error) {
+	return s.credit(ctx, userID, amount, models.LedgerPayment, note)
+}
+
+// WaiveFine forgives amount cents of the user's fines, all of them when
+// amount is zero.
+func (s *BookService) WaiveFine(ctx context.Context, userID, amount int64, note string) (*models.LedgerEntry, error) {
+	return s.credit(ctx, userID, amount, models.LedgerWaiver, note)
+}
+
+func (s *BookService) credit(ctx context.Context, userID, amount int64, kind, note string) (*models.LedgerEntry, error) {
+	var entry *models.LedgerEntry
+	err := s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		// a concurrent payment must not spend the balance read here
+		if err := tx.LockUser(ctx, userID); err != nil {
+			return err
+		}
+		balance, err := tx.FineBalance(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if amount == 0 && kind == models.LedgerWaiver {
+			amount = balance
+		}
+		if amount <= 0 || amount > balance {
+			return book_errors.ErrInvalidAmount
+		}
+
+		now := s.now().UTC()
+		entry = &models.LedgerEntry{
+			UserID:    userID,
+			Kind:      kind,
+			Amount:    -amount,
+			Note:      note,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		return tx.InsertLedgerEntry(ctx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
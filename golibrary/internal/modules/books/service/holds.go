@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+)
+
+// PlaceHold puts the user in the queue for a book that is out and returns
+// the hold with its place in the queue. Available books are rented instead.
+func (s *BookService) PlaceHold(ctx context.Context, bookID, userID int64) (*models.Hold, error) {
+	var hold *models.Hold
+	err := s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		book, err := tx.LockBook(ctx, bookID)
+		if err != nil {
+			if errors.Is(err, book_errors.ErrBookNotFound) {
+				return book_errors.ErrHoldInvalid
+			}
+			return err
+		}
+		if book.Available {
+			return book_errors.ErrHoldInvalid
+		}
+
+		_, err = tx.OpenRental(ctx, bookID, userID)
+		switch {
+		case err == nil:
+			return book_errors.ErrHoldInvalid
+		case !errors.Is(err, book_errors.ErrBookNotFound):
+			return err
+		}
+
+		placed := &models.Hold{
+			Book:      &models.Book{ID: bookID},
+			User:      &models.User{ID: userID},
+			Status:    models.HoldWaiting,
+			CreatedAt: s.now().UTC(),
+		}
+		if err := tx.InsertHold(ctx, placed); err != nil {
+			return err
+		}
+		hold, err = tx.GetHold(ctx, placed.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// CancelHold takes the user out of a book's queue. A copy that was kept for
+// the user goes to the next patron.
+func (s *BookService) CancelHold(ctx context.Context, userID, holdID int64) error {
+	return s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		hold, err := tx.GetHold(ctx, holdID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.LockBook(ctx, hold.Book.ID); err != nil {
+			return err
+		}
+		// read it again under the book lock, it may have moved on meanwhile
+		hold, err = tx.GetHold(ctx, holdID)
+		if err != nil {
+			return err
+		}
+		if hold.User.ID != userID || !hold.Active() {
+			return book_errors.ErrHoldNotFound
+		}
+
+		kept := hold.Copy
+		closedAt := s.now().UTC()
+		hold.Status = models.HoldCancelled
+		hold.ClosedAt = &closedAt
+		if err := tx.UpdateHold(ctx, hold); err != nil {
+			return err
+		}
+		if kept == nil {
+			return nil
+		}
+		copy, err := tx.GetCopy(ctx, kept.ID)
+		if err != nil {
+			return err
+		}
+		return s.passOn(ctx, tx, copy, closedAt)
+	})
+}
+
+// ExpireHolds closes the holds that were not picked up within the pickup
+// window and passes their copies on, it returns how many expired. It is run
+// periodically in the background.
+func (s *BookService) ExpireHolds(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	holds, err := s.storage.ListExpiredHolds(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, hold := range holds {
+		err := s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+			if _, err := tx.LockBook(ctx, hold.Book.ID); err != nil {
+				return err
+			}
+			current, err := tx.GetHold(ctx, hold.ID)
+			if err != nil {
+				return err
+			}
+			// picked up or cancelled since it was listed
+			if current.Status != models.HoldReady {
+				return book_errors.ErrHoldNotFound
+			}
+
+			current.Status = models.HoldExpired
+			current.ClosedAt = &now
+			if err := tx.UpdateHold(ctx, current); err != nil {
+				return err
+			}
+			copy, err := tx.GetCopy(ctx, current.Copy.ID)
+			if err != nil {
+				return err
+			}
+			return s.passOn(ctx, tx, copy, now)
+		})
+		if errors.Is(err, book_errors.ErrHoldNotFound) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// passOn keeps a copy that came back for the next patron in its book's
+// queue until the pickup window closes, or puts it on the shelf when nobody
+// is waiting. The caller holds the book lock.
+func (s *BookService) passOn(ctx context.Context, tx repository.IBookStorage, copy *models.Copy, at time.Time) error {
+	next, err := tx.NextHold(ctx, copy.BookID, models.HoldWaiting)
+	switch {
+	case errors.Is(err, book_errors.ErrHoldNotFound):
+		copy.Status = models.CopyAvailable
+		err = tx.UpdateCopy(ctx, copy)
+	case err == nil:
+		expiresAt := at.Add(s.policy.PickupWindow)
+		next.Copy = copy
+		next.Status = models.HoldReady
+		next.ReadyAt = &at
+		next.ExpiresAt = &expiresAt
+		if err = tx.UpdateHold(ctx, next); err == nil {
+			copy.Status = models.CopyOnHold
+			err = tx.UpdateCopy(ctx, copy)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.SyncAvailable(ctx, copy.BookID)
+}
+
+func (s *BookService) ListUserHolds(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	return s.storage.ListUserHolds(ctx, userID, status, filters)
+}
+
+func (s *BookService) ListBookHolds(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Hold, filter.Metadata, error) {
+	return s.storage.ListBookHolds(ctx, bookID, filters)
+}
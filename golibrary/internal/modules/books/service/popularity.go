@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	filter "test/internal/infrastructure/filters"
+	"test/internal/models"
+)
+
+// windowStart is where a popularity window that ends now starts, the zero
+// time for all time.
+func (s *BookService) windowStart(window string) (time.Time, error) {
+	length, ok := models.PopularityWindows[window]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown popularity window %q", window)
+	}
+	if length == 0 {
+		return time.Time{}, nil
+	}
+	return s.now().UTC().Add(-length), nil
+}
+
+// RankAuthors is the leaderboard of the authors whose books were rented the
+// most in the window.
+func (s *BookService) RankAuthors(ctx context.Context, window string, filters filter.Filters) ([]*models.AuthorRank, filter.Metadata, error) {
+	since, err := s.windowStart(window)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	return s.storage.RankAuthors(ctx, since, filters)
+}
+
+// RankBooks is the leaderboard of the books rented the most in the window.
+func (s *BookService) RankBooks(ctx context.Context, window string, filters filter.Filters) ([]*models.BookRank, filter.Metadata, error) {
+	since, err := s.windowStart(window)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	return s.storage.RankBooks(ctx, since, filters)
+}
@@ -1,66 +1,321 @@
 package service
 
 import (
-	"sort"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
 	filter "test/internal/infrastructure/filters"
+	"test/internal/infrastructure/validator"
 	"test/internal/models"
+	book_errors "test/internal/models/errors"
 	"test/internal/modules/books/repository"
+	"time"
 )
 
+// LanguageRX matches an ISO 639 language code, stored in lower case.
+var LanguageRX = regexp.MustCompile("^[a-z]{2,3}$")
+
 type IBookService interface {
-	CreateBook(book *models.Book) error
-	CreateAuthor(author *models.Author) error
-	ListUsers(filters filter.Filters) ([]*models.User, filter.Metadata, error)
-	ListBooks(filters filter.Filters) ([]*models.Book, filter.Metadata, error)
-	ListAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error)
-	ListTopRatedAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error)
-	RentBook(userID, bookID int64) error
-	ReturnBook(userID, bookID int64) error
+	CreateBook(ctx context.Context, book *models.Book) error
+	CreateAuthor(ctx context.Context, author *models.Author) error
+	ListUsers(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error)
+	ListBooks(ctx context.Context, filters filter.Filters) ([]*models.Book, filter.Metadata, error)
+	SearchBooks(ctx context.Context, query string, filters filter.Filters) ([]*models.Book, filter.Metadata, error)
+	ListAuthors(ctx context.Context, filters filter.Filters) ([]*models.Author, filter.Metadata, error)
+	RankAuthors(ctx context.Context, window string, filters filter.Filters) ([]*models.AuthorRank, filter.Metadata, error)
+	RankBooks(ctx context.Context, window string, filters filter.Filters) ([]*models.BookRank, filter.Metadata, error)
+	RentBook(ctx context.Context, userID, bookID int64) error
+	ReturnBook(ctx context.Context, userID, bookID int64) error
+	ListUserRentals(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Rental, filter.Metadata, error)
+	ListBookRentals(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Rental, filter.Metadata, error)
+	AccrueFines(ctx context.Context) (int, error)
+	FineBalance(ctx context.Context, userID int64) (int64, error)
+	ListLedger(ctx context.Context, userID int64, filters filter.Filters) ([]*models.LedgerEntry, filter.Metadata, error)
+	PayFine(ctx context.Context, userID, amount int64, note string) (*models.LedgerEntry, error)
+	WaiveFine(ctx context.Context, userID, amount int64, note string) (*models.LedgerEntry, error)
+	PlaceHold(ctx context.Context, bookID, userID int64) (*models.Hold, error)
+	CancelHold(ctx context.Context, userID, holdID int64) error
+	ExpireHolds(ctx context.Context) (int, error)
+	ListUserHolds(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Hold, filter.Metadata, error)
+	ListBookHolds(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Hold, filter.Metadata, error)
+	AddCopy(ctx context.Context, copy *models.Copy) error
+	UpdateCopy(ctx context.Context, copyID int64, update CopyUpdate) (*models.Copy, error)
+	ListCopies(ctx context.Context, bookID int64) ([]*models.Copy, error)
+}
+
+// DefaultLoanPeriod is how long a book may be kept when no other period is
+// configured.
+const DefaultLoanPeriod = 14 * 24 * time.Hour
+
+// DefaultPickupWindow is how long a returned book is kept for the next patron
+// in its queue when no other window is configured.
+const DefaultPickupWindow = 3 * 24 * time.Hour
+
+// Policy holds the circulation rules of the library. Money is in cents.
+type Policy struct {
+	// LoanPeriod is the time between renting a book and its due date.
+	LoanPeriod time.Duration
+	// DailyFine is charged for every started day past the due date, no fines
+	// are charged when it is not positive.
+	DailyFine int64
+	// FineGrace is how late a book may come back without a fine.
+	FineGrace time.Duration
+	// FineCap limits the fine of one rental, zero means no limit.
+	FineCap int64
+	// FineThreshold is the balance above which a patron can't rent, zero
+	// means no limit.
+	FineThreshold int64
+	// PickupWindow is how long a returned book waits for the patron whose
+	// hold is next.
+	PickupWindow time.Duration
 }
 
 type BookService struct {
 	storage repository.IBookStorage
+	policy  Policy
+	now     func() time.Time
 }
 
-func NewBookService(repo repository.IBookStorage) *BookService {
-	return &BookService{storage: repo}
+func NewBookService(repo repository.IBookStorage, policy Policy) *BookService {
+	if policy.LoanPeriod <= 0 {
+		policy.LoanPeriod = DefaultLoanPeriod
+	}
+	if policy.PickupWindow <= 0 {
+		policy.PickupWindow = DefaultPickupWindow
+	}
+	return &BookService{storage: repo, policy: policy, now: time.Now}
 }
 
-func (s *BookService) CreateBook(book *models.Book) error {
-	return s.storage.CreateBook(book)
+// SetClock replaces the time source rentals are stamped with. The seed
+// command uses it to spread demo rentals over the past.
+func (s *BookService) SetClock(now func() time.Time) {
+	s.now = now
 }
 
-func (s *BookService) CreateAuthor(author *models.Author) error {
-	return s.storage.CreateAuthor(author)
+func (s *BookService) CreateBook(ctx context.Context, book *models.Book) error {
+	return s.storage.CreateBook(ctx, book)
 }
 
-func (s *BookService) ListUsers(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
-	return s.storage.ListUsers(filters)
+func (s *BookService) CreateAuthor(ctx context.Context, author *models.Author) error {
+	return s.storage.CreateAuthor(ctx, author)
 }
 
-func (s *BookService) ListBooks(filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
-	return s.storage.ListBooks(filters)
+func (s *BookService) ListUsers(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+	return s.storage.ListUsers(ctx, filters)
+}
+
+func (s *BookService) ListBooks(ctx context.Context, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	return s.storage.ListBooks(ctx, filters)
+}
+
+// SearchBooks lists the books matching a query checked by ValidateSearch,
+// most relevant first.
+func (s *BookService) SearchBooks(ctx context.Context, query string, filters filter.Filters) ([]*models.Book, filter.Metadata, error) {
+	return s.storage.SearchBooks(ctx, query, filters)
+}
+
+func (s *BookService) ListAuthors(ctx context.Context, filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
+	return s.storage.ListAuthors(ctx, filters)
+}
+
+// RentBook lends the user a copy of the book and counts the order for each
+// of its contributors. All writes happen in one transaction with the book row locked, so
+// a copy can't be lent twice. A copy kept for a hold is only lent to the
+// patron it is kept for, which fulfils the hold; a patron borrows one copy of
+// a title at a time.
+func (s *BookService) RentBook(ctx context.Context, bookID, userID int64) error {
+	return s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		_, err := tx.LockBook(ctx, bookID)
+		if err != nil {
+			if errors.Is(err, book_errors.ErrBookNotFound) {
+				return book_errors.ErrRentInvalid
+			}
+			return err
+		}
+		_, err = tx.OpenRental(ctx, bookID, userID)
+		switch {
+		case err == nil:
+			return book_errors.ErrRentInvalid
+		case !errors.Is(err, book_errors.ErrBookNotFound):
+			return err
+		}
+
+		pickup, err := tx.ActiveHold(ctx, bookID, userID)
+		if err != nil && !errors.Is(err, book_errors.ErrHoldNotFound) {
+			return err
+		}
+		var copy *models.Copy
+		if pickup != nil && pickup.Status == models.HoldReady {
+			copy, err = tx.GetCopy(ctx, pickup.Copy.ID)
+		} else {
+			copy, err = tx.AvailableCopy(ctx, bookID)
+			if errors.Is(err, book_errors.ErrCopyNotFound) {
+				return book_errors.ErrRentInvalid
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		balance, err := tx.FineBalance(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if s.policy.FineThreshold > 0 && balance > s.policy.FineThreshold {
+			return book_errors.ErrRentBlocked
+		}
+
+		rentedAt := s.now().UTC()
+		rental := &models.Rental{
+			Book:     &models.Book{ID: bookID},
+			Copy:     copy,
+			User:     &models.User{ID: userID},
+			RentedAt: rentedAt,
+			DueAt:    rentedAt.Add(s.policy.LoanPeriod),
+		}
+		if err := tx.InsertRental(ctx, rental); err != nil {
+			return err
+		}
+		copy.Status = models.CopyOnLoan
+		if err := tx.UpdateCopy(ctx, copy); err != nil {
+			return err
+		}
+		// a patron who got a copy off the shelf while still queued leaves
+		// the queue as well
+		if pickup != nil {
+			pickup.Status = models.HoldFulfilled
+			pickup.ClosedAt = &rentedAt
+			if err := tx.UpdateHold(ctx, pickup); err != nil {
+				return err
+			}
+		}
+		if err := tx.SyncAvailable(ctx, bookID); err != nil {
+			return err
+		}
+		return tx.IncrementTimesOrdered(ctx, bookID)
+	})
 }
 
-func (s *BookService) ListAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
-	return s.storage.ListAuthors(filters)
+// ReturnBook closes the user's rental, charges its final fine if it is late
+// and passes the copy on to the next hold or puts it back on the shelf, all
+// in one transaction. The rental is kept as history.
+func (s *BookService) ReturnBook(ctx context.Context, bookID, userID int64) error {
+	return s.storage.Atomic(ctx, func(ctx context.Context, tx repository.IBookStorage) error {
+		if _, err := tx.LockBook(ctx, bookID); err != nil {
+			return err
+		}
+		rental, err := tx.OpenRental(ctx, bookID, userID)
+		if err != nil {
+			return err
+		}
+
+		returnedAt := s.now().UTC()
+		if err := tx.CloseRental(ctx, rental.ID, returnedAt); err != nil {
+			return err
+		}
+		if fine := s.policy.fine(rental.DueAt, returnedAt); fine > 0 {
+			if err := tx.SetFine(ctx, rental, fine, returnedAt); err != nil {
+				return err
+			}
+		}
+		copy, err := tx.GetCopy(ctx, rental.Copy.ID)
+		if err != nil {
+			return err
+		}
+		return s.passOn(ctx, tx, copy, returnedAt)
+	})
 }
 
-func (s *BookService) ListTopRatedAuthors(filters filter.Filters) ([]*models.Author, filter.Metadata, error) {
-	authors, meta, err := s.storage.ListAuthors(filters)
+func (s *BookService) ListUserRentals(ctx context.Context, userID int64, status string, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	rentals, metadata, err := s.storage.ListUserRentals(ctx, userID, status, filters)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	s.flagOverdue(rentals)
+	return rentals, metadata, nil
+}
 
+func (s *BookService) ListBookRentals(ctx context.Context, bookID int64, filters filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+	rentals, metadata, err := s.storage.ListBookRentals(ctx, bookID, filters)
 	if err != nil {
 		return nil, filter.Metadata{}, err
 	}
-	sort.Slice(authors, func(i, j int) bool {
-		return authors[i].Times_ordered > authors[j].Times_ordered
-	})
-	return authors, meta, nil
+	s.flagOverdue(rentals)
+	return rentals, metadata, nil
 }
-func (s *BookService) RentBook(bookID, userID int64) error {
-	return s.storage.RentBook(bookID, userID)
+
+func (s *BookService) flagOverdue(rentals []*models.Rental) {
+	now := s.now()
+	for _, rental := range rentals {
+		rental.Overdue = rental.ReturnedAt == nil && now.After(rental.DueAt)
+	}
 }
 
-func (s *BookService) ReturnBook(bookID, userID int64) error {
-	return s.storage.ReturnBook(bookID, userID)
+// ValidateSearch checks a search query and collapses its white space.
+func ValidateSearch(v *validator.Validator, query *string) {
+	*query = strings.Join(strings.Fields(*query), " ")
+	v.Check(*query != "", "q", "must be provided")
+	v.Check(len(*query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(len(strings.Fields(*query)) <= 10, "q", "must not contain more than 10 terms")
+}
+
+// ValidateBook checks a new book. The ISBN, language and genres are
+// normalized in place so the stored values compare equal: the ISBN becomes
+// an ISBN-13, the language and genres lower case, and repeated genres are
+// dropped. A book given only an author gets it as its single contributor;
+// contributors are numbered in the order given and the first one becomes
+// the book's author.
+func ValidateBook(v *validator.Validator, book *models.Book) {
+	v.Check(book.Title != "", "title", "must be provided")
+	v.Check(len(book.Title) <= 255, "title", "must not be more than 255 bytes long")
+
+	if len(book.Contributors) == 0 && book.Author != nil {
+		book.Contributors = []models.Contributor{{Author: book.Author, Role: models.RoleAuthor}}
+	}
+	v.Check(len(book.Contributors) > 0, "contributors", "must be provided")
+	v.Check(len(book.Contributors) <= 50, "contributors", "must not contain more than 50 values")
+	for i := range book.Contributors {
+		contributor := &book.Contributors[i]
+		if contributor.Role == "" {
+			contributor.Role = models.RoleAuthor
+		}
+		contributor.Position = i + 1
+		v.Check(contributor.Author != nil && contributor.Author.ID > 0, "contributors", "must all have an author")
+		v.Check(validator.PermittedValue(contributor.Role, models.ContributorRoles...), "contributors", "must have a role of author, editor, translator or illustrator")
+		for _, other := range book.Contributors[:i] {
+			if contributor.Author != nil && other.Author != nil && other.Author.ID == contributor.Author.ID && other.Role == contributor.Role {
+				v.AddError("contributors", "must not credit an author twice with the same role")
+			}
+		}
+	}
+	if len(book.Contributors) > 0 && book.Contributors[0].Author != nil {
+		book.Author = &models.Author{ID: book.Contributors[0].Author.ID}
+	}
+
+	if book.ISBN != "" {
+		isbn, ok := models.NormalizeISBN(book.ISBN)
+		v.Check(ok, "isbn", "must be a valid ISBN-10 or ISBN-13")
+		book.ISBN = isbn
+	}
+	v.Check(len(book.Publisher) <= 255, "publisher", "must not be more than 255 bytes long")
+	v.Check(len(book.Edition) <= 100, "edition", "must not be more than 100 bytes long")
+	book.Language = strings.ToLower(strings.TrimSpace(book.Language))
+	v.Check(book.Language == "" || validator.Matches(book.Language, LanguageRX), "language", "must be an ISO 639 language code")
+	v.Check(book.Pages >= 0, "pages", "must not be negative")
+	v.Check(book.Pages <= 100_000, "pages", "must be a maximum of 100000")
+	v.Check(len(book.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+
+	genres := make([]string, 0, len(book.Genres))
+	for _, genre := range book.Genres {
+		genre = strings.ToLower(strings.TrimSpace(genre))
+		v.Check(genre != "", "genres", "must not contain empty values")
+		v.Check(len(genre) <= 50, "genres", "must not contain values more than 50 bytes long")
+		if !slices.Contains(genres, genre) {
+			genres = append(genres, genre)
+		}
+	}
+	v.Check(len(genres) <= 20, "genres", "must not contain more than 20 values")
+	book.Genres = genres
 }
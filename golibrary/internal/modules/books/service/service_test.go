@@ -0,0 +1,1197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"test/config"
+	"test/internal/db"
+	"test/internal/db/memory"
+	filter "test/internal/infrastructure/filters"
+	"test/internal/infrastructure/validator"
+	"test/internal/models"
+	book_errors "test/internal/models/errors"
+	"test/internal/modules/books/repository"
+	user_repository "test/internal/modules/user/repository"
+
+	"go.uber.org/zap"
+)
+
+// patrons are the users both test storages start with, ids 1 to 3.
+var patrons = []string{"carl", "dana", "erin"}
+
+func newService(t *testing.T) (*BookService, *models.Book) {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.New()
+	users := memory.NewUserStorage(store)
+	for _, name := range patrons {
+		if err := users.Insert(ctx, &models.User{Name: name, Email: name + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return seed(t, NewBookService(memory.NewBookStorage(store), Policy{}))
+}
+
+func newSqliteService(t *testing.T) (*BookService, *models.Book) {
+	t.Helper()
+	ctx := context.Background()
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(":memory:"))
+	dbx, err := db.NewSqlDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+
+	users := user_repository.NewUserModel(dbx, time.Second)
+	for _, name := range patrons {
+		if err := users.Insert(ctx, &models.User{Name: name, Email: name + "@example.com", Password: models.Password{Hash: []byte("hash")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return seed(t, NewBookService(repository.NewBookStorage(dbx, zap.NewNop(), time.Second), Policy{}))
+}
+
+// forEachBackend runs test against a memory and a sqlite storage, each
+// seeded with the patrons and one book.
+func forEachBackend(t *testing.T, test func(t *testing.T, service *BookService, book *models.Book)) {
+	t.Helper()
+	t.Run("memory", func(t *testing.T) {
+		service, book := newService(t)
+		test(t, service, book)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		service, book := newSqliteService(t)
+		test(t, service, book)
+	})
+}
+
+func seed(t *testing.T, service *BookService) (*BookService, *models.Book) {
+	t.Helper()
+	ctx := context.Background()
+	author := &models.Author{Name: "Stanislaw Lem"}
+	if err := service.CreateAuthor(ctx, author); err != nil {
+		t.Fatal(err)
+	}
+	book := &models.Book{Title: "Solaris", Year: 1961, Author: &models.Author{ID: author.ID}}
+	if err := service.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AddCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK1"}); err != nil {
+		t.Fatal(err)
+	}
+	return service, book
+}
+
+func TestRentBook(t *testing.T) {
+	ctx := context.Background()
+	service, book := newService(t)
+
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+	}
+	if err := service.RentBook(ctx, 42, 1); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+	}
+
+	authors, _, err := service.ListAuthors(ctx, filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authors[0].Times_ordered != 1 || authors[0].Books[0].Available {
+		t.Errorf("unexpected author %+v", authors[0])
+	}
+
+	if err := service.ReturnBook(ctx, book.ID, 2); !errors.Is(err, book_errors.ErrBookNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+	}
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Errorf("expected a returned book to be rentable, got %v", err)
+	}
+}
+
+func TestRentBookConcurrently(t *testing.T) {
+	forEachBackend(t, rentConcurrently)
+}
+
+func rentConcurrently(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rented := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if service.RentBook(ctx, book.ID, 1) == nil {
+				mu.Lock()
+				rented++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rented != 1 {
+		t.Errorf("expected exactly one successful rent, got %d", rented)
+	}
+}
+
+func TestPayFineConcurrently(t *testing.T) {
+	forEachBackend(t, payConcurrently)
+}
+
+func payConcurrently(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	service.policy = Policy{LoanPeriod: 7 * 24 * time.Hour, DailyFine: 50, FineGrace: 24 * time.Hour}
+
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(10 * 24 * time.Hour)
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	owed, err := service.FineBalance(ctx, 1)
+	if err != nil || owed <= 0 {
+		t.Fatalf("expected a fine, got %d (%v)", owed, err)
+	}
+
+	// each payment is more than half of the balance, only one fits
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	paid := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.PayFine(ctx, 1, owed/2+1, ""); err == nil {
+				mu.Lock()
+				paid++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if paid != 1 {
+		t.Errorf("expected exactly one successful payment, got %d", paid)
+	}
+	if balance, err := service.FineBalance(ctx, 1); err != nil || balance != owed-(owed/2+1) {
+		t.Errorf("expected a balance of %d, got %d (%v)", owed-(owed/2+1), balance, err)
+	}
+	if _, err := service.PayFine(ctx, 42, 1, ""); !errors.Is(err, book_errors.ErrUserNotFound) {
+		t.Errorf("expected %v for an unknown user, got %v", book_errors.ErrUserNotFound, err)
+	}
+}
+
+func TestRentalHistory(t *testing.T) {
+	forEachBackend(t, rentalHistory)
+}
+
+func rentalHistory(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	service.policy.LoanPeriod = 7 * 24 * time.Hour
+
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(48 * time.Hour)
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatalf("expected a returned book to be rentable, got %v", err)
+	}
+
+	newestFirst := filter.Filters{Page: 1, PageSize: 20, Sort: "-rented_at", SortSafelist: []string{"-rented_at"}}
+
+	history, metadata, err := service.ListBookRentals(ctx, book.ID, newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || metadata.TotalRecords != 2 {
+		t.Fatalf("expected two rentals, got %+v", history)
+	}
+	latest, first := history[0], history[1]
+	if latest.ReturnedAt != nil || latest.User.Name != "carl" {
+		t.Errorf("expected carl to have the book now, got %+v", latest)
+	}
+	if first.ReturnedAt == nil || !first.ReturnedAt.Equal(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected return date %v", first.ReturnedAt)
+	}
+	if !first.DueAt.Equal(time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the due date a week after renting, got %v", first.DueAt)
+	}
+
+	current, _, err := service.ListUserRentals(ctx, 1, models.RentalCurrent, newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].Book.Title != book.Title || current[0].Book.Author.Name == "" {
+		t.Errorf("expected the current loan with its book, got %+v", current)
+	}
+
+	returned, _, err := service.ListUserRentals(ctx, 1, models.RentalReturned, newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(returned) != 1 || returned[0].ID != first.ID {
+		t.Errorf("expected the returned loan, got %+v", returned)
+	}
+}
+
+func TestPolicyFine(t *testing.T) {
+	due := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	policy := Policy{DailyFine: 50, FineGrace: 24 * time.Hour, FineCap: 300}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int64
+	}{
+		{"before due", due.Add(-time.Hour), 0},
+		{"within grace", due.Add(24 * time.Hour), 0},
+		{"after grace", due.Add(25 * time.Hour), 100},
+		{"three days", due.Add(72 * time.Hour), 150},
+		{"capped", due.Add(30 * 24 * time.Hour), 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.fine(due, tt.at); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	if got := (Policy{}).fine(due, due.Add(72*time.Hour)); got != 0 {
+		t.Errorf("expected no fines without a daily rate, got %d", got)
+	}
+}
+
+func TestFines(t *testing.T) {
+	forEachBackend(t, fines)
+}
+
+func fines(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	service.policy = Policy{
+		LoanPeriod:    7 * 24 * time.Hour,
+		DailyFine:     50,
+		FineGrace:     24 * time.Hour,
+		FineCap:       1000,
+		FineThreshold: 100,
+	}
+
+	balance := func() int64 {
+		t.Helper()
+		balance, err := service.FineBalance(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return balance
+	}
+
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// still within the grace period
+	now = now.Add(8 * 24 * time.Hour)
+	if charged, err := service.AccrueFines(ctx); err != nil || charged != 0 {
+		t.Fatalf("expected nothing to be charged, got %d, %v", charged, err)
+	}
+
+	rentals, _, err := service.ListUserRentals(ctx, 1, models.RentalCurrent, filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rentals) != 1 || !rentals[0].Overdue {
+		t.Errorf("expected the rental to be overdue, got %+v", rentals)
+	}
+
+	// two days late, then three: the fine grows instead of adding up
+	now = now.Add(24 * time.Hour)
+	if charged, err := service.AccrueFines(ctx); err != nil || charged != 1 {
+		t.Fatalf("expected one rental to be charged, got %d, %v", charged, err)
+	}
+	if got := balance(); got != 100 {
+		t.Errorf("expected a balance of 100, got %d", got)
+	}
+	now = now.Add(24 * time.Hour)
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 150 {
+		t.Errorf("expected a balance of 150 after the return, got %d", got)
+	}
+	if charged, err := service.AccrueFines(ctx); err != nil || charged != 0 {
+		t.Errorf("expected returned books not to be charged, got %d, %v", charged, err)
+	}
+
+	if err := service.RentBook(ctx, book.ID, 1); !errors.Is(err, book_errors.ErrRentBlocked) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentBlocked, err)
+	}
+
+	// without a threshold nobody is blocked
+	service.policy.FineThreshold = 0
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Errorf("expected no block without a threshold, got %v", err)
+	}
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	service.policy.FineThreshold = 100
+
+	if _, err := service.PayFine(ctx, 1, 200, ""); !errors.Is(err, book_errors.ErrInvalidAmount) {
+		t.Errorf("expected %v, got %v", book_errors.ErrInvalidAmount, err)
+	}
+	payment, err := service.PayFine(ctx, 1, 100, "cash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Amount != -100 || payment.Kind != models.LedgerPayment {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Errorf("expected renting to be allowed under the threshold, got %v", err)
+	}
+
+	if _, err := service.WaiveFine(ctx, 1, 0, "first time"); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 0 {
+		t.Errorf("expected the waiver to clear the balance, got %d", got)
+	}
+	if _, err := service.WaiveFine(ctx, 1, 0, ""); !errors.Is(err, book_errors.ErrInvalidAmount) {
+		t.Errorf("expected %v, got %v", book_errors.ErrInvalidAmount, err)
+	}
+
+	ledger, metadata, err := service.ListLedger(ctx, 1, filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 3 || len(ledger) != 3 {
+		t.Fatalf("expected a fine, a payment and a waiver, got %+v", ledger)
+	}
+	fine := ledger[0]
+	if fine.Kind != models.LedgerFine || fine.Amount != 150 || fine.RentalID == nil || !fine.UpdatedAt.Equal(now) {
+		t.Errorf("unexpected fine %+v", fine)
+	}
+	if ledger[2].Kind != models.LedgerWaiver || ledger[2].Amount != -50 || ledger[2].Note != "first time" {
+		t.Errorf("unexpected waiver %+v", ledger[2])
+	}
+}
+
+func TestHolds(t *testing.T) {
+	forEachBackend(t, holds)
+}
+
+func holds(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	service.policy.PickupWindow = 48 * time.Hour
+
+	if _, err := service.PlaceHold(ctx, book.ID, 2); !errors.Is(err, book_errors.ErrHoldInvalid) {
+		t.Errorf("expected available books not to be held, got %v", err)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PlaceHold(ctx, book.ID, 1); !errors.Is(err, book_errors.ErrHoldInvalid) {
+		t.Errorf("expected the reader not to hold their own book, got %v", err)
+	}
+
+	dana, err := service.PlaceHold(ctx, book.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	erin, err := service.PlaceHold(ctx, book.ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dana.Position != 1 || erin.Position != 2 || erin.Status != models.HoldWaiting {
+		t.Errorf("unexpected queue %+v, %+v", dana, erin)
+	}
+	if _, err := service.PlaceHold(ctx, book.ID, 2); !errors.Is(err, book_errors.ErrHoldInvalid) {
+		t.Errorf("expected a second hold to be refused, got %v", err)
+	}
+
+	// the book is kept for dana, nobody else can take it
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 3); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+	}
+	queue, metadata, err := service.ListBookHolds(ctx, book.ID, filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 2 || queue[0].Status != models.HoldReady || queue[0].User.Name != "dana" || queue[1].Position != 2 {
+		t.Fatalf("unexpected queue %+v", queue)
+	}
+	if !queue[0].ExpiresAt.Equal(now.Add(48 * time.Hour)) {
+		t.Errorf("expected the hold to expire after the pickup window, got %v", queue[0].ExpiresAt)
+	}
+
+	// dana doesn't come, the book passes to erin
+	now = now.Add(49 * time.Hour)
+	if expired, err := service.ExpireHolds(ctx); err != nil || expired != 1 {
+		t.Fatalf("expected one hold to expire, got %d, %v", expired, err)
+	}
+	if err := service.RentBook(ctx, book.ID, 2); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+	}
+
+	newestFirst := filter.Filters{Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: []string{"-created_at"}}
+	held, _, err := service.ListUserHolds(ctx, 3, models.HoldReady, newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 1 || held[0].Position != 1 || held[0].Book.Title != book.Title {
+		t.Fatalf("expected erin's hold to be ready, got %+v", held)
+	}
+
+	if err := service.RentBook(ctx, book.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	fulfilled, _, err := service.ListUserHolds(ctx, 3, "", newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fulfilled) != 1 || fulfilled[0].Status != models.HoldFulfilled || fulfilled[0].Position != 0 {
+		t.Errorf("expected the hold to be fulfilled, got %+v", fulfilled)
+	}
+
+	// cancelling the only hold makes the returned book available again
+	carl, err := service.PlaceHold(ctx, book.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.CancelHold(ctx, 2, carl.ID); !errors.Is(err, book_errors.ErrHoldNotFound) {
+		t.Errorf("expected other patrons' holds to be left alone, got %v", err)
+	}
+	if err := service.ReturnBook(ctx, book.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.CancelHold(ctx, 1, carl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.CancelHold(ctx, 1, carl.ID); !errors.Is(err, book_errors.ErrHoldNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrHoldNotFound, err)
+	}
+	if err := service.RentBook(ctx, book.ID, 2); err != nil {
+		t.Errorf("expected the book to be available again, got %v", err)
+	}
+}
+
+func TestCopies(t *testing.T) {
+	forEachBackend(t, copies)
+}
+
+func copies(t *testing.T, service *BookService, book *models.Book) {
+	ctx := context.Background()
+	byID := filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+	str := func(s string) *string { return &s }
+
+	second := &models.Copy{BookID: book.ID, Barcode: "BK2", Location: "shelf 4"}
+	if err := service.AddCopy(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AddCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK1"}); !errors.Is(err, book_errors.ErrDuplicateBarcode) {
+		t.Errorf("expected %v, got %v", book_errors.ErrDuplicateBarcode, err)
+	}
+	if err := service.AddCopy(ctx, &models.Copy{BookID: 42, Barcode: "BK9"}); !errors.Is(err, book_errors.ErrBookNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrBookNotFound, err)
+	}
+
+	// two patrons borrow a copy each, one copy per patron
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected a second copy to be refused, got %v", err)
+	}
+	if err := service.RentBook(ctx, book.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 3); !errors.Is(err, book_errors.ErrRentInvalid) {
+		t.Errorf("expected %v, got %v", book_errors.ErrRentInvalid, err)
+	}
+	books, _, err := service.ListBooks(ctx, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books[0].Available || books[0].CopiesAvailable != 0 || books[0].CopiesTotal != 2 {
+		t.Errorf("expected both copies to be out, got %+v", books[0])
+	}
+
+	rentals, _, err := service.ListBookRentals(ctx, book.ID, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rentals) != 2 || rentals[0].Copy.Barcode != "BK1" || rentals[1].Copy.ID != second.ID {
+		t.Fatalf("expected a rental of each copy, got %+v, %+v", rentals[0].Copy, rentals[1].Copy)
+	}
+	lent := rentals[0].Copy.ID
+
+	if _, err := service.UpdateCopy(ctx, lent, CopyUpdate{Status: str(models.CopyDamaged)}); !errors.Is(err, book_errors.ErrCopyInvalid) {
+		t.Errorf("expected a lent copy to keep its status, got %v", err)
+	}
+	updated, err := service.UpdateCopy(ctx, lent, CopyUpdate{Condition: str("torn cover")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Condition != "torn cover" || updated.Status != models.CopyOnLoan {
+		t.Errorf("unexpected copy %+v", updated)
+	}
+
+	// a new copy goes to the patron waiting for the book
+	hold, err := service.PlaceHold(ctx, book.ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third := &models.Copy{BookID: book.ID, Barcode: "BK3"}
+	if err := service.AddCopy(ctx, third); err != nil {
+		t.Fatal(err)
+	}
+	if third.Status != models.CopyOnHold {
+		t.Errorf("expected the new copy to be kept for the hold, got %+v", third)
+	}
+	if _, err := service.UpdateCopy(ctx, third.ID, CopyUpdate{Status: str(models.CopyInRepair)}); !errors.Is(err, book_errors.ErrCopyInvalid) {
+		t.Errorf("expected a kept copy to keep its status, got %v", err)
+	}
+	held, _, err := service.ListUserHolds(ctx, 3, models.HoldReady, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 1 || held[0].ID != hold.ID || held[0].Copy == nil || held[0].Copy.ID != third.ID {
+		t.Fatalf("expected the hold to be ready with the new copy, got %+v", held)
+	}
+	if err := service.RentBook(ctx, book.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lost copy stops counting, finding it puts it back on the shelf
+	if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateCopy(ctx, lent, CopyUpdate{Status: str(models.CopyOnLoan)}); !errors.Is(err, book_errors.ErrCopyInvalid) {
+		t.Errorf("expected circulation statuses not to be set by hand, got %v", err)
+	}
+	if _, err := service.UpdateCopy(ctx, lent, CopyUpdate{Status: str(models.CopyLost)}); err != nil {
+		t.Fatal(err)
+	}
+	books, _, err = service.ListBooks(ctx, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books[0].Available || books[0].CopiesTotal != 2 {
+		t.Errorf("expected the lost copy not to count, got %+v", books[0])
+	}
+	if _, err := service.UpdateCopy(ctx, lent, CopyUpdate{Status: str(models.CopyAvailable)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateCopy(ctx, 42, CopyUpdate{}); !errors.Is(err, book_errors.ErrCopyNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrCopyNotFound, err)
+	}
+
+	list, err := service.ListCopies(ctx, book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Status != models.CopyAvailable || list[1].Status != models.CopyOnLoan || list[2].Status != models.CopyOnLoan {
+		t.Errorf("unexpected copies %+v, %+v, %+v", list[0], list[1], list[2])
+	}
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Errorf("expected the found copy to be rentable, got %v", err)
+	}
+}
+
+func TestValidateBook(t *testing.T) {
+	book := &models.Book{
+		Title:    "The Cyberiad",
+		Author:   &models.Author{ID: 1},
+		ISBN:     "0-15-602759-3",
+		Language: " EN ",
+		Genres:   []string{"Science Fiction", "satire", "science fiction "},
+	}
+	v := validator.New()
+	if ValidateBook(v, book); !v.Valid() {
+		t.Fatalf("expected a valid book, got %v", v.Err())
+	}
+	if book.ISBN != "9780156027595" || book.Language != "en" || !reflect.DeepEqual(book.Genres, []string{"science fiction", "satire"}) {
+		t.Errorf("expected the book to be normalized, got %+v", book)
+	}
+
+	invalid := &models.Book{ISBN: "0-15-602759-4", Language: "english", Pages: -1, Genres: []string{""}}
+	v = validator.New()
+	ValidateBook(v, invalid)
+	for _, key := range []string{"title", "contributors", "isbn", "language", "pages", "genres"} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("expected an error for %s, got %v", key, v.Err())
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	forEachBackend(t, catalog)
+}
+
+func catalog(t *testing.T, service *BookService, solaris *models.Book) {
+	ctx := context.Background()
+	byID := filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	book := &models.Book{
+		Title:       "The Cyberiad",
+		Year:        1965,
+		Author:      &models.Author{ID: solaris.Author.ID},
+		ISBN:        "978-0-15-602759-5",
+		Publisher:   "Harcourt",
+		Edition:     "first",
+		Language:    "en",
+		Pages:       295,
+		Description: "Fables for the cybernetic age.",
+		Genres:      []string{"science fiction", "satire"},
+	}
+	v := validator.New()
+	if ValidateBook(v, book); !v.Valid() {
+		t.Fatal(v.Err())
+	}
+	if err := service.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.ISBN != "9780156027595" || book.Pages != 295 || !reflect.DeepEqual(book.Genres, []string{"satire", "science fiction"}) {
+		t.Errorf("unexpected book %+v", book)
+	}
+
+	// the same isbn written as ISBN-10
+	twin := &models.Book{Title: "The Cyberiad", Year: 1974, Author: &models.Author{ID: solaris.Author.ID}, ISBN: "0156027593"}
+	if ValidateBook(validator.New(), twin); twin.ISBN != book.ISBN {
+		t.Fatalf("expected the isbn to be normalized, got %q", twin.ISBN)
+	}
+	if err := service.CreateBook(ctx, twin); !errors.Is(err, book_errors.ErrDuplicateISBN) {
+		t.Errorf("expected %v, got %v", book_errors.ErrDuplicateISBN, err)
+	}
+
+	tests := []struct {
+		fields map[string]any
+		want   []int64
+	}{
+		{nil, []int64{solaris.ID, book.ID}},
+		{map[string]any{"genre": "satire"}, []int64{book.ID}},
+		{map[string]any{"publisher": "HARCOURT"}, []int64{book.ID}},
+		{map[string]any{"language": "en", "isbn": book.ISBN}, []int64{book.ID}},
+		{map[string]any{"language": "pl"}, nil},
+		{map[string]any{"author_id": solaris.Author.ID, "year_min": int64(1962)}, []int64{book.ID}},
+		{map[string]any{"year_min": int64(1961), "year_max": int64(1961)}, []int64{solaris.ID}},
+		{map[string]any{"available": true}, []int64{solaris.ID}},
+		{map[string]any{"author_id": int64(999)}, nil},
+	}
+	for _, tt := range tests {
+		filters := byID
+		filters.Fields = tt.fields
+		books, metadata, err := service.ListBooks(ctx, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, listed := range books {
+			ids = append(ids, listed.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) || metadata.TotalRecords != len(tt.want) {
+			t.Errorf("%v: expected books %v, got %v (%+v)", tt.fields, tt.want, ids, metadata)
+		}
+		if len(books) > 0 && books[len(books)-1].ID == book.ID && books[len(books)-1].Description != book.Description {
+			t.Errorf("expected catalog details, got %+v", books[len(books)-1])
+		}
+	}
+
+	byName := byID
+	byName.Fields = map[string]any{"name": "STANIS"}
+	authors, _, err := service.ListAuthors(ctx, byName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 1 || authors[0].ID != solaris.Author.ID {
+		t.Errorf("expected the author found by name, got %+v", authors)
+	}
+
+	byEmail := byID
+	byEmail.Fields = map[string]any{"email": "DANA@example.com", "name": "a"}
+	users, _, err := service.ListUsers(ctx, byEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "dana" {
+		t.Errorf("expected the user found by email, got %+v", users)
+	}
+
+	byID.Fields = map[string]any{"title": "Solaris"}
+	if _, _, err := service.ListBooks(ctx, byID); err == nil {
+		t.Error("expected an unknown filter to fail")
+	}
+}
+
+func TestContributors(t *testing.T) {
+	forEachBackend(t, contributors)
+}
+
+func contributors(t *testing.T, service *BookService, solaris *models.Book) {
+	ctx := context.Background()
+	byID := filter.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	translator := &models.Author{Name: "Joanna Kilmartin"}
+	if err := service.CreateAuthor(ctx, translator); err != nil {
+		t.Fatal(err)
+	}
+	lem, kilmartin := &models.Author{ID: solaris.Author.ID}, &models.Author{ID: translator.ID}
+	book := &models.Book{
+		Title: "Solaris (English translation)",
+		Year:  1970,
+		Contributors: []models.Contributor{
+			{Author: lem},
+			{Author: kilmartin, Role: models.RoleTranslator},
+			{Author: lem, Role: models.RoleIllustrator},
+		},
+	}
+	v := validator.New()
+	if ValidateBook(v, book); !v.Valid() {
+		t.Fatal(v.Err())
+	}
+	if err := service.CreateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Author.ID != lem.ID || len(book.Contributors) != 3 {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	for i, want := range []struct {
+		authorID int64
+		role     string
+	}{{lem.ID, models.RoleAuthor}, {kilmartin.ID, models.RoleTranslator}, {lem.ID, models.RoleIllustrator}} {
+		got := book.Contributors[i]
+		if got.Author.ID != want.authorID || got.Role != want.role || got.Position != i+1 || got.Author.Name == "" {
+			t.Errorf("contributor %d: expected author %d as %s, got %+v", i, want.authorID, want.role, got)
+		}
+	}
+
+	twice := &models.Book{Title: "Twice", Contributors: []models.Contributor{{Author: lem}, {Author: lem}}}
+	v = validator.New()
+	if ValidateBook(v, twice); v.Valid() {
+		t.Error("expected an author credited twice in one role to be invalid")
+	}
+	unknown := &models.Book{Title: "Unknown", Contributors: []models.Contributor{{Author: lem}, {Author: &models.Author{ID: 999}, Role: models.RoleEditor}}}
+	if ValidateBook(validator.New(), unknown); unknown.Contributors[1].Position != 2 {
+		t.Fatalf("expected the contributors to be numbered, got %+v", unknown.Contributors)
+	}
+	if err := service.CreateBook(ctx, unknown); !errors.Is(err, book_errors.ErrAuthorNotFound) {
+		t.Errorf("expected %v, got %v", book_errors.ErrAuthorNotFound, err)
+	}
+
+	if err := service.AddCopy(ctx, &models.Copy{BookID: book.ID, Barcode: "BK2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RentBook(ctx, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	authors, _, err := service.ListAuthors(ctx, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lem wrote and illustrated the book but is counted once
+	for i, want := range []struct {
+		books         []int64
+		times_ordered int
+	}{{[]int64{solaris.ID, book.ID}, 1}, {[]int64{book.ID}, 1}} {
+		var ids []int64
+		for _, listed := range authors[i].Books {
+			ids = append(ids, listed.ID)
+		}
+		if !reflect.DeepEqual(ids, want.books) || authors[i].Times_ordered != want.times_ordered {
+			t.Errorf("author %d: expected books %v ordered %d times, got %v ordered %d times",
+				authors[i].ID, want.books, want.times_ordered, ids, authors[i].Times_ordered)
+		}
+	}
+
+	books, _, err := service.ListBooks(ctx, byID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books[0].Contributors) != 1 || !reflect.DeepEqual(books[1].Contributors, book.Contributors) {
+		t.Errorf("unexpected contributors %+v and %+v", books[0].Contributors, books[1].Contributors)
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	forEachBackend(t, search)
+}
+
+func search(t *testing.T, service *BookService, solaris *models.Book) {
+	ctx := context.Background()
+	byRelevance := filter.Filters{Page: 1, PageSize: 20, Sort: "-relevance", SortSafelist: []string{"-relevance"}}
+
+	lem := &models.Author{ID: solaris.Author.ID}
+	var created []int64
+	for _, book := range []*models.Book{
+		{Title: "The Cyberiad", Author: lem, Description: "Fables for the cybernetic age."},
+		{Title: "Summa Technologiae", Author: lem, Description: "Essays, the ocean of Solaris included."},
+	} {
+		v := validator.New()
+		if ValidateBook(v, book); !v.Valid() {
+			t.Fatal(v.Err())
+		}
+		if err := service.CreateBook(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, book.ID)
+	}
+	cyberiad, summa := created[0], created[1]
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		// the title outranks the description
+		{"solaris", []int64{solaris.ID, summa}},
+		{"LEM", []int64{solaris.ID, cyberiad, summa}},
+		{"lem cybernetic", []int64{cyberiad}},
+		{"cybernetic ocean", nil},
+		{"100%", nil},
+	}
+	for _, tt := range tests {
+		query := tt.query
+		v := validator.New()
+		if ValidateSearch(v, &query); !v.Valid() {
+			t.Fatal(v.Err())
+		}
+		books, metadata, err := service.SearchBooks(ctx, query, byRelevance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, book := range books {
+			ids = append(ids, book.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) || metadata.TotalRecords != len(tt.want) {
+			t.Errorf("%q: expected books %v, got %v (%+v)", tt.query, tt.want, ids, metadata)
+		}
+	}
+
+	paged := byRelevance
+	paged.PageSize, paged.Page = 1, 2
+	books, metadata, err := service.SearchBooks(ctx, "lem", paged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].ID != cyberiad || metadata.LastPage != 3 || len(books[0].Contributors) != 1 {
+		t.Errorf("unexpected page %+v (%+v)", books, metadata)
+	}
+
+	query := "   "
+	v := validator.New()
+	if ValidateSearch(v, &query); v.Valid() {
+		t.Error("expected an empty query to be invalid")
+	}
+}
+
+func TestKeysetPaging(t *testing.T) {
+	forEachBackend(t, keysetPaging)
+}
+
+func keysetPaging(t *testing.T, service *BookService, solaris *models.Book) {
+	ctx := context.Background()
+	created := []*models.Book{}
+	for i, year := range []int{1965, 1961, 1968, 1961, 1965} {
+		book := &models.Book{Title: fmt.Sprintf("Book %d", i), Year: year, Author: &models.Author{ID: solaris.Author.ID}}
+		if err := service.CreateBook(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, book)
+	}
+
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	rent := func(book *models.Book, times int) {
+		t.Helper()
+		if book != solaris {
+			if err := service.AddCopy(ctx, &models.Copy{BookID: book.ID, Barcode: fmt.Sprintf("KS%d", book.ID)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < times; i++ {
+			if err := service.RentBook(ctx, book.ID, 1); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(time.Hour)
+			if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	rent(solaris, 5)
+	// ties on the leaderboard
+	rent(created[0], 2)
+	rent(created[3], 2)
+	rent(created[1], 1)
+
+	bookID := func(b *models.Book) int64 { return b.ID }
+	for _, sort := range []string{"id", "-id", "-year", "title", "-year,title", "available,-year,id", "author,-id"} {
+		t.Run("books "+sort, func(t *testing.T) {
+			f := filter.Filters{Page: 1, PageSize: 2, Sort: sort, SortSafelist: []string{sort}}
+			walkPages(t, func(f filter.Filters) ([]*models.Book, filter.Metadata, error) {
+				return service.ListBooks(ctx, f)
+			}, f, bookID)
+		})
+	}
+
+	t.Run("cursor of another sort", func(t *testing.T) {
+		f := filter.Filters{Page: 1, PageSize: 2, Sort: "-year,title", SortSafelist: []string{"-year,title"}, Keyset: true}
+		f.Cursor = &filter.Cursor{Sort: "-year,title", Keys: []any{int64(1965)}, ID: solaris.ID}
+		if _, _, err := service.ListBooks(ctx, f); !errors.Is(err, filter.ErrCursorMismatch) {
+			t.Errorf("expected %v, got %v", filter.ErrCursorMismatch, err)
+		}
+	})
+
+	byYear := filter.Filters{Page: 1, PageSize: 20, Sort: "-year,-title", SortSafelist: filter.Sortable("year", "title")}
+	books, _, err := service.ListBooks(ctx, byYear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(books); i++ {
+		a, b := books[i-1], books[i]
+		if a.Year < b.Year || a.Year == b.Year && a.Title < b.Title {
+			t.Errorf("expected %q (%d) after %q (%d)", a.Title, a.Year, b.Title, b.Year)
+		}
+	}
+
+	t.Run("rentals -rented_at", func(t *testing.T) {
+		f := filter.Filters{Page: 1, PageSize: 2, Sort: "-rented_at", SortSafelist: []string{"-rented_at"}}
+		walkPages(t, func(f filter.Filters) ([]*models.Rental, filter.Metadata, error) {
+			return service.ListBookRentals(ctx, solaris.ID, f)
+		}, f, func(r *models.Rental) int64 { return r.ID })
+	})
+
+	t.Run("search -relevance", func(t *testing.T) {
+		f := filter.Filters{Page: 1, PageSize: 2, Sort: "-relevance", SortSafelist: []string{"-relevance"}}
+		walkPages(t, func(f filter.Filters) ([]*models.Book, filter.Metadata, error) {
+			return service.SearchBooks(ctx, "book", f)
+		}, f, bookID)
+	})
+
+	byRentals := filter.Filters{Page: 1, PageSize: 1, Sort: "-rentals", SortSafelist: []string{"-rentals"}}
+	t.Run("popular books -rentals", func(t *testing.T) {
+		walkPages(t, func(f filter.Filters) ([]*models.BookRank, filter.Metadata, error) {
+			return service.RankBooks(ctx, models.WindowAll, f)
+		}, byRentals, func(r *models.BookRank) int64 { return r.Book.ID })
+	})
+	t.Run("top authors -rentals", func(t *testing.T) {
+		walkPages(t, func(f filter.Filters) ([]*models.AuthorRank, filter.Metadata, error) {
+			return service.RankAuthors(ctx, models.WindowAll, f)
+		}, byRentals, func(r *models.AuthorRank) int64 { return r.Author.ID })
+	})
+
+	// a page read by cursor keeps the ranks of the whole leaderboard
+	t.Run("ranks by cursor", func(t *testing.T) {
+		f := byRentals
+		f.PageSize = 2
+		f.Keyset = true
+		_, metadata, err := service.RankBooks(ctx, models.WindowAll, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Cursor, err = filter.ParseCursor(metadata.Next); err != nil {
+			t.Fatal(err)
+		}
+		ranks, _, err := service.RankBooks(ctx, models.WindowAll, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranks) != 2 || ranks[0].Rank != 2 || ranks[1].Rank != 4 || ranks[1].Book.ID != created[1].ID {
+			t.Fatalf("expected ranks 2 and 4 on the second page, got %+v %+v", ranks[0], ranks[1])
+		}
+	})
+}
+
+// walkPages pages through a listing by cursor, forward to the end and back
+// to the start, and checks both ways see the rows of the offset listing.
+func walkPages[T any](t *testing.T, list func(filter.Filters) ([]T, filter.Metadata, error), f filter.Filters, id func(T) int64) {
+	t.Helper()
+	ids := func(rows []T) []int64 {
+		out := []int64{}
+		for _, row := range rows {
+			out = append(out, id(row))
+		}
+		return out
+	}
+
+	all := f
+	all.PageSize = 100
+	rows, _, err := list(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ids(rows)
+
+	f.Keyset = true
+	forward, prev := []int64{}, ""
+	for {
+		rows, metadata, err := list(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.TotalRecords != 0 {
+			t.Errorf("expected no count by cursor, got %+v", metadata)
+		}
+		forward = append(forward, ids(rows)...)
+		if metadata.Next == "" {
+			prev = metadata.Prev
+			break
+		}
+		if f.Cursor, err = filter.ParseCursor(metadata.Next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(forward, want) {
+		t.Fatalf("forward %v, want %v", forward, want)
+	}
+
+	// the last page is short, so going back the pages hold other rows than
+	// going forward; together they still are the listing
+	backward := forward[len(forward)-len(forward)%f.PageSize:]
+	if len(backward) == 0 {
+		backward = forward[len(forward)-f.PageSize:]
+	}
+	for prev != "" {
+		if f.Cursor, err = filter.ParseCursor(prev); err != nil {
+			t.Fatal(err)
+		}
+		rows, metadata, err := list(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backward = append(ids(rows), backward...)
+		prev = metadata.Prev
+	}
+	if !reflect.DeepEqual(backward, want) {
+		t.Errorf("backward %v, want %v", backward, want)
+	}
+}
+
+func TestPopularity(t *testing.T) {
+	forEachBackend(t, popularity)
+}
+
+func popularity(t *testing.T, service *BookService, solaris *models.Book) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	translator := &models.Author{Name: "Joanna Kilmartin"}
+	if err := service.CreateAuthor(ctx, translator); err != nil {
+		t.Fatal(err)
+	}
+	cyberiad := &models.Book{Title: "The Cyberiad", Year: 1965, Contributors: []models.Contributor{
+		{Author: &models.Author{ID: solaris.Author.ID}},
+		{Author: &models.Author{ID: translator.ID}, Role: models.RoleTranslator},
+	}}
+	ValidateBook(validator.New(), cyberiad)
+	if err := service.CreateBook(ctx, cyberiad); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AddCopy(ctx, &models.Copy{BookID: cyberiad.ID, Barcode: "BK2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a year ago Solaris went out twice, this week The Cyberiad three times
+	rent := func(book *models.Book, times int) {
+		t.Helper()
+		for i := 0; i < times; i++ {
+			if err := service.RentBook(ctx, book.ID, 1); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(time.Hour)
+			if err := service.ReturnBook(ctx, book.ID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	rent(solaris, 2)
+	now = now.AddDate(1, 0, 0)
+	rent(cyberiad, 3)
+
+	f := filter.Filters{Page: 1, PageSize: 20, Sort: "-rentals", SortSafelist: []string{"-rentals"}}
+
+	books, metadata, err := service.RankBooks(ctx, models.WindowWeek, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || metadata.TotalRecords != 1 || books[0].Book.ID != cyberiad.ID || books[0].Rentals != 3 || books[0].Rank != 1 {
+		t.Fatalf("expected only The Cyberiad this week, got %+v", books)
+	}
+	if len(books[0].Book.Contributors) != 2 {
+		t.Errorf("expected the ranked book with its contributors, got %+v", books[0].Book)
+	}
+
+	books, _, err = service.RankBooks(ctx, models.WindowAll, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 || books[0].Book.ID != cyberiad.ID || books[1].Book.ID != solaris.ID || books[1].Rentals != 2 || books[1].Rank != 2 {
+		t.Fatalf("unexpected all time books %+v", books)
+	}
+
+	// Lem is credited on both books, the translator on one; their ranks
+	// tie this week
+	authors, _, err := service.RankAuthors(ctx, models.WindowWeek, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 2 || authors[0].Author.ID != solaris.Author.ID || authors[0].Rank != 1 || authors[1].Rank != 1 || authors[1].Rentals != 3 {
+		t.Fatalf("expected a tie this week, got %+v %+v", authors[0], authors[1])
+	}
+
+	authors, metadata, err = service.RankAuthors(ctx, models.WindowAll, filter.Filters{Page: 2, PageSize: 1, Sort: "-rentals", SortSafelist: []string{"-rentals"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 1 || metadata.TotalRecords != 2 || authors[0].Author.ID != translator.ID || authors[0].Rank != 2 {
+		t.Fatalf("expected the translator second of all time, got %+v %+v", authors, metadata)
+	}
+
+	named := f
+	named.Fields = map[string]any{"name": "kilmartin"}
+	authors, _, err = service.RankAuthors(ctx, models.WindowAll, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 1 || authors[0].Rank != 1 {
+		t.Errorf("expected ranks within the filtered authors, got %+v", authors)
+	}
+
+	if _, _, err := service.RankBooks(ctx, "forever", f); err == nil {
+		t.Error("expected an unknown window to fail")
+	}
+}
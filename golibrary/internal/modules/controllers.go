@@ -13,7 +13,7 @@ type Controllers struct {
 
 func NewControllers(services *Services, components *components.Components) *Controllers {
 	return &Controllers{
-		UserHandler: user_controller.NewUserHandler(components.Responder, services.UserService),
+		UserHandler: user_controller.NewUserHandler(components.Responder, services.UserService, components.Tokens),
 		BookHandler: book_controller.NewBookController(components.Responder, services.BookService),
 	}
 }
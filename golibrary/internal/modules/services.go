@@ -11,9 +11,12 @@ type Services struct {
 	BookService book_service.IBookService
 }
 
-func NewServices(cmp *components.Components, storages *Storages) *Services {
+func NewServices(cmp *components.Components, storages *Storages, policy book_service.Policy, userPolicy user_service.Policy) *Services {
+	userService := user_service.NewUserService(storages.UserStorage, userPolicy, cmp.Mailer)
+	userService.SetLogger(cmp.Logger)
+
 	return &Services{
-		UserService: user_service.NewUserService(storages.UserStorage),
-		BookService: book_service.NewBookService(storages.BookStorage),
+		UserService: userService,
+		BookService: book_service.NewBookService(storages.BookStorage, policy),
 	}
 }
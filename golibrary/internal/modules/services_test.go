@@ -3,7 +3,10 @@ package modules
 import (
 	"test/internal/infrastructure/components"
 	"test/internal/infrastructure/responder"
+	book_service "test/internal/modules/books/service"
+	user_service "test/internal/modules/user/service"
 	"testing"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/ptflp/godecoder"
@@ -18,9 +21,9 @@ func TestNewServices(t *testing.T) {
 	decoder := godecoder.NewDecoder(jsoniter.Config{})
 	responseManager := responder.NewResponder(decoder, logger)
 
-	components := components.NewComponents(responseManager, decoder, logger, nil)
-	storages := NewStorages(nil, nil)
-	services := NewServices(components, storages)
+	components := components.NewComponents(responseManager, decoder, logger, nil, nil, nil)
+	storages := NewStorages(nil, nil, 0)
+	services := NewServices(components, storages, book_service.Policy{}, user_service.Policy{SessionTTL: time.Hour})
 	if services == nil {
 		t.Fatal("services is nil")
 	}
@@ -1,6 +1,9 @@
 package modules
 
 import (
+	"time"
+
+	"test/internal/db/memory"
 	book_storage "test/internal/modules/books/repository"
 	user_storage "test/internal/modules/user/repository"
 
@@ -13,9 +16,19 @@ type Storages struct {
 	BookStorage book_storage.IBookStorage
 }
 
-func NewStorages(sql *sqlx.DB, logger *zap.Logger) *Storages {
+func NewStorages(sql *sqlx.DB, logger *zap.Logger, queryTimeout time.Duration) *Storages {
+	return &Storages{
+		UserStorage: user_storage.NewUserModel(sql, queryTimeout),
+		BookStorage: book_storage.NewBookStorage(sql, logger, queryTimeout),
+	}
+}
+
+// NewMemoryStorages builds storages backed by a shared in-memory store
+// instead of a database.
+func NewMemoryStorages() *Storages {
+	store := memory.New()
 	return &Storages{
-		UserStorage: user_storage.NewUserModel(sql),
-		BookStorage: book_storage.NewBookStorage(sql, logger),
+		UserStorage: memory.NewUserStorage(store),
+		BookStorage: memory.NewBookStorage(store),
 	}
 }
@@ -5,8 +5,15 @@ import (
 )
 
 func TestNewStorages(t *testing.T) {
-	storages := NewStorages(nil, nil)
+	storages := NewStorages(nil, nil, 0)
 	if storages == nil {
 		t.Fatal("storages is nil")
 	}
 }
+
+func TestNewMemoryStorages(t *testing.T) {
+	storages := NewMemoryStorages()
+	if storages == nil || storages.UserStorage == nil || storages.BookStorage == nil {
+		t.Fatal("storages is nil")
+	}
+}
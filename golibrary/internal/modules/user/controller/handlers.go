@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"test/internal/infrastructure/auth"
 	"test/internal/infrastructure/filters"
 	"test/internal/infrastructure/helpers"
 	"test/internal/infrastructure/responder"
@@ -28,7 +31,13 @@ import (
 
 type IUserHandler interface {
 	Login(w http.ResponseWriter, r *http.Request)
+	Refresh(w http.ResponseWriter, r *http.Request)
 	Logout(w http.ResponseWriter, r *http.Request)
+	RevokeSessions(w http.ResponseWriter, r *http.Request)
+	ListLockouts(w http.ResponseWriter, r *http.Request)
+	Unlock(w http.ResponseWriter, r *http.Request)
+	RequestPasswordReset(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
 	GetUserByName(w http.ResponseWriter, r *http.Request)
 	GetUserById(w http.ResponseWriter, r *http.Request)
 	ListUsers(w http.ResponseWriter, r *http.Request)
@@ -36,83 +45,300 @@ type IUserHandler interface {
 	CreateWithList(w http.ResponseWriter, r *http.Request)
 	CreateWithArray(w http.ResponseWriter, r *http.Request)
 	UpdateUser(w http.ResponseWriter, r *http.Request)
+	SetRole(w http.ResponseWriter, r *http.Request)
 	DeleteUser(w http.ResponseWriter, r *http.Request)
 }
 
 type UserHandler struct {
 	responder responder.Responder
 	service   service.IUserService
+	tokens    *auth.Tokens
 }
 
-func NewUserHandler(responder responder.Responder, service service.IUserService) *UserHandler {
+func NewUserHandler(responder responder.Responder, service service.IUserService, tokens *auth.Tokens) *UserHandler {
 	return &UserHandler{
 		responder: responder,
 		service:   service,
+		tokens:    tokens,
 	}
 }
 
+// Login checks the credentials in the JSON body and starts a session. Wrong
+// names and wrong passwords get the same 401, accounts and addresses with too
+// many failures 429 until their lockout ends.
 func (uc *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-
-	err := r.ParseForm()
+	var input struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		uc.responder.ErrorBadRequest(w, errors.New("failed to parse body"))
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
 		return
 	}
-	userName := r.Form.Get("username")
-	userPassword := r.Form.Get("password")
-	fmt.Println("data", userName, userPassword)
 
-	if userName == "" || userPassword == "" {
-		http.Error(w, "Missing username or password.", http.StatusBadRequest)
+	v := validator.New()
+	v.Check(input.Username != "", "username", "must be provided")
+	v.Check(input.Password != "", "password", "must be provided")
+	if !v.Valid() {
+		uc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
-	user, err := uc.service.GetUserByName(userName)
+
+	user, err := uc.service.Login(r.Context(), input.Username, input.Password, clientAddress(r))
 	if err != nil {
+		var locked *service.LockoutError
 		switch {
-		case errors.Is(err, service.ErrRecordNotFound):
-			uc.responder.ErrorInternal(w, errors.New("User not found"))
+		case errors.Is(err, service.ErrInvalidCredentials):
+			uc.responder.ErrorUnauthorized(w, err)
+		case errors.As(err, &locked):
+			uc.responder.ErrorTooManyRequests(w, err, time.Until(locked.Until))
 		default:
 			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
 		}
 		return
 	}
 
-	ok, err := user.Password.Matches(userPassword)
-	if !ok && err != nil {
-		uc.responder.ErrorBadRequest(w, err)
+	session, refresh, err := uc.service.StartSession(r.Context(), user.ID)
+	if err != nil {
+		uc.responder.ErrorInternal(w, errors.New("Internal server error"))
 		return
-	} else if !ok && err == nil {
-		uc.responder.ErrorBadRequest(w, errors.New("wrong password"))
+	}
+	uc.sendSession(w, user, session, refresh)
+}
+
+// clientAddress is the address logins are throttled by, the peer of the
+// connection.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// refreshCookie keeps the refresh token of browsers, it is only sent to the
+// refresh endpoint.
+const (
+	refreshCookie = "refresh_token"
+	refreshPath   = "/user/refresh"
+)
+
+// tokenResponse is what a client keeps of a session.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// sendSession issues an access token for the session and answers with it
+// and the refresh token, in the body and in cookies for browsers.
+func (uc *UserHandler) sendSession(w http.ResponseWriter, user *models.User, session *models.Session, refresh string) {
+	token, err := uc.tokens.Issue(user, session.ID)
+	if err != nil {
+		uc.responder.ErrorInternal(w, err)
 		return
 	}
-	token := helpers.GenerateToken(userName)
 	http.SetCookie(w, &http.Cookie{
 		HttpOnly: true,
-		Expires:  time.Now().Add(60 * time.Minute),
+		Expires:  time.Now().Add(uc.tokens.TTL()),
 		SameSite: http.SameSiteLaxMode,
 		Name:     "jwt",
 		Path:     "/",
 		Value:    token,
 	})
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, fmt.Sprint("successfully logged in"))
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		Expires:  session.ExpiresAt,
+		SameSite: http.SameSiteStrictMode,
+		Name:     refreshCookie,
+		Path:     refreshPath,
+		Value:    refresh,
+	})
+	uc.responder.OutputJSON(w, tokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(uc.tokens.TTL().Seconds()),
+		RefreshToken: refresh,
+	})
+}
+
+// Refresh trades the refresh token of a session, from the body or the
+// cookie, for a new access token and a new refresh token.
+func (uc *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+	if input.RefreshToken == "" {
+		if cookie, err := r.Cookie(refreshCookie); err == nil {
+			input.RefreshToken = cookie.Value
+		}
+	}
+	if input.RefreshToken == "" {
+		uc.responder.ErrorUnauthorized(w, service.ErrInvalidSession)
+		return
+	}
 
+	user, session, refresh, err := uc.service.RefreshSession(r.Context(), input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidSession):
+			uc.responder.ErrorUnauthorized(w, err)
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+	uc.sendSession(w, user, session, refresh)
 }
 
+// Logout ends the caller's session, its tokens stop working at once.
 func (uc *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		uc.responder.ErrorUnauthorized(w, auth.ErrUnauthenticated)
+		return
+	}
+	if err := uc.service.EndSession(r.Context(), claims.SessionID); err != nil {
+		uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		return
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		HttpOnly: true,
-		Expires:  time.Now().Add(-1 * time.Hour),
-		SameSite: http.SameSiteLaxMode,
-		Name:     "jwt",
-		Path:     "/",
-		Value:    "",
-	})
+	for _, cookie := range []struct{ name, path string }{{"jwt", "/"}, {refreshCookie, refreshPath}} {
+		http.SetCookie(w, &http.Cookie{
+			HttpOnly: true,
+			Expires:  time.Now().Add(-1 * time.Hour),
+			SameSite: http.SameSiteLaxMode,
+			Name:     cookie.name,
+			Path:     cookie.path,
+			Value:    "",
+		})
+	}
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, fmt.Sprint("successfully logged out"))
 }
 
+// RevokeSessions logs a user out everywhere, for accounts that were taken
+// over.
+func (uc *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "username")
+	if name == "" {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	user, err := uc.service.GetUserByName(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRecordNotFound):
+			uc.responder.ErrorInternal(w, errors.New("User not found"))
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+
+	revoked, err := uc.service.EndSessions(r.Context(), user.ID)
+	if err != nil {
+		uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		return
+	}
+
+	uc.responder.OutputJSON(w, map[string]int{"revoked": revoked})
+}
+
+// ListLockouts shows admins the accounts and the client addresses with
+// failed logins that still count and until when they are locked out.
+func (uc *UserHandler) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	uc.responder.OutputJSON(w, map[string]interface{}{"data": uc.service.Lockouts(r.Context())})
+}
+
+// Unlock lifts the lockout of an account before it runs out.
+func (uc *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "username")
+	if name == "" {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	uc.responder.OutputJSON(w, map[string]bool{"unlocked": uc.service.Unlock(r.Context(), name)})
+}
+
+// RequestPasswordReset mails a reset token to the owner of the email in the
+// JSON body. The answer is the same whether anyone owns it or not, too many
+// requests for the email or from the client get 429.
+func (uc *UserHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	v := validator.New()
+	service.ValidateEmail(v, input.Email)
+	if !v.Valid() {
+		uc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	if err := uc.service.RequestPasswordReset(r.Context(), input.Email, clientAddress(r)); err != nil {
+		var throttled *service.LockoutError
+		switch {
+		case errors.As(err, &throttled):
+			uc.responder.ErrorTooManyRequests(w, err, time.Until(throttled.Until))
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+
+	uc.responder.OutputJSON(w, map[string]string{"message": "if an account uses this email, a password reset token was sent to it"})
+}
+
+// ResetPassword sets the password in the JSON body with a mailed reset
+// token and logs the user out everywhere.
+func (uc *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Token != "", "token", "must be provided")
+	service.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		uc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	err = uc.service.ResetPassword(r.Context(), input.Token, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidResetToken):
+			uc.responder.ErrorBadRequest(w, err)
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+
+	uc.responder.OutputJSON(w, map[string]string{"message": "password was reset, log in with the new one"})
+}
+
 func (uc *UserHandler) GetUserByName(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "username")
 	if name == "" {
@@ -121,7 +347,7 @@ func (uc *UserHandler) GetUserByName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fmt.Println(name)
-	user, err := uc.service.GetUserByName(name)
+	user, err := uc.service.GetUserByName(r.Context(), name)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrRecordNotFound):
@@ -131,6 +357,11 @@ func (uc *UserHandler) GetUserByName(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// librarians look members up at the desk
+	if !auth.Allowed(r.Context(), user.ID, models.RoleLibrarian) {
+		uc.responder.ErrorForbidden(w, auth.ErrForbidden)
+		return
+	}
 
 	uc.responder.OutputJSON(w, user)
 }
@@ -147,7 +378,7 @@ func (uc *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := uc.service.GetUserById(int64(id64))
+	user, err := uc.service.GetUserById(r.Context(), int64(id64))
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrRecordNotFound):
@@ -192,7 +423,7 @@ func (uc *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = uc.service.CreateUser(user)
+	err = uc.service.CreateUser(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrDuplicateEmail):
@@ -246,7 +477,7 @@ func (uc *UserHandler) CreateWithList(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = uc.service.CreateUser(user)
+		err = uc.service.CreateUser(r.Context(), user)
 		if err != nil {
 			switch {
 			case errors.Is(err, service.ErrDuplicateEmail):
@@ -301,7 +532,7 @@ func (uc *UserHandler) CreateWithArray(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = uc.service.CreateUser(user)
+		err = uc.service.CreateUser(r.Context(), user)
 		if err != nil {
 			switch {
 			case errors.Is(err, service.ErrDuplicateEmail):
@@ -323,7 +554,7 @@ func (uc *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := uc.service.GetUserByName(name)
+	user, err := uc.service.GetUserByName(r.Context(), name)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrRecordNotFound):
@@ -333,9 +564,15 @@ func (uc *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !auth.Allowed(r.Context(), user.ID, models.RoleAdmin) {
+		uc.responder.ErrorForbidden(w, auth.ErrForbidden)
+		return
+	}
 	var input struct {
-		Name  *string `json:"name"`
-		Email *string `json:"email"`
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
 	}
 	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
@@ -348,6 +585,25 @@ func (uc *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if input.Email != nil {
 		user.Email = *input.Email
 	}
+	if input.Password != nil {
+		// users confirm their own change with the current password, admins
+		// set it for others; either way every session of the user ends
+		if claims, _ := auth.FromContext(r.Context()); claims.UserID == user.ID {
+			ok, err := user.Password.Matches(input.CurrentPassword)
+			if err != nil {
+				uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+				return
+			}
+			if !ok {
+				uc.responder.ErrorBadRequest(w, errors.New("current password is wrong"))
+				return
+			}
+		}
+		if err := user.Password.Set(*input.Password); err != nil {
+			uc.responder.ErrorBadRequest(w, errors.New("Invalid request body3"))
+			return
+		}
+	}
 
 	v := validator.New()
 
@@ -356,7 +612,61 @@ func (uc *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = uc.service.UpdateUser(user)
+	err = uc.service.UpdateUser(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrEditConflict):
+			uc.responder.ErrorInternal(w, errors.New("User not found"))
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+
+	uc.responder.OutputJSON(w, user)
+}
+
+// SetRole changes what a user may do. A demotion ends the user's sessions,
+// an upgrade takes effect with their next token refresh.
+func (uc *UserHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "username")
+	if name == "" {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
+		return
+	}
+
+	user, err := uc.service.GetUserByName(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRecordNotFound):
+			uc.responder.ErrorInternal(w, errors.New("User not found"))
+		default:
+			uc.responder.ErrorInternal(w, errors.New("Internal server error"))
+		}
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Role != "", "role", "must be provided")
+
+	updated := *user
+	updated.Role = input.Role
+	if service.ValidateUser(v, &updated); !v.Valid() {
+		uc.responder.ErrorBadRequest(w, v.Err())
+		return
+	}
+
+	err = uc.service.SetRole(r.Context(), user, input.Role)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrEditConflict):
@@ -376,7 +686,7 @@ func (uc *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		uc.responder.ErrorBadRequest(w, errors.New("Invalid request body"))
 		return
 	}
-	deleted, err := uc.service.GetUserByName(user)
+	deleted, err := uc.service.GetUserByName(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrRecordNotFound):
@@ -386,7 +696,11 @@ func (uc *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = uc.service.DeleteUser(deleted.ID)
+	if !auth.Allowed(r.Context(), deleted.ID, models.RoleAdmin) {
+		uc.responder.ErrorForbidden(w, auth.ErrForbidden)
+		return
+	}
+	err = uc.service.DeleteUser(r.Context(), deleted.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrRecordNotFound):
@@ -415,14 +729,14 @@ func (uc *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	input.Filters.PageSize = helpers.ReadInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = helpers.ReadString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "email", "-id", "-name", "-email"}
+	input.Filters.SortSafelist = filters.Sortable("id", "name", "email")
 
 	if filters.ValidateFilters(v, input.Filters); !v.Valid() {
-		uc.responder.ErrorInternal(w, errors.New("Internal server error1"))
+		uc.responder.ErrorBadRequest(w, v.Err())
 		return
 	}
 
-	users, metadata, err := uc.service.ListUsers(input.Filters)
+	users, metadata, err := uc.service.ListUsers(r.Context(), input.Filters)
 	if err != nil {
 		uc.responder.ErrorInternal(w, errors.New("Internal server error2"))
 		return
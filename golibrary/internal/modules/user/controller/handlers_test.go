@@ -6,11 +6,17 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"test/config"
+	"test/internal/infrastructure/auth"
 	filter "test/internal/infrastructure/filters"
+	"test/internal/infrastructure/mailer"
 	"test/internal/infrastructure/responder"
 	"test/internal/models"
+	"test/internal/modules/user/repository"
 	"test/internal/modules/user/service"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi"
 	jsoniter "github.com/json-iterator/go"
@@ -19,38 +25,147 @@ import (
 )
 
 type MockStorage struct {
-	GetByName_mock func(email string) (*models.User, error)
-	Get_mock       func(id int64) (*models.User, error)
-	GetAll_mock    func(filters filter.Filters) ([]*models.User, filter.Metadata, error)
-	Insert_mock    func(user *models.User) error
-	Update_mock    func(user *models.User) error
-	Delete_mock    func(id int64) error
+	GetByName_mock  func(email string) (*models.User, error)
+	GetByEmail_mock func(email string) (*models.User, error)
+	Get_mock        func(id int64) (*models.User, error)
+	GetAll_mock     func(filters filter.Filters) ([]*models.User, filter.Metadata, error)
+	Insert_mock     func(user *models.User) error
+	Update_mock     func(user *models.User) error
+	Delete_mock     func(id int64) error
+
+	// sessions are left out of most tests, without a mock they are kept in
+	// sessions
+	sessions map[int64]*models.Session
+	// resets are kept the same way
+	resets map[int64]*models.PasswordReset
 }
 
-func (m *MockStorage) GetByName(email string) (*models.User, error) {
+func (m *MockStorage) GetByName(ctx context.Context, email string) (*models.User, error) {
 	return m.GetByName_mock(email)
 }
 
-func (m *MockStorage) Get(id int64) (*models.User, error) {
+func (m *MockStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return m.GetByEmail_mock(email)
+}
+
+func (m *MockStorage) Get(ctx context.Context, id int64) (*models.User, error) {
 	return m.Get_mock(id)
 }
 
-func (m *MockStorage) GetAll(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+func (m *MockStorage) GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
 	return m.GetAll_mock(filters)
 }
 
-func (m *MockStorage) Insert(user *models.User) error {
+func (m *MockStorage) Insert(ctx context.Context, user *models.User) error {
 	return m.Insert_mock(user)
 }
 
-func (m *MockStorage) Update(user *models.User) error {
+func (m *MockStorage) Update(ctx context.Context, user *models.User) error {
 	return m.Update_mock(user)
 }
 
-func (m *MockStorage) Delete(id int64) error {
+func (m *MockStorage) Delete(ctx context.Context, id int64) error {
 	return m.Delete_mock(id)
 }
 
+func (m *MockStorage) InsertSession(ctx context.Context, session *models.Session) error {
+	if m.sessions == nil {
+		m.sessions = make(map[int64]*models.Session)
+	}
+	session.ID = int64(len(m.sessions) + 1)
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return nil
+}
+
+func (m *MockStorage) GetSession(ctx context.Context, id int64) (*models.Session, error) {
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+	found := *session
+	return &found, nil
+}
+
+func (m *MockStorage) GetSessionByToken(ctx context.Context, hash string) (*models.Session, error) {
+	for _, session := range m.sessions {
+		if session.TokenHash == hash || session.PreviousHash == hash {
+			found := *session
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrRecordNotFound
+}
+
+func (m *MockStorage) RotateSession(ctx context.Context, session *models.Session, hash string, expiresAt time.Time) error {
+	stored := m.sessions[session.ID]
+	stored.PreviousHash, stored.TokenHash, stored.ExpiresAt = stored.TokenHash, hash, expiresAt
+	*session = *stored
+	return nil
+}
+
+func (m *MockStorage) RevokeSession(ctx context.Context, id int64, at time.Time) error {
+	if session, ok := m.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &at
+	}
+	return nil
+}
+
+func (m *MockStorage) RevokeSessions(ctx context.Context, userID int64, at time.Time) (int, error) {
+	revoked := 0
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &at
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MockStorage) InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	if m.resets == nil {
+		m.resets = make(map[int64]*models.PasswordReset)
+	}
+	reset.ID = int64(len(m.resets) + 1)
+	stored := *reset
+	m.resets[reset.ID] = &stored
+	return nil
+}
+
+func (m *MockStorage) UsePasswordReset(ctx context.Context, hash string, passwordHash []byte, at time.Time) (*models.PasswordReset, error) {
+	for _, reset := range m.resets {
+		if reset.TokenHash == hash && reset.Active(at) {
+			user, err := m.Get_mock(reset.UserID)
+			if err != nil {
+				return nil, err
+			}
+			user.Password.Hash = passwordHash
+			if err := m.Update_mock(user); err != nil {
+				return nil, err
+			}
+			reset.UsedAt = &at
+			found := *reset
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrRecordNotFound
+}
+
+// admin may do anything the handlers allow.
+var admin = auth.Claims{UserID: 100, Name: "admin", Role: models.RoleAdmin, SessionID: 1}
+
+// policy keeps sessions for an hour and doesn't limit logins.
+var policy = service.Policy{SessionTTL: time.Hour}
+
+// tokens sign with a key made up for the tests.
+var tokens = func() *auth.Tokens {
+	tokens, err := auth.NewTokens(config.NewConfig())
+	if err != nil {
+		panic(err)
+	}
+	return tokens
+}()
+
 // r.Post("/user", ctrl.UserHandler.CreateUser)
 // 	r.Get("/user/login", ctrl.UserHandler.Login)
 // 	r.Get("/user/logout", ctrl.UserHandler.Logout)
@@ -84,9 +199,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusOK {
@@ -116,9 +231,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusInternalServerError {
@@ -149,9 +264,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusInternalServerError {
@@ -182,9 +297,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -215,9 +330,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -248,9 +363,9 @@ func TestCreateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -285,12 +400,13 @@ func TestGetByIDHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("id", "1")
 		controller.GetUserById(w, req)
@@ -322,12 +438,13 @@ func TestGetByIDHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("id", "1")
 		controller.GetUserById(w, req)
@@ -360,12 +477,13 @@ func TestGetByIDHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("id", "1")
 		controller.GetUserById(w, req)
@@ -398,12 +516,13 @@ func TestGetByIDHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("id", "lklj")
 		controller.GetUserById(w, req)
@@ -440,14 +559,15 @@ func TestGetByEmailHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "ljlj")
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 
 		controller.GetUserByName(w, req)
 
@@ -479,9 +599,9 @@ func TestGetByEmailHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.GetUserById(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -513,9 +633,9 @@ func TestGetByEmailHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.GetUserById(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -546,14 +666,15 @@ func TestGetByEmailHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "ljlj")
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 
 		controller.GetUserByName(w, req)
 
@@ -599,12 +720,13 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "john")
 		controller.UpdateUser(w, req)
@@ -637,9 +759,9 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusInternalServerError {
@@ -670,9 +792,9 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusInternalServerError {
@@ -703,9 +825,9 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -736,9 +858,9 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -769,9 +891,9 @@ func TestUpdateHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.CreateUser(w, req)
 
 		if w.Code != http.StatusBadRequest {
@@ -809,9 +931,9 @@ func TestListAllHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.ListUsers(w, req)
 
 		if w.Code != http.StatusOK {
@@ -819,7 +941,7 @@ func TestListAllHandler(t *testing.T) {
 		}
 
 	})
-	t.Run("internal server error", func(t *testing.T) {
+	t.Run("invalid filters", func(t *testing.T) {
 
 		req := httptest.NewRequest("POST", "/api/users/list", nil)
 		req.Header.Set("Content-Type", "application/json")
@@ -844,13 +966,13 @@ func TestListAllHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.ListUsers(w, req)
 
-		if w.Code != http.StatusInternalServerError {
-			t.Errorf("expected status code %d but got %d", http.StatusInternalServerError, w.Code)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, w.Code)
 		}
 
 	})
@@ -879,9 +1001,9 @@ func TestListAllHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		controller.ListUsers(w, req)
 
 		if w.Code != http.StatusInternalServerError {
@@ -921,12 +1043,13 @@ func TestDeleteHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "user")
 		controller.DeleteUser(w, req)
@@ -963,12 +1086,13 @@ func TestDeleteHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("none", "d")
 		controller.DeleteUser(w, req)
@@ -1006,12 +1130,13 @@ func TestDeleteHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "user")
 		controller.DeleteUser(w, req)
@@ -1049,12 +1174,13 @@ func TestDeleteHandler(t *testing.T) {
 			DisallowUnknownFields:  true,
 		})
 
-		service := service.NewUserService(mock)
+		service := service.NewUserService(mock, policy, nil)
 
-		controller := NewUserHandler(responder.NewResponder(decoder, logger), service)
+		controller := NewUserHandler(responder.NewResponder(decoder, logger), service, tokens)
 		chiCtx := chi.NewRouteContext()
 
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
 
 		chiCtx.URLParams.Add("username", "user")
 		controller.DeleteUser(w, req)
@@ -1066,3 +1192,383 @@ func TestDeleteHandler(t *testing.T) {
 	})
 
 }
+
+func TestUserAccess(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	carl := func(email string) (*models.User, error) {
+		return &models.User{ID: 7, Name: "carl", Email: "carl@example.com", Role: models.RoleMember, Password: models.Password{Hash: []byte("hash")}}, nil
+	}
+	mock := &MockStorage{
+		GetByName_mock: carl,
+		Update_mock:    func(user *models.User) error { return nil },
+		Delete_mock:    func(id int64) error { return nil },
+	}
+	controller := NewUserHandler(responder.NewResponder(decoder, zap.NewNop()), service.NewUserService(mock, policy, nil), tokens)
+
+	serve := func(handler http.HandlerFunc, method, body string, claims auth.Claims) int {
+		req := httptest.NewRequest(method, "/user/carl", bytes.NewReader([]byte(body)))
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("username", "carl")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), claims))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	owner := auth.Claims{UserID: 7, Role: models.RoleMember}
+	member := auth.Claims{UserID: 8, Role: models.RoleMember}
+	librarian := auth.Claims{UserID: 9, Role: models.RoleLibrarian}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		claims  auth.Claims
+		code    int
+	}{
+		{"owner reads", controller.GetUserByName, "GET", "", owner, http.StatusOK},
+		{"librarian reads", controller.GetUserByName, "GET", "", librarian, http.StatusOK},
+		{"member reads", controller.GetUserByName, "GET", "", member, http.StatusForbidden},
+		{"owner updates", controller.UpdateUser, "PUT", `{"name": "cj"}`, owner, http.StatusOK},
+		{"librarian updates", controller.UpdateUser, "PUT", `{"name": "cj"}`, librarian, http.StatusForbidden},
+		{"owner deletes", controller.DeleteUser, "DELETE", "", owner, http.StatusOK},
+		{"admin deletes", controller.DeleteUser, "DELETE", "", admin, http.StatusOK},
+		{"member deletes", controller.DeleteUser, "DELETE", "", member, http.StatusForbidden},
+		{"librarian deletes", controller.DeleteUser, "DELETE", "", librarian, http.StatusForbidden},
+		{"role set", controller.SetRole, "PUT", `{"role": "librarian"}`, admin, http.StatusOK},
+		{"unknown role", controller.SetRole, "PUT", `{"role": "janitor"}`, admin, http.StatusBadRequest},
+		{"missing role", controller.SetRole, "PUT", `{}`, admin, http.StatusBadRequest},
+	} {
+		if code := serve(tc.handler, tc.method, tc.body, tc.claims); code != tc.code {
+			t.Errorf("%s: expected status code %d but got %d", tc.name, tc.code, code)
+		}
+	}
+}
+
+func TestSessionHandlers(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	carl := &models.User{ID: 7, Name: "carl", Email: "carl@example.com", Role: models.RoleMember}
+	if err := carl.Password.Set("grove street"); err != nil {
+		t.Fatal(err)
+	}
+	carl.Password.Plaintext = nil
+	mock := &MockStorage{
+		GetByName_mock: func(email string) (*models.User, error) { user := *carl; return &user, nil },
+		Get_mock:       func(id int64) (*models.User, error) { user := *carl; return &user, nil },
+		Update_mock:    func(user *models.User) error { return nil },
+	}
+	userService := service.NewUserService(mock, policy, nil)
+	controller := NewUserHandler(responder.NewResponder(decoder, zap.NewNop()), userService, tokens)
+
+	serve := func(handler http.HandlerFunc, req *http.Request, claims *auth.Claims) *httptest.ResponseRecorder {
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("username", "carl")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		if claims != nil {
+			req = req.WithContext(auth.NewContext(req.Context(), *claims))
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	login := func() tokenResponse {
+		t.Helper()
+		w := serve(controller.Login, httptest.NewRequest("POST", "/user/login", bytes.NewReader([]byte(`{"username": "carl", "password": "grove street"}`))), nil)
+		var got tokenResponse
+		if err := jsoniter.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("login: expected status code %d but got %d (%v)", http.StatusOK, w.Code, err)
+		}
+		return got
+	}
+	claimsOf := func(access string) auth.Claims {
+		t.Helper()
+		claims, err := tokens.Verify(access)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims
+	}
+	active := func(access string) bool {
+		ok, err := userService.SessionActive(context.Background(), claimsOf(access).SessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	t.Run("refresh", func(t *testing.T) {
+		first := login()
+		if first.RefreshToken == "" || claimsOf(first.AccessToken).UserID != carl.ID {
+			t.Fatalf("unexpected tokens %+v", first)
+		}
+
+		w := serve(controller.Refresh, httptest.NewRequest("POST", "/user/refresh", bytes.NewReader([]byte(`{"refresh_token": "`+first.RefreshToken+`"}`))), nil)
+		var second tokenResponse
+		if err := jsoniter.Unmarshal(w.Body.Bytes(), &second); w.Code != http.StatusOK || err != nil || second.RefreshToken == first.RefreshToken {
+			t.Fatalf("expected new tokens, got %d %s", w.Code, w.Body)
+		}
+
+		req := httptest.NewRequest("POST", "/user/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: refreshCookie, Value: second.RefreshToken})
+		if w := serve(controller.Refresh, req, nil); w.Code != http.StatusOK {
+			t.Errorf("expected status code %d for the cookie but got %d", http.StatusOK, w.Code)
+		}
+
+		for _, used := range []string{first.RefreshToken, second.RefreshToken} {
+			w = serve(controller.Refresh, httptest.NewRequest("POST", "/user/refresh", bytes.NewReader([]byte(`{"refresh_token": "`+used+`"}`))), nil)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected status code %d for a used token but got %d", http.StatusUnauthorized, w.Code)
+			}
+		}
+		if active(second.AccessToken) {
+			t.Error("expected reusing a token to end the session")
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		session, other := login(), login()
+		claims := claimsOf(session.AccessToken)
+		if w := serve(controller.Logout, httptest.NewRequest("POST", "/user/logout", nil), &claims); w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, w.Code)
+		}
+		if active(session.AccessToken) || !active(other.AccessToken) {
+			t.Error("expected only the caller's session to end")
+		}
+	})
+
+	t.Run("revoke all", func(t *testing.T) {
+		session := login()
+		w := serve(controller.RevokeSessions, httptest.NewRequest("DELETE", "/user/carl/sessions", nil), &admin)
+		if w.Code != http.StatusOK || active(session.AccessToken) {
+			t.Errorf("expected the sessions to end, got %d %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("password change", func(t *testing.T) {
+		session := login()
+		claims := claimsOf(session.AccessToken)
+		w := serve(controller.UpdateUser, httptest.NewRequest("PUT", "/user/carl", bytes.NewReader([]byte(`{"password": "ballas suck", "current_password": "wrong"}`))), &claims)
+		if w.Code != http.StatusBadRequest || !active(session.AccessToken) {
+			t.Errorf("expected a wrong current password to be refused, got %d", w.Code)
+		}
+		w = serve(controller.UpdateUser, httptest.NewRequest("PUT", "/user/carl", bytes.NewReader([]byte(`{"password": "ballas suck", "current_password": "grove street"}`))), &claims)
+		if w.Code != http.StatusOK || active(session.AccessToken) {
+			t.Errorf("expected the change to end the sessions, got %d", w.Code)
+		}
+	})
+}
+
+func TestSetRoleEndsSessions(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	carl := &models.User{ID: 7, Name: "carl", Email: "carl@example.com", Role: models.RoleLibrarian}
+	if err := carl.Password.Set("grove street"); err != nil {
+		t.Fatal(err)
+	}
+	carl.Password.Plaintext = nil
+	mock := &MockStorage{
+		GetByName_mock: func(email string) (*models.User, error) { user := *carl; return &user, nil },
+		Update_mock:    func(user *models.User) error { carl.Role = user.Role; return nil },
+	}
+	respond := responder.NewResponder(decoder, zap.NewNop())
+	userService := service.NewUserService(mock, policy, nil)
+	controller := NewUserHandler(respond, userService, tokens)
+	guarded := auth.New(tokens, userService, respond).Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	login := func() string {
+		t.Helper()
+		session, _, err := userService.StartSession(context.Background(), carl.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		access, err := tokens.Issue(carl, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return access
+	}
+	authenticate := func(access string) int {
+		req := httptest.NewRequest("GET", "/user/carl", nil)
+		req.Header.Set("Authorization", "Bearer "+access)
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		return w.Code
+	}
+	setRole := func(role string) {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/user/carl/role", bytes.NewReader([]byte(`{"role": "`+role+`"}`)))
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("username", "carl")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = req.WithContext(auth.NewContext(req.Context(), admin))
+		w := httptest.NewRecorder()
+		controller.SetRole(w, req)
+		if w.Code != http.StatusOK || carl.Role != role {
+			t.Fatalf("expected the role to become %s, got %d %s", role, w.Code, w.Body)
+		}
+	}
+
+	access := login()
+	setRole(models.RoleAdmin)
+	if code := authenticate(access); code != http.StatusOK {
+		t.Errorf("expected a promotion to keep the session, got %d", code)
+	}
+
+	access = login()
+	setRole(models.RoleMember)
+	if code := authenticate(access); code != http.StatusUnauthorized {
+		t.Errorf("expected the old admin token to be refused after the demotion, got %d", code)
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	carl := &models.User{ID: 7, Name: "carl", Email: "carl@example.com", Role: models.RoleMember}
+	if err := carl.Password.Set("grove street"); err != nil {
+		t.Fatal(err)
+	}
+	carl.Password.Plaintext = nil
+	mock := &MockStorage{
+		GetByName_mock: func(name string) (*models.User, error) {
+			if name != carl.Name {
+				return nil, repository.ErrRecordNotFound
+			}
+			user := *carl
+			return &user, nil
+		},
+	}
+	controller := NewUserHandler(responder.NewResponder(decoder, zap.NewNop()),
+		service.NewUserService(mock, service.Policy{SessionTTL: time.Hour, MaxLoginFailures: 2, MaxAddressFailures: 10, LockoutPeriod: time.Minute}, nil), tokens)
+
+	login := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/user/login", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		controller.Login(w, req)
+		return w
+	}
+
+	for _, body := range []string{`{"username": "carl"}`, `{"password": "grove street"}`, `username=carl`} {
+		if w := login(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d but got %d", body, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	unknown := login(`{"username": "ryder", "password": "grove street"}`)
+	wrong := login(`{"username": "carl", "password": "ballas"}`)
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != http.StatusUnauthorized || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("expected the same 401 for unknown names and wrong passwords, got %d %s and %d %s", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+
+	// the second failure locks the account, even the right password is refused
+	if w := login(`{"username": "carl", "password": "ballas"}`); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if w := login(`{"username": "carl", "password": "grove street"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the locked account to be refused, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	controller.ListLockouts(w, httptest.NewRequest("GET", "/user/lockouts", nil))
+	var listed struct {
+		Data []models.Lockout `json:"data"`
+	}
+	if err := jsoniter.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed.Data) == 0 ||
+		listed.Data[0].Account != "carl" || listed.Data[0].Failures != 2 || listed.Data[0].LockedUntil == nil {
+		t.Errorf("expected carl to be listed as locked, got %s (%v)", w.Body, err)
+	}
+
+	req := httptest.NewRequest("DELETE", "/user/carl/lockout", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("username", "carl")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	controller.Unlock(httptest.NewRecorder(), req)
+	if w := login(`{"username": "carl", "password": "grove street"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the unlocked account to log in, got %d %s", w.Code, w.Body)
+	}
+}
+
+// outbox keeps the mail instead of sending it.
+type outbox []mailer.Message
+
+func (o *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	*o = append(*o, msg)
+	return nil
+}
+
+func TestPasswordResetHandlers(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	carl := &models.User{ID: 7, Name: "carl", Email: "carl@example.com", Role: models.RoleMember}
+	if err := carl.Password.Set("grove street"); err != nil {
+		t.Fatal(err)
+	}
+	carl.Password.Plaintext = nil
+	mock := &MockStorage{
+		GetByName_mock: func(name string) (*models.User, error) { user := *carl; return &user, nil },
+		GetByEmail_mock: func(email string) (*models.User, error) {
+			if email != carl.Email {
+				return nil, repository.ErrRecordNotFound
+			}
+			user := *carl
+			return &user, nil
+		},
+		Get_mock:    func(id int64) (*models.User, error) { user := *carl; return &user, nil },
+		Update_mock: func(user *models.User) error { carl.Password.Hash = user.Password.Hash; return nil },
+	}
+	mail := &outbox{}
+	userService := service.NewUserService(mock, service.Policy{SessionTTL: time.Hour, ResetTTL: time.Hour, ResetURL: "http://localhost/reset"}, mail)
+	controller := NewUserHandler(responder.NewResponder(decoder, zap.NewNop()), userService, tokens)
+
+	serve := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/user/password-reset", bytes.NewReader([]byte(body))))
+		return w
+	}
+
+	known := serve(controller.RequestPasswordReset, `{"email": "carl@example.com"}`)
+	unknown := serve(controller.RequestPasswordReset, `{"email": "ryder@example.com"}`)
+	if known.Code != http.StatusOK || known.Body.String() != unknown.Body.String() {
+		t.Errorf("expected the same answer for known and unknown emails, got %d %s and %d %s", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+	if w := serve(controller.RequestPasswordReset, `{"email": "carl"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for a bad email but got %d", http.StatusBadRequest, w.Code)
+	}
+	userService.WaitForMail()
+	if len(*mail) != 1 {
+		t.Fatalf("expected one mail, got %+v", *mail)
+	}
+	_, token, _ := strings.Cut((*mail)[0].Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	session, _, err := userService.StartSession(context.Background(), carl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{`{"token": "` + token + `", "password": "short"}`, `{"password": "new password"}`} {
+		if w := serve(controller.ResetPassword, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d but got %d", body, http.StatusBadRequest, w.Code)
+		}
+	}
+	if w := serve(controller.ResetPassword, `{"token": "`+token+`", "password": "new password"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d %s", http.StatusOK, w.Code, w.Body)
+	}
+	if ok, _ := carl.Password.Matches("new password"); !ok {
+		t.Error("expected the new password to be stored")
+	}
+	if active, _ := userService.SessionActive(context.Background(), session.ID); active {
+		t.Error("expected the reset to end the session")
+	}
+	if w := serve(controller.ResetPassword, `{"token": "`+token+`", "password": "newer password"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for a used token but got %d", http.StatusBadRequest, w.Code)
+	}
+
+	codes := make([]int, 0, 3)
+	for range 3 {
+		codes = append(codes, serve(controller.RequestPasswordReset, `{"email": "carl@example.com"}`).Code)
+	}
+	userService.WaitForMail()
+	if codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the fourth request for an email to get %d, got %v", http.StatusTooManyRequests, codes)
+	}
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"test/internal/db"
+	"test/internal/models"
+)
+
+func (m UserModel) InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	query := `
+        INSERT INTO password_resets (user_id, token_hash, created_at, expires_at)
+        VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	id, err := db.InsertReturningID(ctx, m.DB, query, reset.UserID, reset.TokenHash, reset.CreatedAt, reset.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	reset.ID = id
+	return nil
+}
+
+// UsePasswordReset spends the unused, unexpired token with the hash and
+// every other open token of the same user, and sets the user's password
+// hash, in one transaction. ErrRecordNotFound means there is no such token
+// or user, ErrEditConflict that the token was spent in the meantime.
+func (m UserModel) UsePasswordReset(ctx context.Context, hash string, passwordHash []byte, at time.Time) (*models.PasswordReset, error) {
+	query := `
+        SELECT id, user_id, token_hash, created_at, expires_at
+        FROM password_resets
+        WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var reset models.PasswordReset
+	err := db.WithTx(ctx, m.DB, func(tx *sqlx.Tx) error {
+		err := tx.QueryRowContext(ctx, tx.Rebind(query+db.ForUpdate(tx)), hash, at).Scan(
+			&reset.ID,
+			&reset.UserID,
+			&reset.TokenHash,
+			&reset.CreatedAt,
+			&reset.ExpiresAt,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if err := spendPasswordReset(ctx, tx, &reset, at); err != nil {
+			return err
+		}
+
+		query := `
+        UPDATE users
+        SET password_hash = ?, version = version + 1
+        WHERE id = ? AND deleted = false`
+
+		result, err := tx.ExecContext(ctx, tx.Rebind(query), passwordHash, reset.UserID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reset.UsedAt = &at
+	return &reset, nil
+}
+
+// spendPasswordReset marks the reset used, then the other open tokens of
+// its user. The reset has to be the row that gets marked, a token spent
+// since it was read is an ErrEditConflict.
+func spendPasswordReset(ctx context.Context, q db.Querier, reset *models.PasswordReset, at time.Time) error {
+	query := `
+        UPDATE password_resets
+        SET used_at = ?
+        WHERE id = ? AND used_at IS NULL AND expires_at > ?`
+
+	result, err := q.ExecContext(ctx, q.Rebind(query), at, reset.ID, at)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	query = `
+        UPDATE password_resets
+        SET used_at = ?
+        WHERE user_id = ? AND used_at IS NULL`
+
+	_, err = q.ExecContext(ctx, q.Rebind(query), at, reset.UserID)
+	return err
+}
@@ -1,15 +1,28 @@
 package repository
 
 import (
+	"context"
 	filter "test/internal/infrastructure/filters"
 	"test/internal/models"
+	"time"
 )
 
 type IUserStorage interface {
-	GetByName(username string) (*models.User, error)
-	Get(id int64) (*models.User, error)
-	GetAll(filters filter.Filters) ([]*models.User, filter.Metadata, error)
-	Insert(user *models.User) error
-	Update(user *models.User) error
-	Delete(id int64) error
+	GetByName(ctx context.Context, username string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	Get(ctx context.Context, id int64) (*models.User, error)
+	GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error)
+	Insert(ctx context.Context, user *models.User) error
+	Update(ctx context.Context, user *models.User) error
+	Delete(ctx context.Context, id int64) error
+
+	InsertSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, id int64) (*models.Session, error)
+	GetSessionByToken(ctx context.Context, hash string) (*models.Session, error)
+	RotateSession(ctx context.Context, session *models.Session, hash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id int64, at time.Time) error
+	RevokeSessions(ctx context.Context, userID int64, at time.Time) (int, error)
+
+	InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error
+	UsePasswordReset(ctx context.Context, hash string, passwordHash []byte, at time.Time) (*models.PasswordReset, error)
 }
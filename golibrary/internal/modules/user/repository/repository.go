@@ -5,53 +5,68 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
 	//"fmt"
-	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 
+	"test/internal/db"
+	"test/internal/db/dberrors"
 	filter "test/internal/infrastructure/filters"
 	"test/internal/models"
 )
 
 type UserModel struct {
 	DB *sqlx.DB
+	// timeout bounds every single operation, on top of the caller's context
+	timeout time.Duration
 }
 
-func NewUserModel(db *sqlx.DB) IUserStorage {
-	return &UserModel{DB: db}
+func NewUserModel(db *sqlx.DB, timeout time.Duration) IUserStorage {
+	return &UserModel{DB: db, timeout: timeout}
 }
 
 var (
 	ErrRecordNotFound = errors.New("user not found")
 	ErrEditConflict   = errors.New("edit conflict")
 	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrDuplicateName  = errors.New("duplicate name")
 )
 
-func (m UserModel) Get(id int64) (*models.User, error) {
+// duplicate tells which unique column of users a write collided with from
+// the name of the violated constraint.
+func duplicate(err error) error {
+	if strings.Contains(dberrors.Constraint(err), "name") {
+		return ErrDuplicateName
+	}
+	return ErrDuplicateEmail
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*models.User, error) {
 
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-        SELECT  id,  name, email, password_hash, deleted, version
+        SELECT  id,  name, email, role, password_hash, deleted, version
         FROM users
-        WHERE id = $1 AND deleted = false`
+        WHERE id = ? AND deleted = false`
 
 	user := &models.User{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, m.DB.Rebind(query), id).Scan(
 
 		&user.ID,
 		&user.Name,
 		&user.Email,
+		&user.Role,
 		&user.Password.Hash,
 		&user.Deleted,
 		&user.Version,
@@ -60,10 +75,8 @@ func (m UserModel) Get(id int64) (*models.User, error) {
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			fmt.Println("no rows", err)
 			return nil, ErrRecordNotFound
 		default:
-			fmt.Println("some error", err)
 			return nil, err
 		}
 	}
@@ -71,46 +84,53 @@ func (m UserModel) Get(id int64) (*models.User, error) {
 	return user, nil
 }
 
-func (m UserModel) Insert(user *models.User) error {
+func (m UserModel) Insert(ctx context.Context, user *models.User) error {
+	if user.Role == "" {
+		user.Role = models.RoleMember
+	}
+
 	query := `
-        INSERT INTO users (name, email, password_hash, deleted) 
-        VALUES ($1, $2, $3, $4)
-        RETURNING id, version`
+        INSERT INTO users (name, email, role, password_hash, deleted) 
+        VALUES (?, ?, ?, ?, ?)`
 
-	args := []any{user.Name, user.Email, user.Password.Hash, user.Deleted}
+	args := []any{user.Name, user.Email, user.Role, user.Password.Hash, user.Deleted}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Version)
+	id, err := db.InsertReturningID(ctx, m.DB, query, args...)
 	if err != nil {
+		err = dberrors.Classify(err)
 		switch {
-		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
-			return ErrDuplicateEmail
+		case errors.Is(err, dberrors.ErrUniqueViolation):
+			return duplicate(err)
 		default:
-			fmt.Println("some error", err)
 			return err
 		}
 	}
 
+	user.ID = id
+	user.Version = 1
+
 	return nil
 }
 
-func (m UserModel) GetByName(username string) (*models.User, error) {
+func (m UserModel) GetByName(ctx context.Context, username string) (*models.User, error) {
 	query := `
-        SELECT id, name, email, password_hash, deleted, version
+        SELECT id, name, email, role, password_hash, deleted, version
         FROM users
-        WHERE name = $1 AND deleted = false`
+        WHERE name = ? AND deleted = false`
 
 	var user models.User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, username).Scan(
+	err := m.DB.QueryRowContext(ctx, m.DB.Rebind(query), username).Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
+		&user.Role,
 		&user.Password.Hash,
 		&user.Deleted,
 		&user.Version,
@@ -119,10 +139,8 @@ func (m UserModel) GetByName(username string) (*models.User, error) {
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			fmt.Println("no rows", err)
 			return nil, ErrRecordNotFound
 		default:
-			fmt.Println("some error", err)
 			return nil, err
 		}
 	}
@@ -130,43 +148,84 @@ func (m UserModel) GetByName(username string) (*models.User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *models.User) error {
+// GetByEmail finds the user owning an email, the column compares without
+// regard to case.
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := `
+        SELECT id, name, email, role, password_hash, deleted, version
+        FROM users
+        WHERE email = ? AND deleted = false`
+
+	var user models.User
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, m.DB.Rebind(query), email).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Role,
+		&user.Password.Hash,
+		&user.Deleted,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *models.User) error {
 	query := `
         UPDATE users 
-        SET name = $1, email = $2, password_hash = $3, version = version + 1
-        WHERE id = $4 AND version = $5 AND deleted = false
-        RETURNING version`
+        SET name = ?, email = ?, role = ?, password_hash = ?, version = version + 1
+        WHERE id = ? AND version = ? AND deleted = false`
 
 	args := []any{
 		user.Name,
 		user.Email,
+		user.Role,
 		user.Password.Hash,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	result, err := m.DB.ExecContext(ctx, m.DB.Rebind(query), args...)
 	if err != nil {
+		err = dberrors.Classify(err)
 		switch {
-		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
-			fmt.Println("duplicate", err)
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			fmt.Println("no rows", err)
-			return ErrEditConflict
+		case errors.Is(err, dberrors.ErrUniqueViolation):
+			return duplicate(err)
 		default:
-			fmt.Println("some error", err)
 			return err
 		}
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	user.Version++
+
 	return nil
 }
 
-func (m UserModel) Delete(id int64) error {
+func (m UserModel) Delete(ctx context.Context, id int64) error {
 
 	if id < 1 {
 		return ErrRecordNotFound
@@ -175,51 +234,55 @@ func (m UserModel) Delete(id int64) error {
 	query := `
         UPDATE users 
         SET deleted = true, version = version + 1
-		WHERE id = $1 AND deleted = false
-		RETURNING version
+		WHERE id = ? AND deleted = false
        `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 	defer cancel()
 
-	user := &models.User{}
+	result, err := m.DB.ExecContext(ctx, m.DB.Rebind(query), id)
+	if err != nil {
+		return err
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.Version)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
-			fmt.Println("duplicate", err)
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			fmt.Println("no rows", err)
-			return ErrEditConflict
-		default:
-			fmt.Println("some error", err)
-			return err
-		}
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
 	}
 
 	return nil
 
 }
 
-func (u UserModel) GetAll(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+// userSorts are the columns GetAll sorts by.
+var userSorts = map[string]bool{"id": true, "name": true, "email": true}
+
+func (m UserModel) GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+	orderBy := []string{}
+	for _, term := range filters.SortTerms() {
+		if !userSorts[term.Column] {
+			return nil, filter.Metadata{}, fmt.Errorf("unknown sort column %q", term.Column)
+		}
+		orderBy = append(orderBy, term.Column+" "+term.Direction())
+	}
 
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id,  name, email, password_hash, deleted, version
+        SELECT count(*) OVER(), id,  name, email, role, password_hash, deleted, version
         FROM users  
 		WHERE deleted = false
-        ORDER BY %s %s, id ASC
-        LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+        ORDER BY %s, id ASC
+        LIMIT ? OFFSET ?`, strings.Join(orderBy, ", "))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
 	defer cancel()
 
 	args := []any{filters.Limit(), filters.Offset()}
 
-	rows, err := u.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, m.DB.Rebind(query), args...)
 	if err != nil {
-		fmt.Println("some query error", err)
 		return nil, filter.Metadata{}, err
 	}
 
@@ -237,12 +300,12 @@ func (u UserModel) GetAll(filters filter.Filters) ([]*models.User, filter.Metada
 			&user.ID,
 			&user.Name,
 			&user.Email,
+			&user.Role,
 			&user.Password.Hash,
 			&user.Deleted,
 			&user.Version,
 		)
 		if err != nil {
-			fmt.Println("some row error", err)
 			return nil, filter.Metadata{}, err
 		}
 
@@ -250,7 +313,6 @@ func (u UserModel) GetAll(filters filter.Filters) ([]*models.User, filter.Metada
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Println("some iter error", err)
 		return nil, filter.Metadata{}, err
 	}
 
@@ -1,9 +1,17 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"test/config"
+	"test/internal/db"
 	filter "test/internal/infrastructure/filters"
 	"test/internal/models"
 	"testing"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 func NewMockStorage() *MockStorage {
@@ -38,58 +46,294 @@ type MockStorage struct {
 	Delete_mock     func(id int64) error
 }
 
-func (m *MockStorage) GetByEmail(email string) (*models.User, error) {
+func (m *MockStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	return m.GetByEmail_mock(email)
 }
 
-func (m *MockStorage) Get(id int64) (*models.User, error) {
+func (m *MockStorage) Get(ctx context.Context, id int64) (*models.User, error) {
 	return m.Get_mock(id)
 }
 
-func (m *MockStorage) GetAll(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+func (m *MockStorage) GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
 	return m.GetAll_mock(filters)
 }
 
-func (m *MockStorage) Insert(user *models.User) error {
+func (m *MockStorage) Insert(ctx context.Context, user *models.User) error {
 	return m.Insert_mock(user)
 }
 
-func (m *MockStorage) Update(user *models.User) error {
+func (m *MockStorage) Update(ctx context.Context, user *models.User) error {
 	return m.Update_mock(user)
 }
 
-func (m *MockStorage) Delete(id int64) error {
+func (m *MockStorage) Delete(ctx context.Context, id int64) error {
 	return m.Delete_mock(id)
 }
 
 func TestRepo(t *testing.T) {
+	ctx := context.Background()
 	userRepository := NewMockStorage()
 
 	t.Run("Get user by email", func(t *testing.T) {
-		resp, _ := userRepository.GetByEmail("")
+		resp, _ := userRepository.GetByEmail(ctx, "")
 		if resp == nil {
 			t.Errorf("expected error got nil")
 		}
 	})
 
 	t.Run("Get user by ID", func(t *testing.T) {
-		resp, _ := userRepository.Get(0)
+		resp, _ := userRepository.Get(ctx, 0)
 		if resp == nil {
 			t.Errorf("expected error got nil")
 		}
 	})
 
 	t.Run("List users", func(t *testing.T) {
-		resp, _, _ := userRepository.GetAll(filter.Filters{})
+		resp, _, _ := userRepository.GetAll(ctx, filter.Filters{})
 		if resp == nil {
 			t.Errorf("expected error got nil")
 		}
 	})
 
 	t.Run("Create", func(t *testing.T) {
-		resp := userRepository.Insert(&models.User{})
+		resp := userRepository.Insert(ctx, &models.User{})
 		if resp != nil {
 			t.Errorf("expected error got nil")
 		}
 	})
 }
+
+func TestSqliteUserModel(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.NewConfig(config.WithDBname("sqlite3"), config.WithDSN(":memory:"))
+	dbx, err := db.NewSqlDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+
+	userRepository := NewUserModel(dbx, time.Second)
+
+	user := &models.User{Name: "bigsmoke", Email: "smoke@example.com", Password: models.Password{Hash: []byte("hash")}}
+	if err := userRepository.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 0 || user.Version != 1 {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	t.Run("duplicate email", func(t *testing.T) {
+		err := userRepository.Insert(ctx, &models.User{Name: "other", Email: "SMOKE@example.com", Password: models.Password{Hash: []byte("hash")}})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("expected %v, got %v", ErrDuplicateEmail, err)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		err := userRepository.Insert(ctx, &models.User{Name: "bigsmoke", Email: "other@example.com", Password: models.Password{Hash: []byte("hash")}})
+		if !errors.Is(err, ErrDuplicateName) {
+			t.Errorf("expected %v, got %v", ErrDuplicateName, err)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		got, err := userRepository.Get(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != user.Email {
+			t.Errorf("expected %s, got %s", user.Email, got.Email)
+		}
+		got, err = userRepository.GetByName(ctx, user.Name)
+		if err != nil || got.ID != user.ID {
+			t.Errorf("expected user %d, got %+v (%v)", user.ID, got, err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		user.Name = "carl"
+		if err := userRepository.Update(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Version != 2 {
+			t.Errorf("expected version 2, got %d", user.Version)
+		}
+		stale := *user
+		stale.Version = 1
+		if err := userRepository.Update(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+			t.Errorf("expected %v, got %v", ErrEditConflict, err)
+		}
+	})
+
+	t.Run("role", func(t *testing.T) {
+		got, err := userRepository.Get(ctx, user.ID)
+		if err != nil || got.Role != models.RoleMember {
+			t.Fatalf("expected a member, got %+v (%v)", got, err)
+		}
+		got.Role = models.RoleLibrarian
+		if err := userRepository.Update(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		got, err = userRepository.GetByName(ctx, got.Name)
+		if err != nil || got.Role != models.RoleLibrarian {
+			t.Errorf("expected a librarian, got %+v (%v)", got, err)
+		}
+		*user = *got
+
+		bad := *got
+		bad.Role = "janitor"
+		if err := userRepository.Update(ctx, &bad); err == nil {
+			t.Error("expected the check constraint to refuse an unknown role")
+		}
+	})
+
+	t.Run("get all", func(t *testing.T) {
+		users, metadata, err := userRepository.GetAll(ctx, filter.Filters{Page: 1, PageSize: 20, Sort: "-name", SortSafelist: []string{"-name"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || metadata.TotalRecords != 1 {
+			t.Errorf("expected one user, got %d (%+v)", len(users), metadata)
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		session := &models.Session{UserID: user.ID, TokenHash: "first", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := userRepository.InsertSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := userRepository.RotateSession(ctx, session, "second", now.Add(2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		stale := *session
+		stale.TokenHash = "first"
+		if err := userRepository.RotateSession(ctx, &stale, "third", now); !errors.Is(err, ErrEditConflict) {
+			t.Errorf("expected %v for a replaced token, got %v", ErrEditConflict, err)
+		}
+		for _, hash := range []string{"first", "second"} {
+			got, err := userRepository.GetSessionByToken(ctx, hash)
+			if err != nil || got.ID != session.ID || got.TokenHash != "second" || got.PreviousHash != "first" {
+				t.Errorf("%s: unexpected session %+v (%v)", hash, got, err)
+			}
+		}
+
+		other := &models.Session{UserID: user.ID, TokenHash: "other", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := userRepository.InsertSession(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.RevokeSession(ctx, session.ID, now); err != nil {
+			t.Fatal(err)
+		}
+		got, err := userRepository.GetSession(ctx, session.ID)
+		if err != nil || got.RevokedAt == nil || !got.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+			t.Errorf("expected a revoked session, got %+v (%v)", got, err)
+		}
+		if n, err := userRepository.RevokeSessions(ctx, user.ID, now); err != nil || n != 1 {
+			t.Errorf("expected the other session to be revoked, got %d (%v)", n, err)
+		}
+		if _, err := userRepository.GetSession(ctx, 99); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", ErrRecordNotFound, err)
+		}
+	})
+
+	t.Run("password resets", func(t *testing.T) {
+		got, err := userRepository.GetByEmail(ctx, strings.ToUpper(user.Email))
+		if err != nil || got.ID != user.ID {
+			t.Fatalf("expected the user by email, got %+v (%v)", got, err)
+		}
+		if _, err := userRepository.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", ErrRecordNotFound, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, hash := range []string{"mailed", "mailed again", "expired"} {
+			expiresAt := now.Add(time.Hour)
+			if hash == "expired" {
+				expiresAt = now.Add(-time.Minute)
+			}
+			reset := &models.PasswordReset{UserID: user.ID, TokenHash: hash, CreatedAt: now, ExpiresAt: expiresAt}
+			if err := userRepository.InsertPasswordReset(ctx, reset); err != nil || reset.ID == 0 {
+				t.Fatalf("unexpected insert %+v (%v)", reset, err)
+			}
+		}
+
+		if _, err := userRepository.UsePasswordReset(ctx, "expired", []byte("new hash"), now); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected %v for an expired token, got %v", ErrRecordNotFound, err)
+		}
+		reset, err := userRepository.UsePasswordReset(ctx, "mailed", []byte("new hash"), now)
+		if err != nil || reset.UserID != user.ID || reset.UsedAt == nil {
+			t.Fatalf("unexpected reset %+v (%v)", reset, err)
+		}
+		if got, err := userRepository.Get(ctx, user.ID); err != nil || string(got.Password.Hash) != "new hash" || got.Version != user.Version+1 {
+			t.Errorf("expected the token to set the password, got %+v (%v)", got, err)
+		} else {
+			*user = *got
+		}
+		for _, hash := range []string{"mailed", "mailed again"} {
+			if _, err := userRepository.UsePasswordReset(ctx, hash, []byte("new hash"), now); !errors.Is(err, ErrRecordNotFound) {
+				t.Errorf("%s: expected %v for a spent token, got %v", hash, ErrRecordNotFound, err)
+			}
+		}
+	})
+
+	t.Run("stale password reset", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		stale := &models.PasswordReset{UserID: user.ID, TokenHash: "stale", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := userRepository.InsertPasswordReset(ctx, stale); err != nil {
+			t.Fatal(err)
+		}
+		// another request spends the token and a new one is mailed between
+		// reading the stale token and marking it used
+		if _, err := userRepository.UsePasswordReset(ctx, "stale", []byte("new hash"), now); err != nil {
+			t.Fatal(err)
+		}
+		fresh := &models.PasswordReset{UserID: user.ID, TokenHash: "fresh", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := userRepository.InsertPasswordReset(ctx, fresh); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := spendPasswordReset(ctx, dbx, stale, now); !errors.Is(err, ErrEditConflict) {
+			t.Errorf("expected %v for a stale token, got %v", ErrEditConflict, err)
+		}
+		reset, err := userRepository.UsePasswordReset(ctx, "fresh", []byte("new hash"), now)
+		if err != nil || reset.ID != fresh.ID {
+			t.Errorf("expected the fresh token to stay usable, got %+v (%v)", reset, err)
+		}
+	})
+
+	t.Run("password reset of a deleted user", func(t *testing.T) {
+		ryder := &models.User{Name: "ryder", Email: "ryder@example.com", Password: models.Password{Hash: []byte("hash")}}
+		if err := userRepository.Insert(ctx, ryder); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		if err := userRepository.InsertPasswordReset(ctx, &models.PasswordReset{UserID: ryder.ID, TokenHash: "ryder", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.Delete(ctx, ryder.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := userRepository.UsePasswordReset(ctx, "ryder", []byte("new hash"), now); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", ErrRecordNotFound, err)
+		}
+		var unused bool
+		if err := dbx.GetContext(ctx, &unused, "SELECT used_at IS NULL FROM password_resets WHERE token_hash = 'ryder'"); err != nil || !unused {
+			t.Errorf("expected the failed reset to leave the token unspent (%v)", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := userRepository.Delete(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := userRepository.Get(ctx, user.ID); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected %v, got %v", ErrRecordNotFound, err)
+		}
+		if err := userRepository.Delete(ctx, user.ID); !errors.Is(err, ErrEditConflict) {
+			t.Errorf("expected %v, got %v", ErrEditConflict, err)
+		}
+	})
+}
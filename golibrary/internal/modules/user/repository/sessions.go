@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"test/internal/db"
+	"test/internal/models"
+)
+
+func (m UserModel) InsertSession(ctx context.Context, session *models.Session) error {
+	query := `
+        INSERT INTO sessions (user_id, token_hash, created_at, expires_at)
+        VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	id, err := db.InsertReturningID(ctx, m.DB, query, session.UserID, session.TokenHash, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	session.ID = id
+	return nil
+}
+
+func (m UserModel) GetSession(ctx context.Context, id int64) (*models.Session, error) {
+	return m.getSession(ctx, `WHERE id = ?`, id)
+}
+
+// GetSessionByToken finds the session a refresh token hash belongs to, the
+// current one or the one it replaced.
+func (m UserModel) GetSessionByToken(ctx context.Context, hash string) (*models.Session, error) {
+	return m.getSession(ctx, `WHERE token_hash = ? OR previous_hash = ?`, hash, hash)
+}
+
+func (m UserModel) getSession(ctx context.Context, where string, args ...any) (*models.Session, error) {
+	query := `
+        SELECT id, user_id, token_hash, COALESCE(previous_hash, ''), created_at, expires_at, revoked_at
+        FROM sessions
+        ` + where
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var session models.Session
+	err := m.DB.QueryRowContext(ctx, m.DB.Rebind(query), args...).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.TokenHash,
+		&session.PreviousHash,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token of an active session and moves
+// its expiry. ErrEditConflict means the token was replaced or revoked in
+// the meantime.
+func (m UserModel) RotateSession(ctx context.Context, session *models.Session, hash string, expiresAt time.Time) error {
+	query := `
+        UPDATE sessions
+        SET previous_hash = token_hash, token_hash = ?, expires_at = ?
+        WHERE id = ? AND token_hash = ? AND revoked_at IS NULL`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, m.DB.Rebind(query), hash, expiresAt, session.ID, session.TokenHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	session.PreviousHash = session.TokenHash
+	session.TokenHash = hash
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// RevokeSession ends a session, ending one that already ended is not an
+// error.
+func (m UserModel) RevokeSession(ctx context.Context, id int64, at time.Time) error {
+	query := `
+        UPDATE sessions
+        SET revoked_at = ?
+        WHERE id = ? AND revoked_at IS NULL`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, m.DB.Rebind(query), at, id)
+	return err
+}
+
+// RevokeSessions ends every session of a user and returns how many were
+// still going.
+func (m UserModel) RevokeSessions(ctx context.Context, userID int64, at time.Time) (int, error) {
+	query := `
+        UPDATE sessions
+        SET revoked_at = ?
+        WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?`
+
+	ctx, cancel := db.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, m.DB.Rebind(query), at, userID, at)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
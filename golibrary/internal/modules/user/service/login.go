@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+)
+
+// decoy is compared against the password when the name is unknown, so
+// those logins take as long as wrong passwords.
+var decoy = sync.OnceValue(func() models.Password {
+	var p models.Password
+	if err := p.Set("no account has this password"); err != nil {
+		panic(err)
+	}
+	return p
+})
+
+// Login checks the password of a user logging in from a client address.
+// Unknown names and wrong passwords both get ErrInvalidCredentials. Every
+// attempt counts against the address and, for a known name, the account
+// until the password turns out right; past the limits the logins are
+// refused with a LockoutError whatever the password. Unknown names aren't
+// tracked as accounts, so made up ones can't fill the throttle.
+func (s *UserService) Login(ctx context.Context, name, password, address string) (*models.User, error) {
+	now := s.now()
+	user, err := s.storage.GetByName(ctx, name)
+	if err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	hash, account := decoy(), ""
+	if user != nil {
+		hash, account = user.Password, user.Name
+	}
+	until, counted := s.throttle.attempt(account, address, now)
+	if !counted {
+		return nil, &LockoutError{Until: until}
+	}
+
+	ok, err := hash.Matches(password)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !ok {
+		if !until.IsZero() {
+			return nil, &LockoutError{Until: until}
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	s.throttle.succeed(account, address, now)
+	return user, nil
+}
+
+// Lockouts lists the accounts and the client addresses with failed logins
+// that still count, the locked ones first.
+func (s *UserService) Lockouts(ctx context.Context) []*models.Lockout {
+	return s.throttle.lockouts(s.now())
+}
+
+// ExpireLockouts forgets the failed logins and reset requests that no
+// longer count and returns how many accounts, emails and addresses it
+// dropped.
+func (s *UserService) ExpireLockouts(ctx context.Context) (int, error) {
+	now := s.now()
+	return s.throttle.sweep(now) + s.resets.sweep(now), nil
+}
+
+// Unlock forgets the failed logins of an account, lifting its lockout, and
+// reports whether it had any. Lockouts of addresses run out by themselves.
+func (s *UserService) Unlock(ctx context.Context, name string) bool {
+	return s.throttle.unlock(name)
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"test/internal/infrastructure/mailer"
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+
+	"go.uber.org/zap"
+)
+
+// ErrInvalidResetToken is returned for password reset tokens that are
+// unknown, expired or already used.
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// resetEmailLimit requests for one email and resetAddressLimit from one
+// client address within resetWindow are answered, the ones after them are
+// refused with a LockoutError until the window is over.
+const (
+	resetEmailLimit   = 3
+	resetAddressLimit = 10
+	resetWindow       = time.Hour
+)
+
+// resetMailTimeout bounds the lookup and the mail that follow a request.
+const resetMailTimeout = 30 * time.Second
+
+// ErrTooManyResets is the reason of a LockoutError for reset requests.
+var ErrTooManyResets = errors.New("too many password reset requests, try again later")
+
+// RequestPasswordReset mails a single use token for setting a new password
+// to the user owning the email. The lookup and the mail happen in the
+// background after the call returns, so neither the answer nor its timing
+// tells which emails are registered; WaitForMail waits for them.
+func (s *UserService) RequestPasswordReset(ctx context.Context, email, address string) error {
+	if until, counted := s.resets.attempt(strings.ToLower(email), address, s.now()); !counted {
+		return &LockoutError{Until: until, Reason: ErrTooManyResets}
+	}
+
+	s.mailing.Add(1)
+	go func() {
+		defer s.mailing.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+		defer cancel()
+		if err := s.mailReset(ctx, email); err != nil {
+			s.logger.Error("error on mailing a password reset", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// WaitForMail waits for the password reset mails still being sent.
+func (s *UserService) WaitForMail() {
+	s.mailing.Wait()
+}
+
+// mailReset stores a token for the user owning the email and mails it to
+// them. Nothing happens for emails no user owns.
+func (s *UserService) mailReset(ctx context.Context, email string) error {
+	user, err := s.storage.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+	link, err := resetLink(s.policy.ResetURL, token)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	reset := &models.PasswordReset{UserID: user.ID, TokenHash: hash, CreatedAt: now, ExpiresAt: now.Add(s.policy.ResetTTL)}
+	if err := s.storage.InsertPasswordReset(ctx, reset); err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		From:    s.policy.MailFrom,
+		To:      user.Email,
+		Subject: "Reset your library password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"someone asked to reset the password of your library account. To choose a new one open\n\n%s\n\n"+
+			"or send this token with your new password: %s\n\n"+
+			"The token works once and expires at %s. If you didn't ask for it, ignore this mail.\n",
+			user.Name, link, token, reset.ExpiresAt.Format("2006-01-02 15:04 MST")),
+	})
+}
+
+// resetLink appends the token to the reset page address.
+func resetLink(page, token string) (string, error) {
+	u, err := url.Parse(page)
+	if err != nil {
+		return "", fmt.Errorf("password reset url: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ResetPassword spends a mailed token, along with any other open token of
+// the user, to set a new password. The token is only spent together with
+// the new password. Like any password change it ends every session of the
+// user, and it lifts a lockout of the account.
+func (s *UserService) ResetPassword(ctx context.Context, token, password string) error {
+	var p models.Password
+	if err := p.Set(password); err != nil {
+		return err
+	}
+
+	reset, err := s.storage.UsePasswordReset(ctx, hashToken(token), p.Hash, s.now().UTC())
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) || errors.Is(err, repository.ErrEditConflict) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if _, err := s.EndSessions(ctx, reset.UserID); err != nil {
+		return err
+	}
+	user, err := s.storage.Get(ctx, reset.UserID)
+	if err != nil {
+		return err
+	}
+
+	s.throttle.unlock(user.Name)
+	return nil
+}
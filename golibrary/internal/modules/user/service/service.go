@@ -1,12 +1,18 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"test/internal/infrastructure/filters"
+	"test/internal/infrastructure/mailer"
 	"test/internal/infrastructure/validator"
 	"test/internal/modules/user/repository"
+	"time"
 
 	"test/internal/models"
+
+	"go.uber.org/zap"
 )
 
 var (
@@ -15,6 +21,9 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 	ErrNoUser         = errors.New("user doesn't exist")
 	ErrWrongPassword  = errors.New("wrong password")
+	// ErrInvalidCredentials is the one answer to unknown names and wrong
+	// passwords, telling them apart would confirm which names exist
+	ErrInvalidCredentials = errors.New("invalid username or password")
 )
 
 // r.Post("/user", ctrl.UserHandler.CreateUser)//ctrl.Auth.Register
@@ -27,44 +36,134 @@ var (
 // 	r.Post("/user/CreateWithArray", ctrl.UserHandler.CreateArray)
 
 type IUserService interface {
-	GetUserByName(email string) (*models.User, error)
-	GetUserById(id int64) (*models.User, error)
-	CreateUser(user *models.User) error
-	UpdateUser(user *models.User) error
-	DeleteUser(id int64) error
-	ListUsers(filters filters.Filters) ([]*models.User, filters.Metadata, error)
+	GetUserByName(ctx context.Context, email string) (*models.User, error)
+	GetUserById(ctx context.Context, id int64) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	SetRole(ctx context.Context, user *models.User, role string) error
+	DeleteUser(ctx context.Context, id int64) error
+	ListUsers(ctx context.Context, filters filters.Filters) ([]*models.User, filters.Metadata, error)
+
+	StartSession(ctx context.Context, userID int64) (*models.Session, string, error)
+	RefreshSession(ctx context.Context, token string) (*models.User, *models.Session, string, error)
+	EndSession(ctx context.Context, id int64) error
+	EndSessions(ctx context.Context, userID int64) (int, error)
+	SessionActive(ctx context.Context, id int64) (bool, error)
+
+	Login(ctx context.Context, name, password, address string) (*models.User, error)
+	Lockouts(ctx context.Context) []*models.Lockout
+	ExpireLockouts(ctx context.Context) (int, error)
+	Unlock(ctx context.Context, name string) bool
+
+	RequestPasswordReset(ctx context.Context, email, address string) error
+	WaitForMail()
+	ResetPassword(ctx context.Context, token, password string) error
+}
+
+// Policy holds the rules of sessions, logins and password resets.
+type Policy struct {
+	// SessionTTL is how long a refresh token is valid, every refresh starts
+	// it over
+	SessionTTL time.Duration
+	// MaxLoginFailures failed logins of an account, MaxAddressFailures from
+	// one client address, within LockoutPeriod lock them out for the
+	// period. 0 doesn't limit.
+	MaxLoginFailures   int
+	MaxAddressFailures int
+	LockoutPeriod      time.Duration
+	// ResetTTL is how long a mailed password reset token is valid, the mail
+	// comes from MailFrom and links to ResetURL with the token appended
+	ResetTTL time.Duration
+	ResetURL string
+	MailFrom string
 }
 
 type UserService struct {
-	storage repository.IUserStorage
+	storage  repository.IUserStorage
+	policy   Policy
+	throttle *throttle
+	// resets throttles the password reset requests, mailing counts the
+	// reset mails still being sent
+	resets  *throttle
+	mailing sync.WaitGroup
+	mailer  mailer.Mailer
+	logger  *zap.Logger
+	now     func() time.Time
 }
 
-func NewUserService(repo repository.IUserStorage) *UserService {
-	return &UserService{storage: repo}
+func NewUserService(repo repository.IUserStorage, policy Policy, mailer mailer.Mailer) *UserService {
+	return &UserService{
+		storage:  repo,
+		policy:   policy,
+		mailer:   mailer,
+		throttle: newThrottle(policy.MaxLoginFailures, policy.MaxAddressFailures, policy.LockoutPeriod),
+		resets:   newThrottle(resetEmailLimit, resetAddressLimit, resetWindow),
+		logger:   zap.NewNop(),
+		now:      time.Now,
+	}
 }
 
-func (s *UserService) CreateUser(user *models.User) error {
-	return s.storage.Insert(user)
+// SetClock replaces the clock the sessions are timed with, for tests.
+func (s *UserService) SetClock(now func() time.Time) {
+	s.now = now
 }
 
-func (s *UserService) GetUserByName(username string) (*models.User, error) {
-	return s.storage.GetByName(username)
+// SetLogger sets where the errors of the background work go, nowhere by
+// default.
+func (s *UserService) SetLogger(logger *zap.Logger) {
+	s.logger = logger
 }
 
-func (s *UserService) UpdateUser(user *models.User) error {
-	return s.storage.Update(user)
+func (s *UserService) CreateUser(ctx context.Context, user *models.User) error {
+	return s.storage.Insert(ctx, user)
 }
 
-func (s *UserService) GetUserById(id int64) (*models.User, error) {
-	return s.storage.Get(id)
+func (s *UserService) GetUserByName(ctx context.Context, username string) (*models.User, error) {
+	return s.storage.GetByName(ctx, username)
 }
 
-func (s *UserService) DeleteUser(id int64) error {
-	return s.storage.Delete(id)
+// UpdateUser stores the changes to a user. A password that was just set
+// logs the user out everywhere.
+func (s *UserService) UpdateUser(ctx context.Context, user *models.User) error {
+	if err := s.storage.Update(ctx, user); err != nil {
+		return err
+	}
+	if user.Password.Plaintext != nil {
+		_, err := s.EndSessions(ctx, user.ID)
+		return err
+	}
+	return nil
 }
 
-func (s *UserService) ListUsers(filters filters.Filters) ([]*models.User, filters.Metadata, error) {
-	return s.storage.GetAll(filters)
+// SetRole changes what a user may do. A demoted user is logged out
+// everywhere, so tokens issued for the old role stop working right away.
+func (s *UserService) SetRole(ctx context.Context, user *models.User, role string) error {
+	previous := user.Role
+	user.Role = role
+	if err := s.storage.Update(ctx, user); err != nil {
+		return err
+	}
+	if role != previous && models.RoleIncludes(previous, role) {
+		_, err := s.EndSessions(ctx, user.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *UserService) GetUserById(ctx context.Context, id int64) (*models.User, error) {
+	return s.storage.Get(ctx, id)
+}
+
+func (s *UserService) DeleteUser(ctx context.Context, id int64) error {
+	if err := s.storage.Delete(ctx, id); err != nil {
+		return err
+	}
+	_, err := s.EndSessions(ctx, id)
+	return err
+}
+
+func (s *UserService) ListUsers(ctx context.Context, filters filters.Filters) ([]*models.User, filters.Metadata, error) {
+	return s.storage.GetAll(ctx, filters)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -84,6 +183,8 @@ func ValidateUser(v *validator.Validator, user *models.User) {
 
 	ValidateEmail(v, user.Email)
 
+	v.Check(user.Role == "" || validator.PermittedValue(user.Role, models.UserRoles...), "role", "must be one of member, librarian or admin")
+
 	if user.Password.Plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.Plaintext)
 	}
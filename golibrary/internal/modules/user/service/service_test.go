@@ -1,48 +1,91 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"test/internal/db/memory"
 	filter "test/internal/infrastructure/filters"
+	"test/internal/infrastructure/mailer"
 	"test/internal/models"
 	"testing"
+	"time"
 )
 
 type MockStorage struct {
 }
 
-func (m *MockStorage) Get(id int64) (*models.User, error) {
+func (m *MockStorage) Get(ctx context.Context, id int64) (*models.User, error) {
 	return &models.User{}, nil
 }
 
-func (m *MockStorage) GetByName(email string) (*models.User, error) {
+func (m *MockStorage) GetByName(ctx context.Context, email string) (*models.User, error) {
 	return &models.User{}, nil
 }
 
-func (m *MockStorage) GetAll(filters filter.Filters) ([]*models.User, filter.Metadata, error) {
+func (m *MockStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return &models.User{}, nil
+}
+
+func (m *MockStorage) GetAll(ctx context.Context, filters filter.Filters) ([]*models.User, filter.Metadata, error) {
 	return []*models.User{}, filter.Metadata{}, nil
 }
 
-func (m *MockStorage) Insert(user *models.User) error {
+func (m *MockStorage) Insert(ctx context.Context, user *models.User) error {
+	return nil
+}
+
+func (m *MockStorage) Update(ctx context.Context, user *models.User) error {
+	return nil
+}
+
+func (m *MockStorage) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (m *MockStorage) InsertSession(ctx context.Context, session *models.Session) error {
+	return nil
+}
+
+func (m *MockStorage) GetSession(ctx context.Context, id int64) (*models.Session, error) {
+	return &models.Session{}, nil
+}
+
+func (m *MockStorage) GetSessionByToken(ctx context.Context, hash string) (*models.Session, error) {
+	return &models.Session{}, nil
+}
+
+func (m *MockStorage) RotateSession(ctx context.Context, session *models.Session, hash string, expiresAt time.Time) error {
 	return nil
 }
 
-func (m *MockStorage) Update(user *models.User) error {
+func (m *MockStorage) RevokeSession(ctx context.Context, id int64, at time.Time) error {
 	return nil
 }
 
-func (m *MockStorage) Delete(id int64) error {
+func (m *MockStorage) RevokeSessions(ctx context.Context, userID int64, at time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *MockStorage) InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error {
 	return nil
 }
 
+func (m *MockStorage) UsePasswordReset(ctx context.Context, hash string, passwordHash []byte, at time.Time) (*models.PasswordReset, error) {
+	return &models.PasswordReset{}, nil
+}
+
 func TestUserService(t *testing.T) {
+	ctx := context.Background()
 	mockStorage := MockStorage{}
-	userService := NewUserService(&mockStorage)
+	userService := NewUserService(&mockStorage, Policy{SessionTTL: time.Hour}, nil)
 	t.Run("ListUsers", func(t *testing.T) {
-		resp, _, _ := userService.ListUsers(filter.Filters{})
+		resp, _, _ := userService.ListUsers(ctx, filter.Filters{})
 		fmt.Println(resp)
 	})
 	t.Run("Get users by name", func(t *testing.T) {
-		resp, _ := userService.GetUserByName("")
+		resp, _ := userService.GetUserByName(ctx, "")
 		if resp == nil {
 			t.Errorf("expected error got nil")
 		}
@@ -50,14 +93,14 @@ func TestUserService(t *testing.T) {
 	})
 
 	t.Run("Get users by ID", func(t *testing.T) {
-		resp, _ := userService.GetUserById(0)
+		resp, _ := userService.GetUserById(ctx, 0)
 		if resp == nil {
 			t.Errorf("expected error got nil")
 		}
 
 	})
 	t.Run("Create", func(t *testing.T) {
-		resp := userService.CreateUser(&models.User{})
+		resp := userService.CreateUser(ctx, &models.User{})
 		if resp != nil {
 			t.Errorf("expected error got nil")
 		}
@@ -65,7 +108,7 @@ func TestUserService(t *testing.T) {
 	})
 
 	t.Run("Update", func(t *testing.T) {
-		resp := userService.UpdateUser(&models.User{})
+		resp := userService.UpdateUser(ctx, &models.User{})
 		if resp != nil {
 			t.Errorf("expected error got nil")
 		}
@@ -73,7 +116,7 @@ func TestUserService(t *testing.T) {
 	})
 
 	t.Run("Delete", func(t *testing.T) {
-		resp := userService.DeleteUser(0)
+		resp := userService.DeleteUser(ctx, 0)
 		if resp != nil {
 			t.Errorf("expected error got nil")
 		}
@@ -81,3 +124,336 @@ func TestUserService(t *testing.T) {
 	})
 
 }
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.NewUserStorage(memory.New())
+	user := &models.User{Name: "carl", Email: "carl@example.com", Password: models.Password{Hash: []byte("hash")}}
+	if err := storage.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	userService := NewUserService(storage, Policy{SessionTTL: time.Hour}, nil)
+	now := time.Now()
+	userService.SetClock(func() time.Time { return now })
+
+	session, first, err := userService.StartSession(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		got, refreshed, second, err := userService.RefreshSession(ctx, first)
+		if err != nil || got.ID != user.ID || refreshed.ID != session.ID || second == first {
+			t.Fatalf("unexpected refresh %+v %+v %q (%v)", got, refreshed, second, err)
+		}
+		if _, _, _, err := userService.RefreshSession(ctx, "made up"); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("expected %v for an unknown token, got %v", ErrInvalidSession, err)
+		}
+
+		// the replaced token was copied, the session ends for both copies
+		if _, _, _, err := userService.RefreshSession(ctx, first); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("expected %v for a reused token, got %v", ErrInvalidSession, err)
+		}
+		if _, _, _, err := userService.RefreshSession(ctx, second); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("expected %v once the token was reused, got %v", ErrInvalidSession, err)
+		}
+		if active, _ := userService.SessionActive(ctx, session.ID); active {
+			t.Error("expected the session to have ended")
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		session, token, err := userService.StartSession(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(2 * time.Hour)
+		if active, _ := userService.SessionActive(ctx, session.ID); active {
+			t.Error("expected an expired session")
+		}
+		if _, _, _, err := userService.RefreshSession(ctx, token); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("expected %v, got %v", ErrInvalidSession, err)
+		}
+	})
+
+	t.Run("password change", func(t *testing.T) {
+		session, _, err := userService.StartSession(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Name = "cj"
+		if err := userService.UpdateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := userService.SessionActive(ctx, session.ID); !active {
+			t.Error("expected other changes to keep the session")
+		}
+
+		if err := user.Password.Set("grove street"); err != nil {
+			t.Fatal(err)
+		}
+		if err := userService.UpdateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := userService.SessionActive(ctx, session.ID); active {
+			t.Error("expected a new password to end the session")
+		}
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.NewUserStorage(memory.New())
+	for _, name := range []string{"carl", "sweet"} {
+		user := &models.User{Name: name, Email: name + "@example.com"}
+		if err := user.Password.Set("grove street"); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userService := NewUserService(storage, Policy{MaxLoginFailures: 3, MaxAddressFailures: 5, LockoutPeriod: time.Minute}, nil)
+	now := time.Now()
+	userService.SetClock(func() time.Time { return now })
+
+	login := func(name, password, address string) error {
+		_, err := userService.Login(ctx, name, password, address)
+		return err
+	}
+
+	t.Run("account", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := login("carl", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("expected %v, got %v", ErrInvalidCredentials, err)
+			}
+		}
+		// a success forgets the failures of the account
+		if err := login("carl", "grove street", "10.0.0.2"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			login("carl", "wrong", "10.0.0.2")
+		}
+		var locked *LockoutError
+		if err := login("carl", "wrong", "10.0.0.3"); !errors.As(err, &locked) || !locked.Until.Equal(now.Add(time.Minute)) {
+			t.Fatalf("expected a lockout for a minute, got %v", err)
+		}
+		if err := login("carl", "grove street", "10.0.0.4"); !errors.Is(err, ErrLockedOut) {
+			t.Errorf("expected %v from any address, got %v", ErrLockedOut, err)
+		}
+		if err := login("sweet", "grove street", "10.0.0.4"); err != nil {
+			t.Errorf("expected other accounts to log in, got %v", err)
+		}
+
+		now = now.Add(time.Minute)
+		if err := login("carl", "grove street", "10.0.0.4"); err != nil {
+			t.Errorf("expected the lockout to run out, got %v", err)
+		}
+	})
+
+	t.Run("address", func(t *testing.T) {
+		// unknown names count against the address as well
+		for i := 0; i < 4; i++ {
+			if err := login(fmt.Sprintf("user%d", i), "wrong", "10.0.0.9"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("expected %v, got %v", ErrInvalidCredentials, err)
+			}
+		}
+		if err := login("sweet", "grove street", "10.0.0.9"); err != nil {
+			t.Fatal(err)
+		}
+		if err := login("user4", "wrong", "10.0.0.9"); !errors.Is(err, ErrLockedOut) {
+			t.Fatalf("expected the address to be locked, got %v", err)
+		}
+		if err := login("sweet", "grove street", "10.0.0.9"); !errors.Is(err, ErrLockedOut) {
+			t.Errorf("expected %v from the address, got %v", ErrLockedOut, err)
+		}
+
+		// unknown names aren't tracked as accounts
+		lockouts := userService.Lockouts(ctx)
+		if len(lockouts) != 1 || lockouts[0].Address != "10.0.0.9" || lockouts[0].LockedUntil == nil || lockouts[0].Failures != 5 {
+			t.Errorf("expected only the address among the lockouts, got %+v", lockouts)
+		}
+		if userService.Unlock(ctx, "nobody") {
+			t.Error("expected nothing to unlock")
+		}
+
+		now = now.Add(time.Minute)
+		if lockouts := userService.Lockouts(ctx); len(lockouts) != 0 {
+			t.Errorf("expected the failures to run out, got %+v", lockouts)
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		results := make(chan error, 20)
+		for range cap(results) {
+			go func() {
+				_, err := userService.Login(ctx, "sweet", "wrong", "10.0.1.1")
+				results <- err
+			}()
+		}
+		guesses := 0
+		for range cap(results) {
+			err := <-results
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				guesses++
+			case !errors.Is(err, ErrLockedOut):
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if guesses != 2 {
+			t.Errorf("expected the lockout to leave 2 wrong guesses of a parallel burst, got %d", guesses)
+		}
+
+		now = now.Add(time.Minute)
+		if swept, _ := userService.ExpireLockouts(ctx); swept != 2 {
+			t.Errorf("expected the account and the address to be swept, got %d", swept)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		unlimited := NewUserService(storage, Policy{LockoutPeriod: time.Minute}, nil)
+		for i := 0; i < 25; i++ {
+			if _, err := unlimited.Login(ctx, "carl", "wrong", "10.0.0.10"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("expected no lockout without limits, got %v", err)
+			}
+		}
+		if _, err := unlimited.Login(ctx, "carl", "grove street", "10.0.0.10"); err != nil {
+			t.Errorf("expected the login to go through, got %v", err)
+		}
+	})
+}
+
+// outbox keeps the mail instead of sending it.
+type outbox []mailer.Message
+
+func (o *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	*o = append(*o, msg)
+	return nil
+}
+
+// mailedToken picks the reset token out of a mail.
+func mailedToken(t *testing.T, msg mailer.Message) string {
+	_, rest, ok := strings.Cut(msg.Body, "token=")
+	if !ok {
+		t.Fatalf("no link with a token in %q", msg.Body)
+	}
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.NewUserStorage(memory.New())
+	user := &models.User{Name: "carl", Email: "carl@example.com"}
+	if err := user.Password.Set("old password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	mail := &outbox{}
+	userService := NewUserService(storage, Policy{
+		SessionTTL:       time.Hour,
+		MaxLoginFailures: 1,
+		LockoutPeriod:    time.Minute,
+		ResetTTL:         time.Hour,
+		ResetURL:         "https://library.example/reset",
+		MailFrom:         "desk@library.example",
+	}, mail)
+	now := time.Now()
+	userService.SetClock(func() time.Time { return now })
+
+	t.Run("unknown email", func(t *testing.T) {
+		if err := userService.RequestPasswordReset(ctx, "nobody@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		userService.WaitForMail()
+		if len(*mail) != 0 {
+			t.Errorf("expected no mail, got %+v", *mail)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		session, _, err := userService.StartSession(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := userService.Login(ctx, "carl", "guessed", "10.0.0.1"); !errors.Is(err, ErrLockedOut) {
+			t.Fatalf("expected the account to be locked out, got %v", err)
+		}
+
+		if err := userService.RequestPasswordReset(ctx, "CARL@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		userService.WaitForMail()
+		if len(*mail) != 1 || (*mail)[0].To != user.Email || (*mail)[0].From != "desk@library.example" {
+			t.Fatalf("expected one mail to carl, got %+v", *mail)
+		}
+		token := mailedToken(t, (*mail)[0])
+
+		if err := userService.ResetPassword(ctx, "made up", "new password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected %v for an unknown token, got %v", ErrInvalidResetToken, err)
+		}
+		if err := userService.ResetPassword(ctx, token, "new password"); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := userService.SessionActive(ctx, session.ID); active {
+			t.Error("expected the reset to end the session")
+		}
+		if _, err := userService.Login(ctx, "carl", "new password", "10.0.0.1"); err != nil {
+			t.Errorf("expected the new password to log in, got %v", err)
+		}
+		if err := userService.ResetPassword(ctx, token, "third password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected %v for a used token, got %v", ErrInvalidResetToken, err)
+		}
+	})
+
+	t.Run("one token spends the others", func(t *testing.T) {
+		*mail = nil
+		for range 2 {
+			if err := userService.RequestPasswordReset(ctx, user.Email, "10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+			userService.WaitForMail()
+		}
+		if err := userService.ResetPassword(ctx, mailedToken(t, (*mail)[1]), "newer password"); err != nil {
+			t.Fatal(err)
+		}
+		if err := userService.ResetPassword(ctx, mailedToken(t, (*mail)[0]), "older password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected %v for the earlier token, got %v", ErrInvalidResetToken, err)
+		}
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		*mail = nil
+		err := userService.RequestPasswordReset(ctx, user.Email, "10.0.0.2")
+		if !errors.Is(err, ErrTooManyResets) {
+			t.Fatalf("expected %v for the fourth request, got %v", ErrTooManyResets, err)
+		}
+		if err := userService.RequestPasswordReset(ctx, "dana@example.com", "10.0.0.2"); err != nil {
+			t.Errorf("expected another email to be answered, got %v", err)
+		}
+		userService.WaitForMail()
+		if len(*mail) != 0 {
+			t.Errorf("expected no mail, got %+v", *mail)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		*mail = nil
+		now = now.Add(resetWindow)
+		if err := userService.RequestPasswordReset(ctx, user.Email, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		userService.WaitForMail()
+		now = now.Add(2 * time.Hour)
+		if err := userService.ResetPassword(ctx, mailedToken(t, (*mail)[0]), "late password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected %v for an expired token, got %v", ErrInvalidResetToken, err)
+		}
+	})
+}
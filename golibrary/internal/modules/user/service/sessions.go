@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"test/internal/models"
+	"test/internal/modules/user/repository"
+)
+
+// ErrInvalidSession is returned for refresh tokens that are unknown, expired,
+// revoked or already used.
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// newToken makes up a random token to hand out and the hash to store in its
+// place.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession logs a user in and returns the session with its refresh
+// token.
+func (s *UserService) StartSession(ctx context.Context, userID int64) (*models.Session, string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := s.now().UTC()
+	session := &models.Session{UserID: userID, TokenHash: hash, CreatedAt: now, ExpiresAt: now.Add(s.policy.SessionTTL)}
+	if err := s.storage.InsertSession(ctx, session); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// RefreshSession trades a refresh token for a new one, the old one can't be
+// used again, and returns the user of the session as they are now.
+// Presenting a token that was already traded means it was copied, the
+// session is ended so neither copy works anymore.
+func (s *UserService) RefreshSession(ctx context.Context, token string) (*models.User, *models.Session, string, error) {
+	session, err := s.storage.GetSessionByToken(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, nil, "", ErrInvalidSession
+		}
+		return nil, nil, "", err
+	}
+
+	now := s.now().UTC()
+	if session.TokenHash != hashToken(token) {
+		if err := s.storage.RevokeSession(ctx, session.ID, now); err != nil {
+			return nil, nil, "", err
+		}
+		return nil, nil, "", ErrInvalidSession
+	}
+	if !session.Active(now) {
+		return nil, nil, "", ErrInvalidSession
+	}
+
+	user, err := s.storage.Get(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, nil, "", ErrInvalidSession
+		}
+		return nil, nil, "", err
+	}
+
+	next, hash, err := newToken()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := s.storage.RotateSession(ctx, session, hash, now.Add(s.policy.SessionTTL)); err != nil {
+		if errors.Is(err, repository.ErrEditConflict) {
+			return nil, nil, "", ErrInvalidSession
+		}
+		return nil, nil, "", err
+	}
+	return user, session, next, nil
+}
+
+// EndSession logs a session out.
+func (s *UserService) EndSession(ctx context.Context, id int64) error {
+	return s.storage.RevokeSession(ctx, id, s.now().UTC())
+}
+
+// EndSessions logs a user out everywhere and returns how many sessions were
+// ended.
+func (s *UserService) EndSessions(ctx context.Context, userID int64) (int, error) {
+	return s.storage.RevokeSessions(ctx, userID, s.now().UTC())
+}
+
+// SessionActive reports whether the session an access token was issued for
+// is still going.
+func (s *UserService) SessionActive(ctx context.Context, id int64) (bool, error) {
+	session, err := s.storage.GetSession(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return session.Active(s.now().UTC()), nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"test/internal/models"
+)
+
+// ErrLockedOut is returned for logins of an account or from an address that
+// failed too often, whatever the password.
+var ErrLockedOut = errors.New("too many failed logins, try again later")
+
+// LockoutError is a request refused until Until, it matches its Reason with
+// errors.Is.
+type LockoutError struct {
+	Until time.Time
+	// Reason is what was throttled, ErrLockedOut for logins when nil
+	Reason error
+}
+
+func (e *LockoutError) reason() error {
+	if e.Reason == nil {
+		return ErrLockedOut
+	}
+	return e.Reason
+}
+
+func (e *LockoutError) Error() string {
+	return e.reason().Error()
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == e.reason()
+}
+
+// failures are the failed logins of an account or an address in the period
+// that started with the first of them.
+type failures struct {
+	count       int
+	since       time.Time
+	lockedUntil time.Time
+}
+
+// throttle counts failed logins per account and per client address in
+// memory, a restart forgets them and sweep drops the ones that ran out. Reaching the limit within the period locks
+// the account or the address out for a period, a limit of 0 never does.
+type throttle struct {
+	mu         sync.Mutex
+	accounts   map[string]*failures
+	addresses  map[string]*failures
+	maxAccount int
+	maxAddress int
+	period     time.Duration
+}
+
+func newThrottle(maxAccount, maxAddress int, period time.Duration) *throttle {
+	return &throttle{
+		accounts:   make(map[string]*failures),
+		addresses:  make(map[string]*failures),
+		maxAccount: maxAccount,
+		maxAddress: maxAddress,
+		period:     period,
+	}
+}
+
+// current drops the failures whose period and lockout are over and returns
+// those that still count.
+func (t *throttle) current(table map[string]*failures, key string, now time.Time) *failures {
+	f, ok := table[key]
+	if ok && !now.Before(f.since.Add(t.period)) && !now.Before(f.lockedUntil) {
+		delete(table, key)
+		return nil
+	}
+	return f
+}
+
+// attempt counts a login of the account from the address before its
+// password is checked, so logins sent in parallel can't all get past the
+// limit. A login that is locked out isn't counted and gets until when,
+// false. A counted one gets the lockout it started, the zero time when it
+// started none. An empty account counts against the address alone.
+func (t *throttle) attempt(account, address string, now time.Time) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var until time.Time
+	for _, f := range []*failures{t.current(t.accounts, account, now), t.current(t.addresses, address, now)} {
+		if f != nil && now.Before(f.lockedUntil) && f.lockedUntil.After(until) {
+			until = f.lockedUntil
+		}
+	}
+	if !until.IsZero() {
+		return until, false
+	}
+
+	count := func(table map[string]*failures, key string, max int) {
+		f := t.current(table, key, now)
+		if f == nil {
+			f = &failures{since: now}
+			table[key] = f
+		}
+		f.count++
+		if max > 0 && f.count >= max {
+			f.lockedUntil = now.Add(t.period)
+			until = f.lockedUntil
+		}
+	}
+	if account != "" {
+		count(t.accounts, account, t.maxAccount)
+	}
+	count(t.addresses, address, t.maxAddress)
+
+	return until, true
+}
+
+// succeed takes back a login attempt that had the right password: the
+// failures of the account are forgotten, the attempt is taken off those of
+// the address. The other failures of the address stay, a login to another
+// account must not reset them.
+func (t *throttle) succeed(account, address string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.accounts, account)
+	f := t.current(t.addresses, address, now)
+	if f == nil {
+		return
+	}
+	f.count--
+	switch {
+	case f.count <= 0:
+		delete(t.addresses, address)
+	case t.maxAddress <= 0 || f.count < t.maxAddress:
+		f.lockedUntil = time.Time{}
+	}
+}
+
+// sweep forgets the failures that no longer count and returns how many
+// accounts and addresses it dropped.
+func (t *throttle) sweep(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	swept := 0
+	for _, table := range []map[string]*failures{t.accounts, t.addresses} {
+		for key := range table {
+			if t.current(table, key, now) == nil {
+				swept++
+			}
+		}
+	}
+	return swept
+}
+
+// unlock forgets the failures of an account and reports whether it had any.
+func (t *throttle) unlock(account string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.accounts[account]
+	delete(t.accounts, account)
+	return ok
+}
+
+// lockouts lists the accounts and the addresses with failures that still
+// count, the locked ones first.
+func (t *throttle) lockouts(now time.Time) []*models.Lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lockouts := []*models.Lockout{}
+	add := func(table map[string]*failures, lockout func(key string) *models.Lockout) {
+		for key := range table {
+			f := t.current(table, key, now)
+			if f == nil {
+				continue
+			}
+			l := lockout(key)
+			l.Failures = f.count
+			l.Since = f.since
+			if now.Before(f.lockedUntil) {
+				lockedUntil := f.lockedUntil
+				l.LockedUntil = &lockedUntil
+			}
+			lockouts = append(lockouts, l)
+		}
+	}
+	add(t.accounts, func(key string) *models.Lockout { return &models.Lockout{Account: key} })
+	add(t.addresses, func(key string) *models.Lockout { return &models.Lockout{Address: key} })
+
+	slices.SortFunc(lockouts, func(a, b *models.Lockout) int {
+		if c := cmp.Compare(lockedUntil(b), lockedUntil(a)); c != 0 {
+			return c
+		}
+		return a.Since.Compare(b.Since)
+	})
+	return lockouts
+}
+
+// lockedUntil is the sort key of a lockout, 0 when it isn't locked.
+func lockedUntil(l *models.Lockout) int64 {
+	if l.LockedUntil == nil {
+		return 0
+	}
+	return l.LockedUntil.UnixNano()
+}
@@ -7,64 +7,96 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 
+	"test/internal/infrastructure/auth"
 	"test/internal/infrastructure/components"
+	"test/internal/models"
 	"test/internal/modules"
 	swagger "test/static"
-
-	"test/internal/infrastructure/helpers"
-
-	"github.com/go-chi/jwtauth/v5"
-	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
-func Routes(ctrl *modules.Controllers, comp *components.Components) *chi.Mux {
+// Routes wires the handlers, sessions tells the guard which logins are still
+// going.
+func Routes(ctrl *modules.Controllers, comp *components.Components, sessions auth.Sessions) *chi.Mux {
 	r := chi.NewRouter()
 
+	guard := auth.New(comp.Tokens, sessions, comp.Responder)
+
+	r.Get("/.well-known/jwks.json", guard.JWKS) // public keys the tokens are signed with
+
+	// the catalog is public
+	r.Get("/books/listBooks", ctrl.BookHandler.ListBooks)     //list books with authors
+	r.Get("/books/search", ctrl.BookHandler.SearchBooks)      // titles, contributors and descriptions, best match first
+	r.Get("/books/listAuthors", ctrl.BookHandler.ListAuthors) //list authors with books
+
+	r.Get("/books/rate", ctrl.BookHandler.ListTopRatedAuthors) // authors whose books were rented the most in a window
+	r.Get("/books/popular", ctrl.BookHandler.ListPopularBooks) // books rented the most in a window
+
+	r.Get("/books/{bookID}/copies", ctrl.BookHandler.ListCopies) // copies with their status
+
+	r.Post("/user", ctrl.UserHandler.CreateUser)
+	r.Post("/user/login", ctrl.UserHandler.Login)
+	r.Post("/user/refresh", ctrl.UserHandler.Refresh)                      // new tokens for a refresh token
+	r.Post("/user/password-reset", ctrl.UserHandler.RequestPasswordReset)  // mail a reset token
+	r.Post("/user/password-reset/confirm", ctrl.UserHandler.ResetPassword) // new password for a reset token
+
+	// members act for themselves, the handlers let librarians act for anybody
 	r.Group(func(r chi.Router) {
+		r.Use(guard.Authenticate)
+
+		r.Patch("/books/rent/{bookID}", ctrl.BookHandler.RentBook)     //	creates new record in junction rable
+		r.Patch("/books/return/{bookID}", ctrl.BookHandler.ReturnBook) // erases record in junction rable
 
-		r.Use(jwtauth.Verifier(helpers.TokenAuth))
-		r.Use(func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				token, _, err := jwtauth.FromContext(r.Context())
-
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusForbidden)
-					return
-				}
-				if token == nil {
-					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-					return
-				} else if jwt.Validate(token) != nil {
-					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-					return
-				}
-
-				next.ServeHTTP(w, r)
-			})
-		})
+		r.Post("/books/{bookID}/holds", ctrl.BookHandler.PlaceHold) // join the queue of a book that is out
 
+		r.Post("/user/logout", ctrl.UserHandler.Logout) // ends the caller's session
+		r.Get("/user/{username}", ctrl.UserHandler.GetUserByName)
+		r.Put("/user/{username}", ctrl.UserHandler.UpdateUser)
+		r.Delete("/user/{username}", ctrl.UserHandler.DeleteUser)
 	})
 
-	r.Post("/books/book", ctrl.BookHandler.CreateBook)
-	r.Post("/books/author", ctrl.BookHandler.CreateAuthor)
+	// a member's own records, librarians see everybody's
+	r.Group(func(r chi.Router) {
+		r.Use(guard.Authenticate)
+		r.Use(guard.OwnerOrRole("userID", models.RoleLibrarian))
 
-	r.Patch("/books/rent/{bookID}", ctrl.BookHandler.RentBook)     //	creates new record in junction rable
-	r.Patch("/books/return/{bookID}", ctrl.BookHandler.ReturnBook) // erases record in junction rable
+		r.Get("/books/users/{userID}/rentals", ctrl.BookHandler.ListUserRentals)      // current and past loans of a user
+		r.Get("/books/users/{userID}/holds", ctrl.BookHandler.ListUserHolds)          // holds of a user with queue positions
+		r.Delete("/books/users/{userID}/holds/{holdID}", ctrl.BookHandler.CancelHold) // leave a queue
+		r.Get("/books/users/{userID}/fines", ctrl.BookHandler.ListFines)              // balance and ledger
+	})
 
-	r.Get("/books/listUsers", ctrl.BookHandler.ListUsers)     //list users with rented books
-	r.Get("/books/listBooks", ctrl.BookHandler.ListBooks)     //list books with authors
-	r.Get("/books/listAuthors", ctrl.BookHandler.ListAuthors) //list authors with books
+	// the catalog and the desk
+	r.Group(func(r chi.Router) {
+		r.Use(guard.Authenticate)
+		r.Use(guard.RequireRole(models.RoleLibrarian))
 
-	r.Get("/books/rate", ctrl.BookHandler.ListTopRatedAuthors)
+		r.Post("/books/book", ctrl.BookHandler.CreateBook)
+		r.Post("/books/author", ctrl.BookHandler.CreateAuthor)
 
-	r.Post("/user", ctrl.UserHandler.CreateUser)
-	r.Get("/user/login", ctrl.UserHandler.Login)
-	r.Get("/user/logout", ctrl.UserHandler.Logout)
-	r.Get("/user/{username}", ctrl.UserHandler.GetUserByName)
-	r.Put("/user/{username}", ctrl.UserHandler.UpdateUser)
-	r.Delete("/user/{username}", ctrl.UserHandler.DeleteUser)
-	r.Post("/user/CreateWithList", ctrl.UserHandler.CreateWithList)
-	r.Post("/user/CreateWithArray", ctrl.UserHandler.CreateWithArray)
+		r.Get("/books/listUsers", ctrl.BookHandler.ListUsers) //list users with rented books
+
+		r.Get("/books/{bookID}/rentals", ctrl.BookHandler.ListBookRentals) // who had the book, latest first
+		r.Get("/books/{bookID}/holds", ctrl.BookHandler.ListBookHolds)     // the queue, next patron first
+
+		r.Post("/books/{bookID}/copies", ctrl.BookHandler.AddCopy)     // register a physical copy
+		r.Patch("/books/copies/{copyID}", ctrl.BookHandler.UpdateCopy) // mark lost, damaged, in repair or available
+
+		r.Post("/books/users/{userID}/fines/payments", ctrl.BookHandler.PayFine)  // record a payment
+		r.Post("/books/users/{userID}/fines/waivers", ctrl.BookHandler.WaiveFine) // librarians forgive charges
+	})
+
+	// the accounts
+	r.Group(func(r chi.Router) {
+		r.Use(guard.Authenticate)
+		r.Use(guard.RequireRole(models.RoleAdmin))
+
+		r.Put("/user/{username}/role", ctrl.UserHandler.SetRole)
+		r.Delete("/user/{username}/sessions", ctrl.UserHandler.RevokeSessions) // log a user out everywhere
+		r.Get("/user/lockouts", ctrl.UserHandler.ListLockouts)                 // failed logins by account and address
+		r.Delete("/user/{username}/lockout", ctrl.UserHandler.Unlock)          // lift an account's lockout
+		r.Post("/user/CreateWithList", ctrl.UserHandler.CreateWithList)
+		r.Post("/user/CreateWithArray", ctrl.UserHandler.CreateWithArray)
+	})
 
 	fileServer := http.FileServerFS(swagger.Swaggerfile)
 	r.Get("/swagger/*", func(w http.ResponseWriter, r *http.Request) {
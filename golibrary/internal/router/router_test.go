@@ -1,12 +1,21 @@
 package router
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"test/config"
+	"test/internal/infrastructure/auth"
 	"test/internal/infrastructure/components"
+	"test/internal/infrastructure/filters"
 	"test/internal/infrastructure/responder"
+	"test/internal/models"
 	"test/internal/modules"
+	book_service "test/internal/modules/books/service"
+	user_service "test/internal/modules/user/service"
 	"testing"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/ptflp/godecoder"
@@ -21,11 +30,11 @@ func TestRouter(t *testing.T) {
 	decoder := godecoder.NewDecoder(jsoniter.Config{})
 	responseManager := responder.NewResponder(decoder, logger)
 
-	components := components.NewComponents(responseManager, decoder, logger, nil)
-	storages := modules.NewStorages(nil, nil)
-	services := modules.NewServices(components, storages)
+	components := components.NewComponents(responseManager, decoder, logger, nil, nil, nil)
+	storages := modules.NewStorages(nil, nil, 0)
+	services := modules.NewServices(components, storages, book_service.Policy{}, user_service.Policy{SessionTTL: time.Hour})
 	ctrl := modules.NewControllers(services, components)
-	r := Routes(ctrl, components)
+	r := Routes(ctrl, components, services.UserService)
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/users/login", nil)
 	req.URL.RawQuery = "username=1&password=1"
@@ -35,3 +44,77 @@ func TestRouter(t *testing.T) {
 		t.Errorf("expected status code %d, got %d", http.StatusNotFound, w.Code)
 	}
 }
+
+func TestRouterAccess(t *testing.T) {
+	decoder := godecoder.NewDecoder(jsoniter.Config{})
+	tokens, err := auth.NewTokens(config.NewConfig(config.WithTokenAlgorithm("EdDSA")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	components := components.NewComponents(responder.NewResponder(decoder, zap.NewNop()), decoder, zap.NewNop(), nil, tokens, nil)
+	storages := modules.NewMemoryStorages()
+	services := modules.NewServices(components, storages, book_service.Policy{}, user_service.Policy{SessionTTL: time.Hour})
+	r := Routes(modules.NewControllers(services, components), components, services.UserService)
+
+	bearer := func(id int64, role string) string {
+		session, _, err := services.UserService.StartSession(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := tokens.Issue(&models.User{ID: id, Name: "carl", Role: role}, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	// an admin that was logged out everywhere
+	loggedOut := bearer(2, models.RoleAdmin)
+	if _, err := services.UserService.EndSessions(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// only requests the guards turn away, the others would reach the storages
+	for _, tc := range []struct {
+		method, path, token string
+		code                int
+	}{
+		{"POST", "/books/book", "", http.StatusUnauthorized},
+		{"POST", "/books/book", bearer(1, models.RoleMember), http.StatusForbidden},
+		{"POST", "/books/author", bearer(1, models.RoleMember), http.StatusForbidden},
+		{"PATCH", "/books/rent/1", "", http.StatusUnauthorized},
+		{"PATCH", "/books/rent/1?userID=2", bearer(1, models.RoleMember), http.StatusForbidden},
+		{"GET", "/books/users/2/fines", bearer(1, models.RoleMember), http.StatusForbidden},
+		{"GET", "/books/listUsers", bearer(1, models.RoleMember), http.StatusForbidden},
+		{"DELETE", "/user/carl", "", http.StatusUnauthorized},
+		{"PUT", "/user/carl/role", bearer(1, models.RoleLibrarian), http.StatusForbidden},
+		{"DELETE", "/user/carl/sessions", bearer(1, models.RoleLibrarian), http.StatusForbidden},
+		{"POST", "/user/logout", "", http.StatusUnauthorized},
+		{"POST", "/user/refresh", "", http.StatusUnauthorized},
+		{"POST", "/user/password-reset/confirm", "", http.StatusBadRequest},
+		{"POST", "/books/book", loggedOut, http.StatusUnauthorized},
+		{"GET", "/.well-known/jwks.json", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", tc.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.path, tc.code, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if !strings.Contains(w.Body.String(), `"kty":"OKP"`) {
+		t.Errorf("expected the public signing key, got %s", w.Body.String())
+	}
+
+	// a cursor made for the sort but with a key missing reaches the storage
+	cursor := filters.Cursor{Sort: "-year", ID: 1}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/books/listBooks?sort=-year&cursor="+cursor.String(), nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "cursor") {
+		t.Errorf("expected a 400 for the cursor, got %d %s", w.Code, w.Body.String())
+	}
+}
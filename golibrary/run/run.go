@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"test/config"
 	"test/internal/db"
+	"test/internal/infrastructure/auth"
+	"test/internal/infrastructure/mailer"
 	"test/internal/infrastructure/responder"
 	"test/internal/modules"
+	book_service "test/internal/modules/books/service"
+	user_service "test/internal/modules/user/service"
 	"test/internal/router"
 	"time"
 
@@ -40,12 +45,23 @@ type App struct {
 	logger   *zap.Logger
 	server   *http.Server
 	services *modules.Services
+	// baseCtx is the parent of every request context, it is cancelled once
+	// the graceful shutdown gives up so in-flight queries stop as well
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+	// overdueInterval is how often fines of overdue rentals are accrued,
+	// holdInterval how often holds not picked up are expired
+	overdueInterval time.Duration
+	holdInterval    time.Duration
 }
 
 func NewApp(conf *config.Config, logger *zap.Logger) *App {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
 	return &App{
-		cfg:    conf,
-		logger: logger}
+		cfg:        conf,
+		logger:     logger,
+		baseCtx:    baseCtx,
+		cancelBase: cancelBase}
 }
 
 func (app *App) Serve() error {
@@ -62,9 +78,17 @@ func (app *App) Serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		shutdownErr <- app.server.Shutdown(ctx)
+		err := app.server.Shutdown(ctx)
+		app.cancelBase()
+		// the reset mails of answered requests still go out
+		app.services.UserService.WaitForMail()
+		shutdownErr <- err
 	}()
 
+	go app.every(app.overdueInterval, "accrued fines", app.services.BookService.AccrueFines)
+	go app.every(app.holdInterval, "expired holds", app.services.BookService.ExpireHolds)
+	go app.every(time.Minute, "expired failed logins", app.services.UserService.ExpireLockouts)
+
 	app.logger.Info("starting server", zap.String("addr", app.server.Addr))
 
 	err := app.server.ListenAndServe()
@@ -89,19 +113,148 @@ func (a *App) Run() {
 	if err != nil {
 		a.logger.Fatal("error init db", zap.Error(err))
 	}
-	components := components.NewComponents(responseManager, decoder, a.logger, dbx)
-	storages := modules.NewStorages(dbx, a.logger)
-	services := modules.NewServices(components, storages)
+	if a.cfg.Db.AutoMigrate && dbx != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := db.Migrate(ctx, dbx); err != nil {
+			a.logger.Fatal("error applying migrations", zap.Error(err))
+		}
+	}
+	tokens, err := auth.NewTokens(a.cfg)
+	if err != nil {
+		a.logger.Fatal("error loading token keys", zap.Error(err))
+	}
+	if tokens.Generated() {
+		a.logger.Warn("no token signing key is configured, signing with a temporary one that is lost on restart")
+	}
+	components := components.NewComponents(responseManager, decoder, a.logger, dbx, tokens, mailer.NewFileMailer(a.cfg.Mail.File))
+	queryTimeout, err := time.ParseDuration(a.cfg.Db.QueryTimeout)
+	if err != nil {
+		a.logger.Fatal("error parsing query timeout", zap.Error(err))
+	}
+
+	var storages *modules.Storages
+	switch a.cfg.Db.DBname {
+	case "memory":
+		storages = modules.NewMemoryStorages()
+	default:
+		storages = modules.NewStorages(dbx, a.logger, queryTimeout)
+	}
+	policy, err := Policy(a.cfg)
+	if err != nil {
+		a.logger.Fatal("error parsing library settings", zap.Error(err))
+	}
+	overdueInterval, err := time.ParseDuration(a.cfg.Library.OverdueCheckInterval)
+	if err != nil || overdueInterval <= 0 {
+		a.logger.Fatal("error parsing overdue check interval", zap.Error(err))
+	}
+	holdInterval, err := time.ParseDuration(a.cfg.Library.HoldCheckInterval)
+	if err != nil || holdInterval <= 0 {
+		a.logger.Fatal("error parsing hold check interval", zap.Error(err))
+	}
+
+	userPolicy, err := UserPolicy(a.cfg)
+	if err != nil {
+		a.logger.Fatal("error parsing login settings", zap.Error(err))
+	}
+
+	services := modules.NewServices(components, storages, policy, userPolicy)
 	a.services = services
+	a.overdueInterval = overdueInterval
+	a.holdInterval = holdInterval
 	controllers := modules.NewControllers(services, components)
 
-	r := router.Routes(controllers, components)
+	r := router.Routes(controllers, components, services.UserService)
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%v", a.cfg.Port),
 		Handler: r,
+		BaseContext: func(net.Listener) context.Context {
+			return a.baseCtx
+		},
 	}
 
 	a.server = server
 
 }
+
+// every runs job every interval until the app shuts down and logs how many
+// rows it changed under done.
+func (a *App) every(interval time.Duration, done string, job func(context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := job(a.baseCtx)
+		if err != nil && a.baseCtx.Err() == nil {
+			a.logger.Error("error in background job", zap.String("job", done), zap.Error(err))
+		}
+		if n > 0 {
+			a.logger.Info(done, zap.Int("count", n))
+		}
+
+		select {
+		case <-a.baseCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Policy reads the circulation rules from the library settings.
+func Policy(cfg *config.Config) (book_service.Policy, error) {
+	loanPeriod, err := time.ParseDuration(cfg.Library.LoanPeriod)
+	if err != nil {
+		return book_service.Policy{}, fmt.Errorf("loan period: %w", err)
+	}
+	fineGrace, err := time.ParseDuration(cfg.Library.FineGracePeriod)
+	if err != nil {
+		return book_service.Policy{}, fmt.Errorf("fine grace period: %w", err)
+	}
+	pickupWindow, err := time.ParseDuration(cfg.Library.PickupWindow)
+	if err != nil {
+		return book_service.Policy{}, fmt.Errorf("pickup window: %w", err)
+	}
+
+	return book_service.Policy{
+		LoanPeriod:    loanPeriod,
+		DailyFine:     int64(cfg.Library.DailyFine),
+		FineGrace:     fineGrace,
+		FineCap:       int64(cfg.Library.FineCap),
+		FineThreshold: int64(cfg.Library.FineThreshold),
+		PickupWindow:  pickupWindow,
+	}, nil
+}
+
+// UserPolicy reads the session, login and password reset rules from the
+// auth and mail settings.
+func UserPolicy(cfg *config.Config) (user_service.Policy, error) {
+	sessionTTL, err := time.ParseDuration(cfg.Auth.SessionTTL)
+	if err != nil {
+		return user_service.Policy{}, fmt.Errorf("session ttl: %w", err)
+	}
+	if sessionTTL <= 0 {
+		return user_service.Policy{}, fmt.Errorf("session ttl %q must be positive", cfg.Auth.SessionTTL)
+	}
+	lockoutPeriod, err := time.ParseDuration(cfg.Auth.LockoutPeriod)
+	if err != nil {
+		return user_service.Policy{}, fmt.Errorf("lockout period: %w", err)
+	}
+	resetTTL, err := time.ParseDuration(cfg.Auth.ResetTTL)
+	if err != nil {
+		return user_service.Policy{}, fmt.Errorf("password reset ttl: %w", err)
+	}
+	if resetTTL <= 0 {
+		return user_service.Policy{}, fmt.Errorf("password reset ttl %q must be positive", cfg.Auth.ResetTTL)
+	}
+
+	return user_service.Policy{
+		SessionTTL:         sessionTTL,
+		MaxLoginFailures:   cfg.Auth.MaxLoginFailures,
+		MaxAddressFailures: cfg.Auth.MaxAddressFailures,
+		LockoutPeriod:      lockoutPeriod,
+		ResetTTL:           resetTTL,
+		ResetURL:           cfg.Auth.ResetURL,
+		MailFrom:           cfg.Mail.From,
+	}, nil
+}